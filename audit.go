@@ -0,0 +1,72 @@
+package dam
+
+/**
+ * 审计字段
+ * 操作人经 context 传递：ctx = dam.WithActor(ctx, adminName)
+ * Repository 与 Query 的写操作据此填充 Model 的 create_time/update_time/update_user
+ */
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+/** 未设置操作人时的默认值，与表结构中 update_user 的默认值一致 **/
+const DefaultActor = "system"
+
+type actorKey struct{}
+
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return DefaultActor
+}
+
+/**
+ * 审计时间，timestamp 列精度为秒
+ */
+func auditTime() time.Time {
+	return timeNow().Truncate(time.Second)
+}
+
+/**
+ * 在更新列中补充 update_time 与 update_user，不覆盖调用方显式指定的值
+ */
+func withAuditColumns(ctx context.Context, set map[string]interface{}) map[string]interface{} {
+	audited := make(map[string]interface{}, len(set)+2)
+	for column, value := range set {
+		audited[column] = value
+	}
+	if _, ok := audited[columnUpdateTime]; !ok {
+		audited[columnUpdateTime] = auditTime()
+	}
+	if _, ok := audited[columnUpdateUser]; !ok {
+		audited[columnUpdateUser] = ActorFromContext(ctx)
+	}
+	return audited
+}
+
+/**
+ * 结构体中嵌入的 Model 字段路径
+ */
+func modelFieldIndex(typ reflect.Type) []int {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.Anonymous || field.Type.Kind() != reflect.Struct {
+			continue
+		}
+		if field.Type == modelType {
+			return []int{i}
+		}
+		if index := modelFieldIndex(field.Type); index != nil {
+			return append([]int{i}, index...)
+		}
+	}
+	return nil
+}
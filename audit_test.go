@@ -0,0 +1,55 @@
+package dam
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestActorFromContext(t *testing.T) {
+	if actor := ActorFromContext(context.Background()); actor != DefaultActor {
+		t.Errorf("aspect actor %s, but get %s", DefaultActor, actor)
+	}
+	if actor := ActorFromContext(WithActor(context.Background(), "admin")); actor != "admin" {
+		t.Errorf("aspect actor admin, but get %s", actor)
+	}
+}
+
+func TestWithAuditColumns(t *testing.T) {
+	now := time.Date(2020, 3, 1, 12, 30, 15, 999, time.Local)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	ctx := WithActor(context.Background(), "admin")
+	set := map[string]interface{}{"alias_name": "a"}
+	audited := withAuditColumns(ctx, set)
+	want := map[string]interface{}{"alias_name": "a", columnUpdateTime: now.Truncate(time.Second), columnUpdateUser: "admin"}
+	if !reflect.DeepEqual(audited, want) {
+		t.Errorf("aspect %v, but get %v", want, audited)
+	}
+	if len(set) != 1 {
+		t.Errorf("withAuditColumns must not modify the given set, get %v", set)
+	}
+
+	explicit := withAuditColumns(ctx, map[string]interface{}{columnUpdateUser: "importer"})
+	if explicit[columnUpdateUser] != "importer" {
+		t.Errorf("aspect explicit update_user kept, but get %v", explicit[columnUpdateUser])
+	}
+}
+
+func TestModelFieldIndex(t *testing.T) {
+	type nested struct {
+		User
+		Extra string `db:"extra"`
+	}
+	if index := modelFieldIndex(reflect.TypeOf(User{})); !reflect.DeepEqual(index, []int{0}) {
+		t.Errorf("aspect [0], but get %v", index)
+	}
+	if index := modelFieldIndex(reflect.TypeOf(nested{})); !reflect.DeepEqual(index, []int{0, 0}) {
+		t.Errorf("aspect [0 0], but get %v", index)
+	}
+	if index := modelFieldIndex(reflect.TypeOf(Order{})); index != nil {
+		t.Errorf("aspect nil, but get %v", index)
+	}
+}
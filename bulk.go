@@ -0,0 +1,254 @@
+package dam
+
+/**
+ * 分库批量插入
+ * 按分库键分组，每个分库拼接多行 insert，单条语句不超过 MaxPacketBytes 与 MaxRows，
+ * 各分库并行写入；某个分块因约束或数据错误失败时逐行重试以定位失败的行，
+ * 连接、超时等与具体行无关的错误不逐行重试，整块记为失败
+ *   result, err := users.BulkInsert(ctx, users, dam.BulkInsertOptions{})
+ *   for _, failure := range result.Failed { ... }
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+const (
+	/** mysql 5.7 max_allowed_packet 默认值 **/
+	defaultBulkMaxPacketBytes = 4 << 20
+	defaultBulkMaxRows        = 1000
+	/** 非字符串参数在协议中的估算长度 **/
+	bulkArgBytes = 16
+	/** 单条预处理语句的占位符上限 **/
+	maxPlaceholders = 65535
+)
+
+type BulkInsertOptions struct {
+	/** 单条语句估算字节上限，需不大于服务端 max_allowed_packet，默认4MB **/
+	MaxPacketBytes int
+	/** 单条语句行数上限，默认1000 **/
+	MaxRows int
+	/** 同时写入的分库数，默认不限 **/
+	Parallelism int
+}
+
+type BulkInsertFailure struct {
+	/** 在入参切片中的下标 **/
+	Index int
+	/** 无法路由时为 -1 **/
+	ShardId int
+	Err     error
+}
+
+type BulkInsertResult struct {
+	Inserted int64
+	/** 按下标排序 **/
+	Failed []BulkInsertFailure
+}
+
+/**
+ * entities 为实体结构体切片或实体指针切片，主键为零值时生成id并回写
+ * 只有参数错误时返回 error，行级失败记录在 Failed 中，其余行照常写入
+ */
+func (this *Repository) BulkInsert(ctx context.Context, entities interface{}, options BulkInsertOptions) (*BulkInsertResult, error) {
+	values, err := this.entityValues(entities)
+	if err != nil {
+		return nil, err
+	}
+	if options.MaxPacketBytes <= 0 {
+		options.MaxPacketBytes = defaultBulkMaxPacketBytes
+	}
+	if options.MaxRows <= 0 {
+		options.MaxRows = defaultBulkMaxRows
+	}
+	result := &BulkInsertResult{}
+	if err := this.generateIds(values); err != nil {
+		return nil, fmt.Errorf("repository %s: %w", this.config.Table, err)
+	}
+
+	now := auditTime()
+	shards := make(map[*ShardDB][]int)
+	for i, value := range values {
+		db, err := this.Db(value.FieldByIndex(this.shardKey.index).Interface())
+		if err != nil {
+			result.Failed = append(result.Failed, BulkInsertFailure{Index: i, ShardId: -1, Err: err})
+			continue
+		}
+		this.fillInsertAudit(ctx, value, now)
+		shards[db] = append(shards[db], i)
+	}
+
+	var (
+		mutex sync.Mutex
+		wg    sync.WaitGroup
+		slots chan struct{}
+	)
+	if options.Parallelism > 0 {
+		slots = make(chan struct{}, options.Parallelism)
+	}
+	for db, rows := range shards {
+		wg.Add(1)
+		go func(db *ShardDB, rows []int) {
+			defer wg.Done()
+			if slots != nil {
+				slots <- struct{}{}
+				defer func() { <-slots }()
+			}
+			inserted, failed := this.bulkInsertShard(ctx, db, values, rows, options)
+			mutex.Lock()
+			result.Inserted += inserted
+			result.Failed = append(result.Failed, failed...)
+			mutex.Unlock()
+		}(db, rows)
+	}
+	wg.Wait()
+	sort.Slice(result.Failed, func(i, j int) bool { return result.Failed[i].Index < result.Failed[j].Index })
+	return result, nil
+}
+
+/**
+ * 切片 => 可寻址的实体结构体 reflect.Value
+ */
+func (this *Repository) entityValues(entities interface{}) ([]reflect.Value, error) {
+	slice := reflect.ValueOf(entities)
+	if slice.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("repository %s: entities must be a slice, get %T", this.config.Table, entities)
+	}
+	values := make([]reflect.Value, slice.Len())
+	for i := range values {
+		value := slice.Index(i)
+		if value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return nil, fmt.Errorf("repository %s: entity %d is nil", this.config.Table, i)
+			}
+			value = value.Elem()
+		}
+		if value.Type() != this.typ {
+			return nil, fmt.Errorf("repository %s: entities must be []%s or []*%s, get %T", this.config.Table, this.typ, this.typ, entities)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+/**
+ * 为主键为零值的实体生成id，默认雪花id时批量生成
+ */
+func (this *Repository) generateIds(values []reflect.Value) error {
+	var missing []reflect.Value
+	for _, value := range values {
+		if id := value.FieldByIndex(this.id.index); isZero(id) {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	switch missing[0].Kind() {
+	case reflect.Int, reflect.Int64, reflect.Uint64:
+		if this.config.IdGenerator != nil {
+			break
+		}
+		ids, err := this.manager.GenerateIds(len(missing))
+		if err != nil {
+			return err
+		}
+		for i, id := range missing {
+			setIntValue(id, ids[i])
+		}
+		return nil
+	}
+	generator := this.idGenerator()
+	for _, id := range missing {
+		if err := setGeneratedId(id, generator); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * 分块写入一个分库
+ */
+func (this *Repository) bulkInsertShard(ctx context.Context, db *ShardDB, values []reflect.Value, rows []int, options BulkInsertOptions) (inserted int64, failed []BulkInsertFailure) {
+	for _, chunk := range this.bulkChunks(values, rows, options) {
+		if ctx.Err() != nil {
+			for _, row := range chunk {
+				failed = append(failed, BulkInsertFailure{Index: row, ShardId: db.ShardId(), Err: ctx.Err()})
+			}
+			continue
+		}
+		args := make([]interface{}, 0, len(chunk)*len(this.columns))
+		for _, row := range chunk {
+			args = append(args, this.insertArgs(values[row])...)
+		}
+		_, err := db.ExecContext(ctx, this.insertSQL(len(chunk)), args...)
+		if err == nil {
+			inserted += int64(len(chunk))
+			continue
+		}
+		if !errors.Is(err, ErrConstraint) && !errors.Is(err, ErrData) {
+			for _, row := range chunk {
+				failed = append(failed, BulkInsertFailure{Index: row, ShardId: db.ShardId(), Err: err})
+			}
+			continue
+		}
+		/** 约束或数据错误，逐行写入以定位失败行 **/
+		for _, row := range chunk {
+			if _, err := db.ExecContext(ctx, this.insertSQL(1), this.insertArgs(values[row])...); err != nil {
+				failed = append(failed, BulkInsertFailure{Index: row, ShardId: db.ShardId(), Err: err})
+				continue
+			}
+			inserted++
+		}
+	}
+	return inserted, failed
+}
+
+/**
+ * 按行数、估算字节数与预处理语句占位符上限(65535)分块
+ */
+func (this *Repository) bulkChunks(values []reflect.Value, rows []int, options BulkInsertOptions) (chunks [][]int) {
+	maxRows := options.MaxRows
+	if limit := maxPlaceholders / len(this.columns); maxRows > limit {
+		maxRows = limit
+	}
+	baseBytes := len(this.insertSQL(0))
+	for start := 0; start < len(rows); {
+		end, size := start, baseBytes
+		for end < len(rows) && end-start < maxRows {
+			rowBytes := bulkRowBytes(this.insertArgs(values[rows[end]]))
+			if end > start && size+rowBytes > options.MaxPacketBytes {
+				break
+			}
+			size += rowBytes
+			end++
+		}
+		chunks = append(chunks, rows[start:end])
+		start = end
+	}
+	return chunks
+}
+
+/**
+ * 一行的估算字节数：占位符与参数长度
+ */
+func bulkRowBytes(args []interface{}) int {
+	size := 4 + 3*len(args)
+	for _, arg := range args {
+		switch value := arg.(type) {
+		case string:
+			size += len(value)
+		case []byte:
+			size += len(value)
+		default:
+			size += bulkArgBytes
+		}
+	}
+	return size
+}
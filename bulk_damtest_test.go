@@ -0,0 +1,85 @@
+package dam_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	dam "github.com/seanbit/godam"
+	"github.com/seanbit/godam/damtest"
+)
+
+const userSchema = `
+CREATE TABLE user (
+user_id BIGINT NOT NULL,
+user_name char(255) DEFAULT NULL,
+password char(255) DEFAULT NULL,
+alias_name char(255) DEFAULT NULL,
+enabled INT DEFAULT 1,
+create_time timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+update_time timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+update_user char(255) DEFAULT 'system',
+delete_time BIGINT NOT NULL DEFAULT 0,
+PRIMARY KEY (user_id),
+UNIQUE KEY user_name(user_name,delete_time)
+);`
+
+type user struct {
+	dam.Model
+	UserId    int    `db:"user_id"`
+	UserName  string `db:"user_name"`
+	Password  string `db:"password"`
+	AliasName string `db:"alias_name"`
+	Enabled   int    `db:"enabled"`
+}
+
+func newUserRepository(t *testing.T, config dam.MysqlConfig) (*damtest.Harness, *dam.Repository) {
+	h := damtest.NewWithConfig(2, config)
+	t.Cleanup(func() { h.Close() })
+	if err := h.LoadSchema(userSchema); err != nil {
+		t.Fatal(err)
+	}
+	return h, dam.NewRepository(h.Manager, user{}, dam.RepositoryConfig{Table: "user", IdColumn: "user_id", ShardKeyColumn: "user_name"})
+}
+
+func TestBulkInsertRowFallback(t *testing.T) {
+	h, repository := newUserRepository(t, dam.MysqlConfig{})
+	ctx := context.Background()
+	/** 10086、10088 在分库0，10087 在分库1；第三行与第一行用户名重复 **/
+	users := []*user{{UserName: "10086"}, {UserName: "10088"}, {UserName: "10086"}, {UserName: "10087"}}
+	result, err := repository.BulkInsert(ctx, users, dam.BulkInsertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inserted != 3 || len(result.Failed) != 1 || result.Failed[0].Index != 2 || !errors.Is(result.Failed[0].Err, dam.ErrDuplicateKey) {
+		t.Errorf("aspect duplicate row 2 to fail alone, but get %d inserted, %+v", result.Inserted, result.Failed)
+	}
+	/** 分库0 一次批量写入失败后逐行写入3次 **/
+	if inserts := len(h.Queries(0)); inserts != 4 {
+		t.Errorf("aspect 4 inserts on shard 0, but get %d", inserts)
+	}
+}
+
+func TestBulkInsertNoFallbackOnTransientError(t *testing.T) {
+	h, repository := newUserRepository(t, dam.MysqlConfig{})
+	ctx := context.Background()
+	timeout := &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}
+	h.Expect(0, `^insert into user`, damtest.Expectation{Err: timeout})
+	users := []*user{{UserName: "10086"}, {UserName: "10088"}, {UserName: "10087"}}
+	result, err := repository.BulkInsert(ctx, users, dam.BulkInsertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inserted != 1 || len(result.Failed) != 2 {
+		t.Fatalf("aspect shard 0 chunk to fail, but get %d inserted, %+v", result.Inserted, result.Failed)
+	}
+	for _, failure := range result.Failed {
+		if failure.ShardId != 0 || !errors.Is(failure.Err, dam.ErrLockWaitTimeout) || !errors.Is(failure.Err, timeout) {
+			t.Errorf("aspect lock wait timeout as is, but get %+v", failure)
+		}
+	}
+	if inserts := len(h.Queries(0)); inserts != 1 {
+		t.Errorf("aspect no row by row retry on shard 0, but get %d inserts", inserts)
+	}
+}
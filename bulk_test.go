@@ -0,0 +1,66 @@
+package dam
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRepositoryInsertSQL(t *testing.T) {
+	repository := NewRepository(nil, &User{}, RepositoryConfig{Table: "user", IdColumn: "user_id", ShardKeyColumn: "user_name"})
+	want := "insert into user(create_time, update_time, update_user, delete_time, user_id, user_name, password, alias_name, enabled)values" +
+		"(?, ?, ?, ?, ?, ?, ?, ?, ?), (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	if query := repository.insertSQL(2); query != want {
+		t.Errorf("aspect %s, but get %s", want, query)
+	}
+}
+
+func TestRepositoryEntityValues(t *testing.T) {
+	repository := NewRepository(nil, &User{}, RepositoryConfig{Table: "user", IdColumn: "user_id", ShardKeyColumn: "user_name"})
+	users := []User{{UserName: "a"}, {UserName: "b"}}
+	values, err := repository.entityValues(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values[1].FieldByIndex(repository.id.index).SetInt(7)
+	if users[1].UserId != 7 {
+		t.Error("aspect entity values to be addressable")
+	}
+	if _, err := repository.entityValues([]*User{{}, nil}); err == nil {
+		t.Error("aspect error for nil entity")
+	}
+	if _, err := repository.entityValues([]string{"a"}); err == nil {
+		t.Error("aspect error for wrong element type")
+	}
+	if _, err := repository.entityValues(&User{}); err == nil {
+		t.Error("aspect error for non-slice")
+	}
+}
+
+func TestRepositoryBulkChunks(t *testing.T) {
+	repository := NewRepository(nil, &User{}, RepositoryConfig{Table: "user", IdColumn: "user_id", ShardKeyColumn: "user_name"})
+	users := make([]User, 10)
+	for i := range users {
+		users[i].Password = strings.Repeat("x", 100)
+	}
+	values, _ := repository.entityValues(users)
+	rows := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+
+	chunks := repository.bulkChunks(values, rows, BulkInsertOptions{MaxRows: 4, MaxPacketBytes: defaultBulkMaxPacketBytes})
+	if want := [][]int{{0, 1, 2, 3}, {4, 5, 6, 7}, {8, 9}}; !reflect.DeepEqual(chunks, want) {
+		t.Errorf("aspect %v, but get %v", want, chunks)
+	}
+
+	rowBytes := bulkRowBytes(repository.insertArgs(values[0]))
+	maxBytes := len(repository.insertSQL(0)) + 3*rowBytes
+	chunks = repository.bulkChunks(values, rows, BulkInsertOptions{MaxRows: 100, MaxPacketBytes: maxBytes})
+	if want := [][]int{{0, 1, 2}, {3, 4, 5}, {6, 7, 8}, {9}}; !reflect.DeepEqual(chunks, want) {
+		t.Errorf("aspect %v, but get %v", want, chunks)
+	}
+
+	/** 单行超过上限时仍单独成块 **/
+	chunks = repository.bulkChunks(values, rows[:2], BulkInsertOptions{MaxRows: 100, MaxPacketBytes: 1})
+	if want := [][]int{{0}, {1}}; !reflect.DeepEqual(chunks, want) {
+		t.Errorf("aspect %v, but get %v", want, chunks)
+	}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	dam "github.com/seanbit/godam"
+)
+
+func runRoute(ctx context.Context, env *env, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: route <key>")
+	}
+	dna, err := dam.Dna(args[0])
+	if err != nil {
+		return err
+	}
+	manager, err := env.mysql()
+	if err != nil {
+		return err
+	}
+	db, err := manager.GetDbByUserName(args[0])
+	if err != nil {
+		return err
+	}
+	tw := env.newTabWriter()
+	fmt.Fprintln(tw, "KEY\tDNA\tSHARD\tHOST")
+	fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", args[0], dna, db.ShardId(), db.Host())
+	return tw.Flush()
+}
+
+func runPing(ctx context.Context, env *env, args []string) error {
+	failed := false
+	tw := env.newTabWriter()
+	fmt.Fprintln(tw, "TARGET\tHOST\tLATENCY\tERROR")
+	if env.config.Mysql != nil {
+		manager, err := env.mysql()
+		if err != nil {
+			return err
+		}
+		for _, db := range sortedDbs(manager) {
+			start := time.Now()
+			err := db.PingContext(ctx)
+			failed = failed || err != nil
+			fmt.Fprintf(tw, "shard %d\t%s\t%s\t%s\n", db.ShardId(), db.Host(), time.Since(start).Round(time.Microsecond), errorText(err))
+		}
+	}
+	if env.config.Redis != nil {
+		start := time.Now()
+		client, err := env.redisClient()
+		if err == nil {
+			err = client.Ping().Err()
+		}
+		failed = failed || err != nil
+		fmt.Fprintf(tw, "redis\t%s\t%s\t%s\n", env.config.Redis.Endpoint(), time.Since(start).Round(time.Microsecond), errorText(err))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if failed {
+		return errCheckFailed
+	}
+	return nil
+}
+
+func runStats(ctx context.Context, env *env, args []string) error {
+	if env.config.Mysql != nil {
+		manager, err := env.mysql()
+		if err != nil {
+			return err
+		}
+		tw := env.newTabWriter()
+		fmt.Fprintln(tw, "SHARD\tHOST\tMAX_OPEN\tOPEN\tIN_USE\tIDLE\tWAIT_COUNT\tWAIT_DURATION")
+		for _, db := range sortedDbs(manager) {
+			// 先建立连接，否则新进程中的连接池为空
+			_ = db.PingContext(ctx)
+			stats := db.Stats()
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%d\t%d\t%d\t%s\n", db.ShardId(), db.Host(), stats.MaxOpenConnections,
+				stats.OpenConnections, stats.InUse, stats.Idle, stats.WaitCount, stats.WaitDuration)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if env.config.Redis != nil {
+		client, err := env.redisClient()
+		if err != nil {
+			return err
+		}
+		_ = client.Ping().Err()
+		pooled, ok := client.(interface{ PoolStats() *redis.PoolStats })
+		if !ok {
+			return fmt.Errorf("redis client %T does not report pool stats", client)
+		}
+		stats := pooled.PoolStats()
+		tw := env.newTabWriter()
+		fmt.Fprintln(tw, "\nREDIS\tHITS\tMISSES\tTIMEOUTS\tTOTAL\tIDLE\tSTALE")
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\n", env.config.Redis.Endpoint(), stats.Hits, stats.Misses, stats.Timeouts,
+			stats.TotalConns, stats.IdleConns, stats.StaleConns)
+		return tw.Flush()
+	}
+	return nil
+}
+
+func runMigrate(ctx context.Context, env *env, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dir := flags.String("dir", "migrations", "directory of <version>_<name>.up.sql / .down.sql files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: migrate [-dir migrations] up|down [n]|status")
+	}
+	migrations, err := dam.LoadMigrations(*dir)
+	if err != nil {
+		return err
+	}
+	manager, err := env.mysql()
+	if err != nil {
+		return err
+	}
+	migrator := dam.NewMigrator(manager, migrations, env.logger)
+
+	var results []dam.MigrationResult
+	switch flags.Arg(0) {
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if writeErr := dam.WriteMigrationStatus(env.out, statuses); writeErr != nil {
+			return writeErr
+		}
+		return err
+	case "up":
+		results, err = migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if flags.NArg() > 1 {
+			if steps, err = strconv.Atoi(flags.Arg(1)); err != nil || steps <= 0 {
+				return fmt.Errorf("invalid steps %q", flags.Arg(1))
+			}
+		}
+		results, err = migrator.Down(ctx, steps)
+	default:
+		return fmt.Errorf("unknown migrate action %q", flags.Arg(0))
+	}
+
+	tw := env.newTabWriter()
+	fmt.Fprintln(tw, "SHARD\tVERSIONS\tERROR")
+	for _, result := range results {
+		versions := make([]string, 0, len(result.Versions))
+		for _, version := range result.Versions {
+			versions = append(versions, strconv.FormatInt(version, 10))
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", result.ShardId, strings.Join(versions, ","), errorText(result.Err))
+	}
+	if writeErr := tw.Flush(); writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return errCheckFailed
+	}
+	return nil
+}
+
+func runExec(ctx context.Context, env *env, args []string) error {
+	flags := flag.NewFlagSet("exec", flag.ContinueOnError)
+	allShards := flags.Bool("all-shards", false, "run on every shard")
+	key := flags.String("key", "", "run on the shard of this shard key")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || *allShards == (*key != "") {
+		return errors.New("usage: exec -all-shards|-key <key> <sql>")
+	}
+	query := flags.Arg(0)
+	manager, err := env.mysql()
+	if err != nil {
+		return err
+	}
+	var dbs []*dam.ShardDB
+	if *allShards {
+		dbs = sortedDbs(manager)
+	} else {
+		db, err := manager.GetDbByUserName(*key)
+		if err != nil {
+			return err
+		}
+		dbs = []*dam.ShardDB{db}
+	}
+
+	failed := false
+	for _, db := range dbs {
+		fmt.Fprintf(env.out, "-- shard %d (%s)\n", db.ShardId(), db.Host())
+		if err := execOnShard(ctx, env, db, query); err != nil {
+			failed = true
+			fmt.Fprintf(env.out, "error: %v\n", err)
+		}
+	}
+	if failed {
+		return errCheckFailed
+	}
+	return nil
+}
+
+func execOnShard(ctx context.Context, env *env, db *dam.ShardDB, query string) error {
+	if !returnsRows(query) {
+		result, err := db.ExecContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(env.out, "%d row(s) affected\n", affected)
+		return nil
+	}
+
+	rows, err := db.QueryxContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	tw := env.newTabWriter()
+	fmt.Fprintln(tw, strings.Join(columns, "\t"))
+	count := 0
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return err
+		}
+		cells := make([]string, len(values))
+		for i, value := range values {
+			switch v := value.(type) {
+			case nil:
+				cells[i] = "NULL"
+			case []byte:
+				cells[i] = string(v)
+			default:
+				cells[i] = fmt.Sprint(v)
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(env.out, "%d row(s)\n", count)
+	return nil
+}
+
+func returnsRows(query string) bool {
+	fields := strings.Fields(dam.Fingerprint(query))
+	if len(fields) == 0 {
+		return false
+	}
+	switch fields[0] {
+	case "select", "show", "desc", "describe", "explain", "with":
+		return true
+	}
+	return false
+}
+
+func runLocks(ctx context.Context, env *env, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: locks <prefix>")
+	}
+	client, err := env.redisClient()
+	if err != nil {
+		return err
+	}
+	locks, err := listLocks(client, args[0])
+	if err != nil {
+		return err
+	}
+	tw := env.newTabWriter()
+	fmt.Fprintln(tw, "KEY\tTTL")
+	for _, lock := range locks {
+		fmt.Fprintf(tw, "%s\t%s\n", lock.key, lock.ttlText())
+	}
+	return tw.Flush()
+}
+
+/** TTL 命令对未设置过期时间的key返回 -1 **/
+const noExpiration time.Duration = -1
+
+type heldLock struct {
+	key string
+	/** noExpiration 表示未设置过期时间，如 TryLock(key, 0) 或丢失过期时间的锁，不会自动释放 **/
+	ttl time.Duration
+}
+
+func (this heldLock) ttlText() string {
+	if this.ttl == noExpiration {
+		return "none"
+	}
+	return this.ttl.String()
+}
+
+/**
+ * 列出 prefix 下的key及剩余过期时间，按key排序
+ */
+func listLocks(client redis.UniversalClient, prefix string) ([]heldLock, error) {
+	var mutex sync.Mutex
+	var locks []heldLock
+	scan := func(node redis.Cmdable) error {
+		found, err := scanLocks(node, prefix)
+		mutex.Lock()
+		locks = append(locks, found...)
+		mutex.Unlock()
+		return err
+	}
+	var err error
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		// 集群模式下 SCAN 只作用于单个节点，需逐个主节点扫描
+		err = cluster.ForEachMaster(func(node *redis.Client) error {
+			return scan(node)
+		})
+	} else {
+		err = scan(client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].key < locks[j].key })
+	return locks, nil
+}
+
+func scanLocks(node redis.Cmdable, prefix string) ([]heldLock, error) {
+	pattern := escapeGlob(prefix) + "*"
+	var locks []heldLock
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(cursor, pattern, 100).Result()
+		if err != nil {
+			return locks, err
+		}
+		for _, key := range keys {
+			ttl, err := node.TTL(key).Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				return locks, err
+			}
+			// -2 扫描期间已释放；-1 未设置过期时间，是不会自动释放的锁，保留
+			if ttl < 0 && ttl != noExpiration {
+				continue
+			}
+			locks = append(locks, heldLock{key: key, ttl: ttl})
+		}
+		if cursor = next; cursor == 0 {
+			return locks, nil
+		}
+	}
+}
+
+/**
+ * 转义 SCAN MATCH 的通配符，使 prefix 按字面匹配
+ */
+func escapeGlob(text string) string {
+	var b strings.Builder
+	for _, c := range text {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func runDrift(ctx context.Context, env *env, args []string) error {
+	flags := flag.NewFlagSet("drift", flag.ContinueOnError)
+	expectedPath := flags.String("expected", "", "expected schema json, defaults to the shard with the lowest id")
+	dump := flags.Bool("dump", false, "print the schema of the shard with the lowest id as json and exit")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	manager, err := env.mysql()
+	if err != nil {
+		return err
+	}
+	if *dump {
+		dbs := sortedDbs(manager)
+		if len(dbs) == 0 {
+			return errors.New("no shard configured")
+		}
+		schema, err := dam.ReadSchema(ctx, dbs[0])
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(env.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(schema)
+	}
+
+	var expected *dam.Schema
+	if *expectedPath != "" {
+		content, err := ioutil.ReadFile(*expectedPath)
+		if err != nil {
+			return err
+		}
+		expected = &dam.Schema{}
+		if err := json.Unmarshal(content, expected); err != nil {
+			return fmt.Errorf("parse %s: %v", *expectedPath, err)
+		}
+	}
+	report, err := dam.CheckDrift(ctx, manager, expected)
+	if err != nil {
+		return err
+	}
+	report.WriteTo(env.out)
+	if report.HasDrift() {
+		return errCheckFailed
+	}
+	return nil
+}
+
+func runDecodeId(ctx context.Context, env *env, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: decode-id <id>...")
+	}
+	tw := env.newTabWriter()
+	fmt.Fprintln(tw, "ID\tTIME\tWORKER\tSEQUENCE")
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid id %q", arg)
+		}
+		info := dam.DecodeId(id)
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\n", info.Id, info.Time.Format("2006-01-02 15:04:05.000 -0700"), info.WorkerId, info.Sequence)
+	}
+	return tw.Flush()
+}
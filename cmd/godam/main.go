@@ -0,0 +1,211 @@
+package main
+
+/**
+ * godam 命令行工具
+ * 读取与服务相同的 MysqlConfig/RedisConfig(json)：
+ *   {"mysql": {...}, "redis": {...}}
+ * 用法: godam -config godam.json <command> [arguments]
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/go-redis/redis/v7"
+	dam "github.com/seanbit/godam"
+)
+
+const (
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+/** 命令已输出结果，但需以非零状态退出，如检查到结构漂移 **/
+var errCheckFailed = errors.New("check failed")
+
+/** 管理器没有原生客户端，如内存实现 **/
+var errRedisClientUnavailable = errors.New("redis client is unavailable")
+
+type config struct {
+	Mysql *dam.MysqlConfig `json:"mysql"`
+	Redis *dam.RedisConfig `json:"redis"`
+}
+
+type command struct {
+	args string
+	help string
+	run  func(ctx context.Context, env *env, args []string) error
+}
+
+var commands = map[string]command{
+	"route":     {"<key>", "show dna, shard and host of a shard key", runRoute},
+	"ping":      {"", "ping every shard and redis", runPing},
+	"stats":     {"", "connection pool stats of every shard and redis", runStats},
+	"migrate":   {"[-dir migrations] up|down [n]|status", "apply, roll back or show migrations on every shard", runMigrate},
+	"exec":      {"-all-shards|-key <key> <sql>", "run sql on every shard or on the shard of a key", runExec},
+	"locks":     {"<prefix>", "list keys under a lock prefix and their ttl, \"none\" for locks without expiry", runLocks},
+	"drift":     {"[-expected schema.json] [-dump]", "compare table structure of every shard, exit 1 on drift", runDrift},
+	"decode-id": {"<id>...", "decode time, worker id and sequence of snowflake ids", runDecodeId},
+}
+
+/** 无需读取配置的命令 **/
+var offlineCommands = map[string]bool{
+	"decode-id": true,
+}
+
+func main() {
+	configPath := flag.String("config", "godam.json", "config file with mysql and redis sections")
+	verbose := flag.Bool("v", false, "log manager events to stderr")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "godam: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	env := &env{out: os.Stdout}
+	if !offlineCommands[flag.Arg(0)] {
+		var err error
+		if env, err = loadEnv(*configPath, *verbose); err != nil {
+			fmt.Fprintln(os.Stderr, "godam:", err)
+			os.Exit(exitFailure)
+		}
+	}
+	if code := execute(context.Background(), env, cmd, flag.Args()[1:]); code != 0 {
+		os.Exit(code)
+	}
+}
+
+/**
+ * 执行命令并返回退出码，errCheckFailed 表示结果已输出，不再打印错误
+ */
+func execute(ctx context.Context, env *env, cmd command, args []string) int {
+	if err := cmd.run(ctx, env, args); err != nil {
+		if err != errCheckFailed {
+			fmt.Fprintln(os.Stderr, "godam:", err)
+		}
+		return exitFailure
+	}
+	return 0
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: godam [-config godam.json] [-v] <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", name, commands[name].args, commands[name].help)
+	}
+	tw.Flush()
+	fmt.Fprintln(os.Stderr, "\nflags:")
+	flag.PrintDefaults()
+}
+
+type env struct {
+	config       config
+	out          io.Writer
+	logger       dam.ILogger
+	mysqlManager dam.IMysqlManager
+	redisManager dam.IRedisManager
+}
+
+func loadEnv(path string, verbose bool) (*env, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg config
+	if err := json.Unmarshal(content, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %v", path, err)
+	}
+	level := dam.LogLevelWarn
+	if verbose {
+		level = dam.LogLevelDebug
+	}
+	return &env{
+		config: cfg,
+		out:    os.Stdout,
+		logger: dam.NewStdLogger(log.New(os.Stderr, "", log.LstdFlags), level),
+	}, nil
+}
+
+/**
+ * 按需打开mysql
+ */
+func (this *env) mysql() (dam.IMysqlManager, error) {
+	if this.mysqlManager != nil {
+		return this.mysqlManager, nil
+	}
+	if this.config.Mysql == nil {
+		return nil, errors.New("config has no mysql section")
+	}
+	mysqlConfig := *this.config.Mysql
+	mysqlConfig.Logger = this.logger
+	this.mysqlManager = dam.NewMysqlManager(mysqlConfig)
+	this.mysqlManager.Open()
+	return this.mysqlManager, nil
+}
+
+/**
+ * 按需创建redis客户端，不做 Open 时的连通性检查，由命令自行处理连接错误
+ */
+func (this *env) redis() (dam.IRedisManager, error) {
+	if this.redisManager != nil {
+		return this.redisManager, nil
+	}
+	if this.config.Redis == nil {
+		return nil, errors.New("config has no redis section")
+	}
+	redisConfig := *this.config.Redis
+	redisConfig.Logger = this.logger
+	this.redisManager = dam.NewRedisManager(redisConfig)
+	return this.redisManager, nil
+}
+
+/**
+ * 原生客户端，管理器不提供时返回 errRedisClientUnavailable
+ */
+func (this *env) redisClient() (redis.UniversalClient, error) {
+	manager, err := this.redis()
+	if err != nil {
+		return nil, err
+	}
+	client := manager.UniversalClient()
+	if client == nil {
+		return nil, errRedisClientUnavailable
+	}
+	return client, nil
+}
+
+func (this *env) newTabWriter() *tabwriter.Writer {
+	return tabwriter.NewWriter(this.out, 0, 4, 2, ' ', 0)
+}
+
+/**
+ * 按数据中心id排序的分库
+ */
+func sortedDbs(manager dam.IMysqlManager) []*dam.ShardDB {
+	dbs := manager.GetAllDbs()
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].ShardId() < dbs[j].ShardId() })
+	return dbs
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	dam "github.com/seanbit/godam"
+	"github.com/seanbit/godam/damtest"
+)
+
+func newTestEnv(t *testing.T) (*env, *bytes.Buffer) {
+	env, buf, _ := newTestHarnessEnv(t)
+	return env, buf
+}
+
+func newTestHarnessEnv(t *testing.T) (*env, *bytes.Buffer, *damtest.Harness) {
+	h := damtest.New(2)
+	t.Cleanup(func() { h.Close() })
+	if err := h.LoadSchema("create table user (user_id bigint not null primary key, user_name char(255) default null)"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	return &env{
+		config:       config{Mysql: &dam.MysqlConfig{}},
+		out:          &buf,
+		logger:       dam.NopLogger(),
+		mysqlManager: h.Manager,
+	}, &buf, h
+}
+
+func TestReturnsRows(t *testing.T) {
+	cases := map[string]bool{
+		"select 1":                             true,
+		"  SELECT * from user":                 true,
+		"/* hint */ select 1":                  true,
+		"show tables":                          true,
+		"desc user":                            true,
+		"explain select * from user":           true,
+		"with t as (select 1) select * from t": true,
+		"update user set enabled=0":            false,
+		"insert into user values (1)":          false,
+		"":                                     false,
+	}
+	for query, want := range cases {
+		if got := returnsRows(query); got != want {
+			t.Errorf("aspect returnsRows(%q) %v, but get %v", query, want, got)
+		}
+	}
+}
+
+func TestCommandUsage(t *testing.T) {
+	env, _ := newTestEnv(t)
+	ctx := context.Background()
+	cases := []struct {
+		name string
+		args []string
+	}{
+		{"route", nil},
+		{"route", []string{"a", "b"}},
+		{"exec", nil},
+		{"exec", []string{"select 1"}},
+		{"exec", []string{"-all-shards", "-key", "10086", "select 1"}},
+		{"exec", []string{"-unknown", "select 1"}},
+		{"migrate", []string{"-dir", t.TempDir()}},
+		{"locks", nil},
+		{"locks", []string{""}},
+		{"locks", []string{"a", "b"}},
+		{"decode-id", nil},
+		{"decode-id", []string{"abc"}},
+	}
+	for _, c := range cases {
+		if err := commands[c.name].run(ctx, env, c.args); err == nil || err == errCheckFailed {
+			t.Errorf("aspect usage error for %s %v, but get %v", c.name, c.args, err)
+		}
+	}
+}
+
+func TestRunRoute(t *testing.T) {
+	env, buf := newTestEnv(t)
+	if err := runRoute(context.Background(), env, []string{"10087"}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !reflect.DeepEqual(strings.Fields(lines[1]), []string{"10087", "10087", "1", "damtest-1"}) {
+		t.Errorf("unexpected route output:\n%s", buf.String())
+	}
+}
+
+func TestRunExec(t *testing.T) {
+	env, buf := newTestEnv(t)
+	ctx := context.Background()
+	if err := runExec(ctx, env, []string{"-key", "10086", "insert into user(user_id, user_name) values (1, '10086')"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "-- shard 0 (damtest-0)\n1 row(s) affected\n"; buf.String() != want {
+		t.Errorf("aspect %q, but get %q", want, buf.String())
+	}
+
+	buf.Reset()
+	if err := runExec(ctx, env, []string{"-all-shards", "select user_id, user_name from user"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "-- shard 0 (damtest-0)\n" +
+		"user_id  user_name\n" +
+		"1        10086\n" +
+		"1 row(s)\n" +
+		"-- shard 1 (damtest-1)\n" +
+		"user_id  user_name\n" +
+		"0 row(s)\n"
+	if buf.String() != want {
+		t.Errorf("aspect %q, but get %q", want, buf.String())
+	}
+
+	buf.Reset()
+	if err := runExec(ctx, env, []string{"-all-shards", "select * from missing"}); err != errCheckFailed {
+		t.Errorf("aspect check failed, but get %v", err)
+	}
+	if strings.Count(buf.String(), "error: ") != 2 {
+		t.Errorf("aspect an error per shard, but get %q", buf.String())
+	}
+}
+
+func TestRunPingAndStats(t *testing.T) {
+	env, buf := newTestEnv(t)
+	ctx := context.Background()
+	if err := runPing(ctx, env, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "shard 0  damtest-0") || !strings.Contains(buf.String(), "shard 1  damtest-1") {
+		t.Errorf("unexpected ping output:\n%s", buf.String())
+	}
+	buf.Reset()
+	if err := runStats(ctx, env, nil); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[0], "SHARD") {
+		t.Errorf("unexpected stats output:\n%s", buf.String())
+	}
+}
+
+func TestRedisClientUnavailable(t *testing.T) {
+	env, buf := newTestEnv(t)
+	env.config.Redis = &dam.RedisConfig{Host: "memory"}
+	env.redisManager = dam.NewMemoryRedisManager()
+	ctx := context.Background()
+	if err := runPing(ctx, env, nil); err != errCheckFailed {
+		t.Errorf("aspect errCheckFailed, but get %v", err)
+	}
+	if !strings.Contains(buf.String(), errRedisClientUnavailable.Error()) {
+		t.Errorf("aspect redis error row, but get:\n%s", buf.String())
+	}
+	if err := runStats(ctx, env, nil); err != errRedisClientUnavailable {
+		t.Errorf("stats: aspect errRedisClientUnavailable, but get %v", err)
+	}
+	if err := runLocks(ctx, env, []string{"lock:"}); err != errRedisClientUnavailable {
+		t.Errorf("locks: aspect errRedisClientUnavailable, but get %v", err)
+	}
+}
+
+/** 客户端不提供 PoolStats **/
+type unpooledRedisManager struct {
+	dam.IRedisManager
+	client redis.UniversalClient
+}
+
+func (this unpooledRedisManager) UniversalClient() redis.UniversalClient {
+	return struct{ redis.UniversalClient }{this.client}
+}
+
+func TestRunStatsWithoutPoolStats(t *testing.T) {
+	env, _ := newTestEnv(t)
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	defer client.Close()
+	env.config.Redis = &dam.RedisConfig{Host: "127.0.0.1:1"}
+	env.redisManager = unpooledRedisManager{IRedisManager: dam.NewMemoryRedisManager(), client: client}
+	if err := runStats(context.Background(), env, nil); err == nil || !strings.Contains(err.Error(), "pool stats") {
+		t.Errorf("aspect pool stats error, but get %v", err)
+	}
+}
+
+/**
+ * 引擎不支持 information_schema，预设 user 表结构；drifted 的分库 user_name 列更长且缺少唯一索引
+ */
+func expectUserSchema(h *damtest.Harness, shardId int, drifted bool) {
+	userName, indexes := "char(255)", [][]interface{}{{"user", "PRIMARY", 0, "user_id"}, {"user", "user_name", 0, "user_name"}}
+	if drifted {
+		userName, indexes = "char(512)", indexes[:1]
+	}
+	h.Expect(shardId, `from information_schema\.tables`, damtest.Expectation{
+		Columns: []string{"table_name", "engine", "table_collation"},
+		Rows:    [][]interface{}{{"user", "InnoDB", "utf8mb4_general_ci"}},
+	})
+	h.Expect(shardId, `from information_schema\.columns`, damtest.Expectation{
+		Columns: []string{"table_name", "column_name", "ordinal_position", "column_type", "is_nullable",
+			"column_default", "character_set_name", "collation_name", "extra"},
+		Rows: [][]interface{}{
+			{"user", "user_id", 1, "bigint", "NO", nil, nil, nil, ""},
+			{"user", "user_name", 2, userName, "YES", nil, "utf8mb4", "utf8mb4_general_ci", ""},
+		},
+	})
+	h.Expect(shardId, `from information_schema\.statistics`, damtest.Expectation{
+		Columns: []string{"table_name", "index_name", "non_unique", "column_name"},
+		Rows:    indexes,
+	})
+}
+
+func TestCheckDrift(t *testing.T) {
+	env, buf, h := newTestHarnessEnv(t)
+	ctx := context.Background()
+	expectUserSchema(h, 0, false)
+	expectUserSchema(h, 1, true)
+	report, err := dam.CheckDrift(ctx, env.mysqlManager, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.HasDrift() || report.ReferenceShard != 0 || len(report.Shards) != 1 || len(report.Shards[0].Differences) != 2 {
+		t.Fatalf("aspect 2 differences on shard 1, but get %+v", report)
+	}
+
+	expectUserSchema(h, 0, false)
+	expectUserSchema(h, 1, true)
+	if code := execute(ctx, env, commands["drift"], nil); code != exitFailure {
+		t.Errorf("aspect exit code %d, but get %d", exitFailure, code)
+	}
+	if !strings.Contains(buf.String(), "char(512)") || !strings.Contains(buf.String(), "user_name") {
+		t.Errorf("aspect drift report, but get:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	expectUserSchema(h, 0, false)
+	expectUserSchema(h, 1, false)
+	if code := execute(ctx, env, commands["drift"], nil); code != 0 {
+		t.Errorf("aspect exit code 0 without drift, but get %d:\n%s", code, buf.String())
+	}
+}
+
+func TestRunDecodeId(t *testing.T) {
+	var buf bytes.Buffer
+	env := &env{out: &buf}
+	at := time.Date(2026, 1, 2, 3, 4, 5, 6e6, time.Local)
+	id := dam.MinIdAt(at)
+	if err := runDecodeId(context.Background(), env, []string{strconv.FormatInt(id, 10)}); err != nil {
+		t.Fatal(err)
+	}
+	want := strconv.FormatInt(id, 10) + "  " + at.Format("2006-01-02 15:04:05.000 -0700") + "  0       0"
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 2 || lines[1] != want {
+		t.Errorf("aspect %q, but get:\n%s", want, buf.String())
+	}
+}
+
+/**
+ * 分两页返回匹配的key，TTL 为 -1 表示未设置过期时间，-2 表示key已被删除
+ */
+type fakeLockNode struct {
+	redis.Cmdable
+	ttls     map[string]time.Duration
+	patterns []string
+}
+
+func (this *fakeLockNode) Scan(cursor uint64, match string, count int64) *redis.ScanCmd {
+	this.patterns = append(this.patterns, match)
+	var keys []string
+	for key := range this.ttls {
+		if ok, _ := path.Match(match, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	half := len(keys) / 2
+	if cursor == 0 {
+		return redis.NewScanCmdResult(keys[:half], 1, nil)
+	}
+	return redis.NewScanCmdResult(keys[half:], 0, nil)
+}
+
+func (this *fakeLockNode) TTL(key string) *redis.DurationCmd {
+	return redis.NewDurationResult(this.ttls[key], nil)
+}
+
+func TestScanLocks(t *testing.T) {
+	node := &fakeLockNode{ttls: map[string]time.Duration{
+		"lock:order:1": time.Minute,
+		"lock:order:2": time.Second,
+		"lock:cache":   -1,
+		"lock:gone":    -2,
+		"lock*:1":      time.Minute,
+		"session:1":    time.Hour,
+	}}
+	locks, err := scanLocks(node, "lock:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]time.Duration)
+	for _, lock := range locks {
+		got[lock.key] = lock.ttl
+	}
+	want := map[string]time.Duration{"lock:order:1": time.Minute, "lock:order:2": time.Second, "lock:cache": noExpiration}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("aspect %v, but get %v", want, got)
+	}
+	if text := (heldLock{key: "lock:cache", ttl: noExpiration}).ttlText(); text != "none" {
+		t.Errorf("aspect ttl none, but get %s", text)
+	}
+	if len(node.patterns) != 2 || node.patterns[0] != "lock:*" {
+		t.Errorf("aspect two scans of lock:*, but get %v", node.patterns)
+	}
+
+	node.patterns = nil
+	if locks, err := scanLocks(node, "lock*"); err != nil || len(locks) != 1 || locks[0].key != "lock*:1" {
+		t.Errorf("aspect literal prefix lock*, but get %v, %v", locks, err)
+	}
+	if node.patterns[0] != `lock\**` {
+		t.Errorf("aspect escaped pattern, but get %v", node.patterns)
+	}
+}
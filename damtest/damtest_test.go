@@ -0,0 +1,335 @@
+package damtest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	dam "github.com/seanbit/godam"
+)
+
+const userSchema = `
+CREATE TABLE IF NOT EXISTS user (
+user_id BIGINT NOT NULL COMMENT '用户ID',
+user_name char(255) DEFAULT NULL COMMENT '用户名',
+password char(255) DEFAULT NULL COMMENT '密码',
+alias_name char(255) DEFAULT NULL COMMENT '用户别名，昵称',
+enabled INT DEFAULT 1 COMMENT '账户是否启用：1，启用；0，禁用；',
+create_time timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+update_time timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
+update_user char(255) DEFAULT 'system' COMMENT '修改这条记录的管理员用户名',
+delete_time BIGINT NOT NULL DEFAULT 0 COMMENT '删除时间',
+PRIMARY KEY (user_id),
+UNIQUE KEY user_name(user_name,delete_time)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+`
+
+type user struct {
+	dam.Model
+	UserId    int    `db:"user_id"`
+	UserName  string `db:"user_name"`
+	Password  string `db:"password"`
+	AliasName string `db:"alias_name"`
+	Enabled   int    `db:"enabled"`
+}
+
+func newUserHarness(t *testing.T) (*Harness, *dam.Repository) {
+	h := New(2)
+	t.Cleanup(func() { h.Close() })
+	if err := h.LoadSchema(userSchema); err != nil {
+		t.Fatal(err)
+	}
+	repository := dam.NewRepository(h.Manager, user{}, dam.RepositoryConfig{Table: "user", IdColumn: "user_id", ShardKeyColumn: "user_name"})
+	return h, repository
+}
+
+func TestRepositoryOnShards(t *testing.T) {
+	h, repository := newUserHarness(t)
+	ctx := context.Background()
+	/** 数字用户名的基因即其本身，10086 与 10087 分别落在 0、1 分库 **/
+	if h.ShardOf("10086") != 0 || h.ShardOf("10087") != 1 {
+		t.Fatalf("aspect shards 0 and 1, but get %d and %d", h.ShardOf("10086"), h.ShardOf("10087"))
+	}
+
+	first := &user{UserName: "10086", Password: "p", AliasName: "a"}
+	second := &user{UserName: "10087", Password: "p", AliasName: "b"}
+	for _, u := range []*user{first, second} {
+		if err := repository.Insert(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.AssertQueried(t, 0, `^insert into user`)
+	h.AssertQueried(t, 1, `^insert into user`)
+	if queries := h.Queries(0); len(queries) != 1 || queries[0].Args[5] != "10086" {
+		t.Errorf("aspect one insert of 10086 on shard 0, but get %v", queries)
+	}
+
+	h.Reset()
+	var got user
+	if err := repository.Get(ctx, &got, first.UserId, first.UserName); err != nil {
+		t.Fatal(err)
+	}
+	if got.UserId != first.UserId || got.AliasName != "a" || !got.CreateTime.Equal(first.CreateTime) {
+		t.Errorf("aspect %+v, but get %+v", *first, got)
+	}
+	if shards := h.ShardsOf(`^select`); !reflect.DeepEqual(shards, []int{0}) {
+		t.Errorf("aspect select on shard 0 only, but get %v", shards)
+	}
+	h.AssertNotQueried(t, 1, `^select`)
+
+	first.AliasName = "changed"
+	changed, err := repository.Update(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []string{"alias_name"}) {
+		t.Errorf("aspect [alias_name] changed, but get %v", changed)
+	}
+
+	duplicate := &user{UserName: "10086"}
+	err = repository.Insert(ctx, duplicate)
+	var duplicateErr *dam.DuplicateKeyError
+	if !errors.As(err, &duplicateErr) || duplicateErr.Key != "user_name" || duplicateErr.Value != "10086-0" {
+		t.Errorf("aspect duplicate key user_name, but get %v", err)
+	}
+
+	if err := repository.SoftDelete(ctx, first.UserId, first.UserName); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.Get(ctx, &got, first.UserId, first.UserName); err != sql.ErrNoRows {
+		t.Errorf("aspect sql.ErrNoRows after soft delete, but get %v", err)
+	}
+	/** 软删除后唯一键中 delete_time 不同，可再次使用用户名 **/
+	if err := repository.Insert(ctx, duplicate); err != nil {
+		t.Fatal(err)
+	}
+
+	var all []*user
+	if err := repository.FindAll(ctx, &all, dam.ScopeWithDeleted); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Errorf("aspect 3 users with deleted, but get %d", len(all))
+	}
+	all = nil
+	if err := repository.FindAll(ctx, &all, dam.ScopeNotDeleted); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Errorf("aspect 2 users, but get %d", len(all))
+	}
+}
+
+func TestFixturesAndExpect(t *testing.T) {
+	h, _ := newUserHarness(t)
+	ctx := context.Background()
+	err := h.LoadFixturesByKey("user", "user_name",
+		map[string]interface{}{"user_id": 1, "user_name": "10086"},
+		map[string]interface{}{"user_id": 2, "user_name": "10088"},
+		map[string]interface{}{"user_id": 3, "user_name": "10087", "delete_time": 5},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Queries(AnyShard)) != 0 {
+		t.Errorf("aspect fixtures not recorded, but get %v", h.Queries(AnyShard))
+	}
+	dbs := make(map[int]*dam.ShardDB)
+	for _, db := range h.Manager.GetAllDbs() {
+		dbs[db.ShardId()] = db
+	}
+	count, err := dam.Table("user").Count(ctx, dbs[0])
+	if err != nil || count != 2 {
+		t.Errorf("aspect 2 users on shard 0, but get %d, %v", count, err)
+	}
+	count, err = dam.Table("user").OnlyDeleted().Count(ctx, dbs[1])
+	if err != nil || count != 1 {
+		t.Errorf("aspect 1 deleted user on shard 1, but get %d, %v", count, err)
+	}
+
+	h.Expect(1, `^select`, Expectation{Err: &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}})
+	if _, err := dam.Table("user").Count(ctx, dbs[1]); !errors.Is(err, dam.ErrDeadlock) {
+		t.Errorf("aspect deadlock, but get %v", err)
+	}
+	h.Expect(AnyShard, `^select`, Expectation{Columns: []string{"count(*)"}, Rows: [][]interface{}{{42}}})
+	if count, err := dam.Table("user").Count(ctx, dbs[1]); err != nil || count != 42 {
+		t.Errorf("aspect expected count 42, but get %d, %v", count, err)
+	}
+	if count, err := dam.Table("user").Count(ctx, dbs[1]); err != nil || count != 0 {
+		t.Errorf("aspect expectation consumed, but get %d, %v", count, err)
+	}
+}
+
+func TestEngine(t *testing.T) {
+	h := New(1)
+	defer h.Close()
+	schema := "create table `counter` (\n" +
+		"  `id` int not null auto_increment primary key,\n" +
+		"  `name` varchar(32) not null,\n" +
+		"  `value` bigint not null default 0,\n" +
+		"  `note` text,\n" +
+		"  unique key `uk_name` (`name`)\n" +
+		");"
+	if err := h.LoadSchema(schema); err != nil {
+		t.Fatal(err)
+	}
+	db := h.Manager.GetAllDbs()[0].DB
+
+	result, err := db.Exec("insert into counter(name, value) values (?, ?), (?, ?)", "a", 1, "b", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := result.LastInsertId(); id != 1 {
+		t.Errorf("aspect first insert id 1, but get %d", id)
+	}
+	result, err = db.Exec("insert into counter(name, value) values (?, 10) on duplicate key update value=value+values(value), id=last_insert_id(id)", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := result.LastInsertId(); id != 2 {
+		t.Errorf("aspect last_insert_id 2, but get %d", id)
+	}
+	if affected, _ := result.RowsAffected(); affected != 2 {
+		t.Errorf("aspect 2 rows affected by update on duplicate, but get %d", affected)
+	}
+	if result, err = db.Exec("insert ignore into counter(name) values ('a')"); err != nil {
+		t.Fatal(err)
+	}
+	if affected, _ := result.RowsAffected(); affected != 0 {
+		t.Errorf("aspect insert ignore affects nothing, but get %d", affected)
+	}
+	if _, err := db.Exec("insert into counter(value) values (1)"); err == nil {
+		t.Errorf("aspect error for missing not null column")
+	}
+	if _, err := db.Exec("select * from missing"); !isMysqlError(err, 1146) {
+		t.Errorf("aspect table missing error, but get %v", err)
+	}
+
+	var names []string
+	if err := db.Select(&names, "select name from counter where value in (1, 12) and note is null order by value desc limit 0, 10"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"b", "a"}) {
+		t.Errorf("aspect [b a], but get %v", names)
+	}
+	var value int64
+	if err := db.Get(&value, "select value from counter where name=?", "b"); err != nil || value != 12 {
+		t.Errorf("aspect value 12, but get %d, %v", value, err)
+	}
+
+	if result, err = db.Exec("update counter set value=value where id=1"); err != nil {
+		t.Fatal(err)
+	}
+	if affected, _ := result.RowsAffected(); affected != 0 {
+		t.Errorf("aspect unchanged update affects nothing, but get %d", affected)
+	}
+	if _, err := db.Exec("update counter set name='a' where id=2"); !isMysqlError(err, 1062) {
+		t.Errorf("aspect duplicate error on update, but get %v", err)
+	}
+
+	tx := db.MustBegin()
+	tx.MustExec("delete from counter where id>=?", 1)
+	tx.Rollback()
+	if err := db.Get(&value, "select count(*) from counter"); err != nil || value != 2 {
+		t.Errorf("aspect rollback keeps 2 rows, but get %d, %v", value, err)
+	}
+	err = withTx(db, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec("delete from counter where name=?", "a")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&value, "select count(*) from counter"); err != nil || value != 1 {
+		t.Errorf("aspect commit deletes 1 row, but get %d, %v", value, err)
+	}
+}
+
+func TestTransactionUndo(t *testing.T) {
+	h := New(1)
+	defer h.Close()
+	if err := h.LoadSchema("create table counter (id int not null auto_increment primary key, name varchar(32) not null, value int not null default 0, unique key uk_name (name))"); err != nil {
+		t.Fatal(err)
+	}
+	db := h.Manager.GetAllDbs()[0].DB
+	db.MustExec("insert into counter(name, value) values ('a', 1), ('b', 2), ('c', 3)")
+
+	/** 多行 insert 在第二行冲突，整条语句不生效 **/
+	if _, err := db.Exec("insert into counter(name) values ('d'), ('a')"); !isMysqlError(err, 1062) {
+		t.Errorf("aspect duplicate error, but get %v", err)
+	}
+	var names []string
+	if err := db.Select(&names, "select name from counter order by id"); err != nil || !reflect.DeepEqual(names, []string{"a", "b", "c"}) {
+		t.Errorf("aspect failed insert undone, but get %v, %v", names, err)
+	}
+
+	tx := db.MustBegin()
+	tx.MustExec("insert into counter(name) values ('x')")
+	tx.MustExec("update counter set value=10 where name='a'")
+	tx.MustExec("delete from counter where name in ('b', 'c')")
+	/** 其它连接在事务期间提交的写入 **/
+	db.MustExec("insert into counter(name) values ('y')")
+	db.MustExec("update counter set value=20 where name='y'")
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	type row struct {
+		Name  string `db:"name"`
+		Value int    `db:"value"`
+	}
+	var rows []row
+	if err := db.Select(&rows, "select name, value from counter order by id"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []row{{"a", 1}, {"b", 2}, {"c", 3}, {"y", 20}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("aspect %v after rollback, but get %v", want, rows)
+	}
+}
+
+func TestExpectConcurrent(t *testing.T) {
+	h, _ := newUserHarness(t)
+	db := h.Manager.GetAllDbs()[0]
+	ctx := context.Background()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			dam.Table("user").Count(ctx, db)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		h.Expect(AnyShard, `^select`, Expectation{Columns: []string{"count(*)"}, Rows: [][]interface{}{{i}}})
+	}
+	<-done
+	h.Reset()
+
+	h.Expect(AnyShard, `^select`, Expectation{Times: 2, Err: errors.New("twice")})
+	h.Expect(AnyShard, `^select`, Expectation{Times: -1, Columns: []string{"count(*)"}, Rows: [][]interface{}{{7}}})
+	for i, want := range []int64{-1, -1, 7, 7, 7} {
+		count, err := dam.Table("user").Count(ctx, db)
+		if want < 0 && err == nil || want >= 0 && (err != nil || count != want) {
+			t.Errorf("call %d: aspect %d, but get %d, %v", i, want, count, err)
+		}
+	}
+}
+
+func withTx(db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func isMysqlError(err error, number uint16) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == number
+}
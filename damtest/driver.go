@@ -0,0 +1,161 @@
+package damtest
+
+/**
+ * database/sql 驱动，每个分库一个 connector，连接共享分库的内存数据库
+ * 事务以撤销记录实现：事务中每条语句的修改记入连接的 undoLog，Rollback 或连接关闭时逆序撤销，
+ * 其它连接同时提交的写入不受影响；不提供隔离，未提交的修改对其它连接可见
+ */
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+)
+
+var errDsnUnsupported = errors.New("damtest: open by dsn is unsupported, use damtest.New")
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return nil, errDsnUnsupported
+}
+
+type connector struct {
+	shard *shard
+}
+
+func (this *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &conn{shard: this.shard}, nil
+}
+
+func (this *connector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type conn struct {
+	shard *shard
+	/** 事务中不为 nil **/
+	undo *undoLog
+}
+
+func (this *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: this, query: query}, nil
+}
+
+func (this *conn) Close() error {
+	if this.undo != nil {
+		this.shard.database.rollback(this.undo)
+		this.undo = nil
+	}
+	return nil
+}
+
+func (this *conn) Begin() (driver.Tx, error) {
+	if this.undo != nil {
+		return nil, errors.New("damtest: nested transaction")
+	}
+	this.undo = &undoLog{}
+	return &tx{conn: this}, nil
+}
+
+func (this *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, _, err := this.shard.execute(query, namedValues(args), this.undo)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (this *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	_, set, err := this.shard.execute(query, namedValues(args), this.undo)
+	if err != nil {
+		return nil, err
+	}
+	if set == nil {
+		set = &resultSet{}
+	}
+	return &rows{set: set}, nil
+}
+
+func namedValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (this *tx) Commit() error {
+	this.conn.undo = nil
+	return nil
+}
+
+func (this *tx) Rollback() error {
+	if this.conn.undo != nil {
+		this.conn.shard.database.rollback(this.conn.undo)
+		this.conn.undo = nil
+	}
+	return nil
+}
+
+/**
+ * 预处理语句只保存sql文本，执行时再解析
+ */
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (this *stmt) Close() error {
+	return nil
+}
+
+func (this *stmt) NumInput() int {
+	return -1
+}
+
+func (this *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	res, _, err := this.conn.shard.execute(this.query, args, this.conn.undo)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (this *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	_, set, err := this.conn.shard.execute(this.query, args, this.conn.undo)
+	if err != nil {
+		return nil, err
+	}
+	if set == nil {
+		set = &resultSet{}
+	}
+	return &rows{set: set}, nil
+}
+
+type rows struct {
+	set *resultSet
+	pos int
+}
+
+func (this *rows) Columns() []string {
+	return this.set.columns
+}
+
+func (this *rows) Close() error {
+	return nil
+}
+
+func (this *rows) Next(dest []driver.Value) error {
+	if this.pos >= len(this.set.rows) {
+		return io.EOF
+	}
+	copy(dest, this.set.rows[this.pos])
+	this.pos++
+	return nil
+}
@@ -0,0 +1,1132 @@
+package damtest
+
+/**
+ * 内存sql引擎，每个分库一个 database
+ * 支持测试常用的mysql子集：
+ *   create table [if not exists]（列类型、default、auto_increment、primary key、unique key）、drop table、truncate
+ *   insert [ignore] ... values ...[, ...] [on duplicate key update ...]
+ *   select 全部列、指定列或 count(*) ... [where] [order by] [limit] [for update]
+ *   update ... set ... [where] [limit]、delete from ... [where] [limit]
+ * where 支持 and/or/not/括号、比较、is [not] null、[not] in、between
+ * 错误以 *mysql.MySQLError 返回(1062 唯一键冲突、1146 表不存在、1064 语法不支持)，与真实驱动一致
+ *
+ * 与 InnoDB 一致，单条语句是原子的：执行失败时撤销该语句已做的修改(如多行 insert 的前几行)。
+ * 事务内语句的撤销记录追加到事务的 undoLog，回滚时逆序撤销，只影响本事务修改过的行；
+ * 不提供隔离与行锁，建表、删表、truncate 不可回滚，自增值不回退
+ *
+ * 选择自带引擎而非 sqlmock：仓库不引入新的依赖，且 Repository、分库路由、批量写入、号段等测试
+ * 需要跨语句的真实状态(唯一键冲突、last_insert_id、软删除后的查询)，逐条预设返回值既冗长又脆弱；
+ * 引擎之外的语句可用 Harness.Expect 预设结果
+ */
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	mysqlErrDuplicateEntry  = 1062
+	mysqlErrSyntax          = 1064
+	mysqlErrNoDefault       = 1364
+	mysqlErrTableExists     = 1050
+	mysqlErrUnknownTable    = 1051
+	mysqlErrNoSuchTable     = 1146
+	mysqlErrBadField        = 1054
+	mysqlErrWrongValueCount = 1136
+	primaryKeyName          = "PRIMARY"
+	timeLayout              = "2006-01-02 15:04:05"
+)
+
+func syntaxError(near string) error {
+	return &mysql.MySQLError{Number: mysqlErrSyntax, Message: "You have an error in your SQL syntax: " + near}
+}
+
+func unknownColumnError(column string) error {
+	return &mysql.MySQLError{Number: mysqlErrBadField, Message: fmt.Sprintf("Unknown column '%s' in 'field list'", column)}
+}
+
+type columnKind int
+
+const (
+	kindText columnKind = iota
+	kindInteger
+	kindFloat
+	kindTime
+)
+
+type column struct {
+	name          string
+	kind          columnKind
+	notNull       bool
+	hasDefault    bool
+	defaultValue  expr
+	autoIncrement bool
+}
+
+type uniqueKey struct {
+	name    string
+	columns []string
+}
+
+type table struct {
+	name          string
+	columns       []*column
+	keys          []uniqueKey
+	rows          []map[string]driver.Value
+	autoIncrement int64
+}
+
+func (this *table) column(name string) *column {
+	for _, column := range this.columns {
+		if column.name == name {
+			return column
+		}
+	}
+	return nil
+}
+
+func (this *table) columnNames() []string {
+	names := make([]string, len(this.columns))
+	for i, column := range this.columns {
+		names[i] = column.name
+	}
+	return names
+}
+
+/**
+ * 按行的身份移除，行已不存在时忽略
+ */
+func (this *table) remove(row map[string]driver.Value) {
+	id := rowId(row)
+	for i, existing := range this.rows {
+		if rowId(existing) == id {
+			this.rows = append(this.rows[:i], this.rows[i+1:]...)
+			return
+		}
+	}
+}
+
+/**
+ * 与 row 在唯一键上冲突的行，skip 为自身(update时)
+ */
+func (this *table) conflict(row, skip map[string]driver.Value) (map[string]driver.Value, uniqueKey) {
+	for _, key := range this.keys {
+		for _, existing := range this.rows {
+			if sameRow(existing, skip) || !keyEqual(key, existing, row) {
+				continue
+			}
+			return existing, key
+		}
+	}
+	return nil, uniqueKey{}
+}
+
+func sameRow(a, b map[string]driver.Value) bool {
+	return b != nil && rowId(a) == rowId(b)
+}
+
+func rowId(row map[string]driver.Value) uintptr {
+	return reflect.ValueOf(row).Pointer()
+}
+
+/**
+ * 唯一键中任一列为 NULL 时不冲突
+ */
+func keyEqual(key uniqueKey, a, b map[string]driver.Value) bool {
+	for _, name := range key.columns {
+		c, ok := compareValues(a[name], b[name])
+		if !ok || c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func duplicateError(key uniqueKey, row map[string]driver.Value) error {
+	values := make([]string, len(key.columns))
+	for i, name := range key.columns {
+		values[i] = formatValue(row[name])
+	}
+	return &mysql.MySQLError{Number: mysqlErrDuplicateEntry,
+		Message: fmt.Sprintf("Duplicate entry '%s' for key '%s'", strings.Join(values, "-"), key.name)}
+}
+
+func formatValue(value driver.Value) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case time.Time:
+		return v.Format(timeLayout)
+	}
+	return fmt.Sprint(value)
+}
+
+func copyRow(row map[string]driver.Value) map[string]driver.Value {
+	copied := make(map[string]driver.Value, len(row))
+	for name, value := range row {
+		copied[name] = value
+	}
+	return copied
+}
+
+/**
+ * 按列类型转换值，与mysql隐式转换近似
+ */
+func (this *column) convert(value driver.Value) driver.Value {
+	value = normalizeValue(value)
+	if value == nil {
+		return nil
+	}
+	switch this.kind {
+	case kindInteger:
+		switch v := value.(type) {
+		case float64:
+			return int64(v)
+		case string:
+			if i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				return i
+			}
+			if f, ok := parseFloat(v); ok {
+				return int64(f)
+			}
+			return int64(0)
+		}
+	case kindFloat:
+		if f, ok := toFloat(value); ok {
+			return f
+		}
+		if f, ok := parseFloat(value); ok {
+			return f
+		}
+		return float64(0)
+	case kindTime:
+		if text, ok := value.(string); ok {
+			for _, layout := range []string{timeLayout, "2006-01-02", time.RFC3339Nano} {
+				if t, err := time.ParseInLocation(layout, text, time.Local); err == nil {
+					return t
+				}
+			}
+		}
+	case kindText:
+		switch v := value.(type) {
+		case int64, float64:
+			return fmt.Sprint(v)
+		case time.Time:
+			return v.Format(timeLayout)
+		}
+	}
+	return value
+}
+
+type result struct {
+	lastInsertId int64
+	rowsAffected int64
+}
+
+func (this result) LastInsertId() (int64, error) {
+	return this.lastInsertId, nil
+}
+
+func (this result) RowsAffected() (int64, error) {
+	return this.rowsAffected, nil
+}
+
+type resultSet struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+/**
+ * 撤销记录，按记录的逆序执行
+ */
+type undoLog []func()
+
+func (this *undoLog) rollback() {
+	for i := len(*this) - 1; i >= 0; i-- {
+		(*this)[i]()
+	}
+	*this = nil
+}
+
+type database struct {
+	mutex  sync.Mutex
+	tables map[string]*table
+	/** 最近一次 filter 在 limit 前匹配的行数，用于 count(*) **/
+	matched int
+	/** 当前语句的撤销记录 **/
+	statement undoLog
+}
+
+func newDatabase() *database {
+	return &database{tables: make(map[string]*table)}
+}
+
+/**
+ * 回滚事务的全部修改
+ */
+func (this *database) rollback(undo *undoLog) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	undo.rollback()
+}
+
+func (this *database) recordInsert(t *table, row map[string]driver.Value) {
+	this.statement = append(this.statement, func() {
+		t.remove(row)
+	})
+}
+
+func (this *database) recordUpdate(row map[string]driver.Value) {
+	old := copyRow(row)
+	this.statement = append(this.statement, func() {
+		for name, value := range old {
+			row[name] = value
+		}
+	})
+}
+
+/**
+ * indexes 为被删除行在表中的位置，升序
+ */
+func (this *database) recordDelete(t *table, rows []map[string]driver.Value, indexes []int) {
+	this.statement = append(this.statement, func() {
+		for i, row := range rows {
+			index := indexes[i]
+			if index > len(t.rows) {
+				index = len(t.rows)
+			}
+			t.rows = append(t.rows, nil)
+			copy(t.rows[index+1:], t.rows[index:])
+			t.rows[index] = row
+		}
+	})
+}
+
+func (this *database) table(name string) (*table, error) {
+	table, ok := this.tables[name]
+	if !ok {
+		return nil, &mysql.MySQLError{Number: mysqlErrNoSuchTable, Message: fmt.Sprintf("Table '%s' doesn't exist", name)}
+	}
+	return table, nil
+}
+
+/**
+ * 执行一条语句，查询语句返回 resultSet
+ * 失败时撤销本语句的修改；成功且 undo 不为 nil(事务中)时将撤销记录追加到 undo
+ */
+func (this *database) execute(query string, args []driver.Value, undo *undoLog) (result, *resultSet, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return result{}, nil, err
+	}
+	for len(tokens) > 0 && tokens[len(tokens)-1].text == ";" && tokens[len(tokens)-1].kind == tokenSymbol {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) == 0 {
+		return result{}, nil, syntaxError("empty query")
+	}
+	p := &parser{query: query, tokens: tokens, args: args}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	var res result
+	var set *resultSet
+	switch p.next().text {
+	case "create":
+		err = this.createTable(p)
+	case "drop":
+		err = this.dropTable(p)
+	case "truncate":
+		err = this.truncate(p)
+	case "insert":
+		res, err = this.insert(p)
+	case "select":
+		set, err = this.selectRows(p)
+	case "update":
+		res, err = this.update(p)
+	case "delete":
+		res, err = this.delete(p)
+	case "set", "use":
+		/** set names utf8 等会话设置 **/
+		return result{}, nil, nil
+	default:
+		return result{}, nil, p.errorf("unsupported statement")
+	}
+	if err == nil && !p.done() {
+		err = p.errorf("unexpected token")
+	}
+	if err != nil {
+		this.statement.rollback()
+	} else if undo != nil {
+		*undo = append(*undo, this.statement...)
+	}
+	this.statement = nil
+	return res, set, err
+}
+
+func (this *database) createTable(p *parser) error {
+	if err := p.expect("table"); err != nil {
+		return err
+	}
+	ifNotExists := false
+	if p.accept("if") {
+		if err := p.expect("not"); err != nil {
+			return err
+		}
+		if err := p.expect("exists"); err != nil {
+			return err
+		}
+		ifNotExists = true
+	}
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	if _, ok := this.tables[name]; ok {
+		if ifNotExists {
+			p.pos = len(p.tokens)
+			return nil
+		}
+		return &mysql.MySQLError{Number: mysqlErrTableExists, Message: fmt.Sprintf("Table '%s' already exists", name)}
+	}
+	t := &table{name: name}
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for {
+		if err := this.tableElement(p, t); err != nil {
+			return err
+		}
+		if p.accept(")") {
+			break
+		}
+		if err := p.expect(","); err != nil {
+			return err
+		}
+	}
+	/** engine=InnoDB default charset=utf8mb4 等表选项 **/
+	p.pos = len(p.tokens)
+	this.tables[name] = t
+	return nil
+}
+
+func (this *database) tableElement(p *parser, t *table) error {
+	switch {
+	case p.accept("primary"):
+		if err := p.expect("key"); err != nil {
+			return err
+		}
+		columns, err := p.identList()
+		if err != nil {
+			return err
+		}
+		t.keys = append([]uniqueKey{{name: primaryKeyName, columns: columns}}, t.keys...)
+		for _, name := range columns {
+			if column := t.column(name); column != nil {
+				column.notNull = true
+			}
+		}
+		return nil
+	case p.accept("unique"):
+		if !p.accept("key") {
+			p.accept("index")
+		}
+		return this.keyDefinition(p, t, true)
+	case p.accept("key"), p.accept("index"), p.accept("fulltext"):
+		p.accept("key")
+		return this.keyDefinition(p, t, false)
+	case p.accept("constraint"):
+		return p.errorf("constraint is unsupported")
+	}
+	return this.columnDefinition(p, t)
+}
+
+func (this *database) keyDefinition(p *parser, t *table, unique bool) error {
+	name := ""
+	if p.peek().kind == tokenIdent {
+		name = p.next().text
+	}
+	columns, err := p.identList()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = columns[0]
+	}
+	p.accept("using")
+	p.accept("btree")
+	if unique {
+		t.keys = append(t.keys, uniqueKey{name: name, columns: columns})
+	}
+	return nil
+}
+
+func (this *database) columnDefinition(p *parser, t *table) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	typeName := p.next()
+	if typeName.kind != tokenIdent {
+		return p.errorf("expect column type")
+	}
+	c := &column{name: name, kind: columnKindOf(typeName.text)}
+	if p.accept("(") {
+		for !p.done() && !p.accept(")") {
+			p.next()
+		}
+	}
+	for !p.done() {
+		switch next := p.peek(); {
+		case next.kind == tokenSymbol && (next.text == "," || next.text == ")"):
+			t.columns = append(t.columns, c)
+			return nil
+		case p.accept("not"):
+			if err := p.expect("null"); err != nil {
+				return err
+			}
+			c.notNull = true
+		case p.accept("default"):
+			value, err := p.term()
+			if err != nil {
+				return err
+			}
+			c.hasDefault = true
+			c.defaultValue = value
+		case p.accept("auto_increment"):
+			c.autoIncrement = true
+		case p.accept("primary"):
+			p.accept("key")
+			c.notNull = true
+			t.keys = append([]uniqueKey{{name: primaryKeyName, columns: []string{name}}}, t.keys...)
+		case p.accept("unique"):
+			p.accept("key")
+			t.keys = append(t.keys, uniqueKey{name: name, columns: []string{name}})
+		case p.accept("on"):
+			/** on update current_timestamp **/
+			if err := p.expect("update"); err != nil {
+				return err
+			}
+			if _, err := p.term(); err != nil {
+				return err
+			}
+		case p.accept("comment"), p.accept("collate"):
+			p.next()
+		case p.accept("character"):
+			p.accept("set")
+			p.next()
+		default:
+			/** null、unsigned、zerofill 等忽略 **/
+			p.next()
+		}
+	}
+	return p.errorf("unterminated column definition")
+}
+
+func columnKindOf(typeName string) columnKind {
+	switch typeName {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "bit", "bool", "boolean", "year":
+		return kindInteger
+	case "float", "double", "real", "decimal", "numeric":
+		return kindFloat
+	case "date", "datetime", "timestamp":
+		return kindTime
+	}
+	return kindText
+}
+
+func (this *database) dropTable(p *parser) error {
+	if err := p.expect("table"); err != nil {
+		return err
+	}
+	ifExists := p.accept("if") && p.accept("exists")
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return err
+		}
+		if _, ok := this.tables[name]; !ok && !ifExists {
+			return &mysql.MySQLError{Number: mysqlErrUnknownTable, Message: fmt.Sprintf("Unknown table '%s'", name)}
+		}
+		delete(this.tables, name)
+		if !p.accept(",") {
+			return nil
+		}
+	}
+}
+
+func (this *database) truncate(p *parser) error {
+	p.accept("table")
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	t, err := this.table(name)
+	if err != nil {
+		return err
+	}
+	t.rows = nil
+	t.autoIncrement = 0
+	return nil
+}
+
+func (this *database) insert(p *parser) (result, error) {
+	ignore := p.accept("ignore")
+	p.accept("into")
+	name, err := p.ident()
+	if err != nil {
+		return result{}, err
+	}
+	t, err := this.table(name)
+	if err != nil {
+		return result{}, err
+	}
+	names := t.columnNames()
+	if p.peek().text == "(" {
+		if names, err = p.identList(); err != nil {
+			return result{}, err
+		}
+		for _, name := range names {
+			if t.column(name) == nil {
+				return result{}, unknownColumnError(name)
+			}
+		}
+	}
+	if !p.accept("values") {
+		if err := p.expect("value"); err != nil {
+			return result{}, err
+		}
+	}
+	var rows [][]expr
+	for {
+		if err := p.expect("("); err != nil {
+			return result{}, err
+		}
+		var row []expr
+		for {
+			value, err := p.expr()
+			if err != nil {
+				return result{}, err
+			}
+			row = append(row, value)
+			if p.accept(")") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return result{}, err
+			}
+		}
+		if len(row) != len(names) {
+			return result{}, &mysql.MySQLError{Number: mysqlErrWrongValueCount,
+				Message: fmt.Sprintf("Column count doesn't match value count at row %d", len(rows)+1)}
+		}
+		rows = append(rows, row)
+		if !p.accept(",") {
+			break
+		}
+	}
+	var updates []assignment
+	if p.accept("on") {
+		for _, word := range []string{"duplicate", "key", "update"} {
+			if err := p.expect(word); err != nil {
+				return result{}, err
+			}
+		}
+		if updates, err = p.assignments(t); err != nil {
+			return result{}, err
+		}
+	}
+
+	var res result
+	var lastInsertId int64
+	for _, values := range rows {
+		row, autoId, err := this.newRow(t, names, values)
+		if err != nil {
+			return result{}, err
+		}
+		if res.lastInsertId == 0 {
+			res.lastInsertId = autoId
+		}
+		existing, key := t.conflict(row, nil)
+		switch {
+		case existing == nil:
+			t.rows = append(t.rows, row)
+			this.recordInsert(t, row)
+			res.rowsAffected++
+		case updates != nil:
+			ctx := &evalContext{row: existing, inserted: row, lastInsertId: &lastInsertId}
+			changed, err := this.assign(t, existing, updates, ctx)
+			if err != nil {
+				return result{}, err
+			}
+			if changed {
+				res.rowsAffected += 2
+			}
+		case ignore:
+		default:
+			return result{}, duplicateError(key, row)
+		}
+	}
+	if lastInsertId != 0 {
+		res.lastInsertId = lastInsertId
+	}
+	return res, nil
+}
+
+/**
+ * 以默认值与 insert 的值构建新行，自增列为空或0时分配，返回分配的自增值
+ */
+func (this *database) newRow(t *table, names []string, values []expr) (row map[string]driver.Value, autoId int64, err error) {
+	row = make(map[string]driver.Value, len(t.columns))
+	provided := make(map[string]bool, len(names))
+	for i, name := range names {
+		value, err := values[i](&evalContext{})
+		if err != nil {
+			return nil, 0, err
+		}
+		row[name] = t.column(name).convert(value)
+		provided[name] = true
+	}
+	for _, column := range t.columns {
+		switch {
+		case column.autoIncrement:
+			if id, ok := row[column.name].(int64); ok && id != 0 {
+				if id > t.autoIncrement {
+					t.autoIncrement = id
+				}
+				continue
+			}
+			t.autoIncrement++
+			row[column.name] = t.autoIncrement
+			autoId = t.autoIncrement
+		case provided[column.name]:
+		case column.hasDefault:
+			value, err := column.defaultValue(&evalContext{})
+			if err != nil {
+				return nil, 0, err
+			}
+			row[column.name] = column.convert(value)
+		case column.notNull:
+			return nil, 0, &mysql.MySQLError{Number: mysqlErrNoDefault, Message: fmt.Sprintf("Field '%s' doesn't have a default value", column.name)}
+		default:
+			row[column.name] = nil
+		}
+	}
+	return row, autoId, nil
+}
+
+type assignment struct {
+	column string
+	value  expr
+}
+
+func (this *parser) assignments(t *table) ([]assignment, error) {
+	var assignments []assignment
+	for {
+		name, err := this.ident()
+		if err != nil {
+			return nil, err
+		}
+		if t.column(name) == nil {
+			return nil, unknownColumnError(name)
+		}
+		if err := this.expect("="); err != nil {
+			return nil, err
+		}
+		value, err := this.expr()
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, assignment{column: name, value: value})
+		if !this.accept(",") {
+			return assignments, nil
+		}
+	}
+}
+
+/**
+ * 按顺序赋值(后面的赋值可见前面的结果)，唯一键冲突时不修改行
+ */
+func (this *database) assign(t *table, row map[string]driver.Value, assignments []assignment, ctx *evalContext) (bool, error) {
+	updated := copyRow(row)
+	ctx.row = updated
+	for _, assignment := range assignments {
+		value, err := assignment.value(ctx)
+		if err != nil {
+			return false, err
+		}
+		updated[assignment.column] = t.column(assignment.column).convert(value)
+	}
+	changed := false
+	for name, value := range updated {
+		if c, ok := compareValues(row[name], value); !ok && (row[name] != nil || value != nil) || ok && c != 0 {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	if existing, key := t.conflict(updated, row); existing != nil {
+		return false, duplicateError(key, updated)
+	}
+	this.recordUpdate(row)
+	for name, value := range updated {
+		row[name] = value
+	}
+	return true, nil
+}
+
+type selectItem struct {
+	name  string
+	value expr
+	count bool
+}
+
+func (this *database) selectRows(p *parser) (*resultSet, error) {
+	var items []selectItem
+	star := false
+	if p.accept("*") {
+		star = true
+	} else {
+		for {
+			item := selectItem{}
+			if p.accept("count") {
+				if err := p.expect("("); err != nil {
+					return nil, err
+				}
+				if !p.accept("*") {
+					if _, err := p.expr(); err != nil {
+						return nil, err
+					}
+				}
+				if err := p.expect(")"); err != nil {
+					return nil, err
+				}
+				item.name, item.count = "count(*)", true
+			} else {
+				start := p.pos
+				value, err := p.expr()
+				if err != nil {
+					return nil, err
+				}
+				item.value = value
+				item.name = p.tokens[start].text
+				if p.pos-start > 1 {
+					item.name = tokensText(p.tokens[start:p.pos])
+				}
+			}
+			if p.accept("as") {
+				alias, err := p.ident()
+				if err != nil {
+					return nil, err
+				}
+				item.name = alias
+			}
+			items = append(items, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if !p.accept("from") {
+		/** select 1、select last_insert_id() 等无表查询 **/
+		row := make([]driver.Value, len(items))
+		names := make([]string, len(items))
+		for i, item := range items {
+			if item.value == nil {
+				return nil, p.errorf("unsupported select")
+			}
+			names[i] = item.name
+			row[i], _ = item.value(&evalContext{})
+		}
+		return &resultSet{columns: names, rows: [][]driver.Value{row}}, nil
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	t, err := this.table(name)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := this.filter(p, t)
+	if err != nil {
+		return nil, err
+	}
+	if p.accept("for") {
+		if err := p.expect("update"); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("lock") {
+		for _, word := range []string{"in", "share", "mode"} {
+			if err := p.expect(word); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if star {
+		set := &resultSet{columns: t.columnNames()}
+		for _, row := range rows {
+			values := make([]driver.Value, len(t.columns))
+			for i, column := range t.columns {
+				values[i] = row[column.name]
+			}
+			set.rows = append(set.rows, values)
+		}
+		return set, nil
+	}
+	set := &resultSet{}
+	aggregate := false
+	for _, item := range items {
+		set.columns = append(set.columns, item.name)
+		aggregate = aggregate || item.count
+	}
+	if aggregate {
+		rows = rows[:minInt(len(rows), 1)]
+		if len(rows) == 0 {
+			rows = append(rows, map[string]driver.Value{})
+		}
+	}
+	for _, row := range rows {
+		values := make([]driver.Value, len(items))
+		for i, item := range items {
+			if item.count {
+				values[i] = int64(this.matched)
+				continue
+			}
+			if values[i], err = item.value(&evalContext{row: row}); err != nil {
+				return nil, err
+			}
+		}
+		set.rows = append(set.rows, values)
+	}
+	return set, nil
+}
+
+func tokensText(tokens []token) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+/**
+ * 解析 [where] [order by] [limit] 并返回匹配的行(引用表中的行)，matched 记录 limit 前的匹配数
+ */
+func (this *database) filter(p *parser, t *table) ([]map[string]driver.Value, error) {
+	var where condition
+	if p.accept("where") {
+		var err error
+		if where, err = p.condition(); err != nil {
+			return nil, err
+		}
+	}
+	type order struct {
+		value expr
+		desc  bool
+	}
+	var orders []order
+	if p.accept("order") {
+		if err := p.expect("by"); err != nil {
+			return nil, err
+		}
+		for {
+			value, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			o := order{value: value}
+			if p.accept("desc") {
+				o.desc = true
+			} else {
+				p.accept("asc")
+			}
+			orders = append(orders, o)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	offset, limit := 0, -1
+	if p.accept("limit") {
+		first, err := p.intValue()
+		if err != nil {
+			return nil, err
+		}
+		limit = first
+		if p.accept(",") {
+			offset = first
+			if limit, err = p.intValue(); err != nil {
+				return nil, err
+			}
+		} else if p.accept("offset") {
+			if offset, err = p.intValue(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var rows []map[string]driver.Value
+	for _, row := range t.rows {
+		if where != nil {
+			ok, err := where(&evalContext{row: row})
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		rows = append(rows, row)
+	}
+	var sortErr error
+	if len(orders) > 0 {
+		sort.SliceStable(rows, func(i, j int) bool {
+			for _, o := range orders {
+				a, err := o.value(&evalContext{row: rows[i]})
+				if err != nil {
+					sortErr = err
+					return false
+				}
+				b, _ := o.value(&evalContext{row: rows[j]})
+				c := nullsFirstCompare(a, b)
+				if c != 0 {
+					return c < 0 != o.desc
+				}
+			}
+			return false
+		})
+	}
+	if sortErr != nil {
+		return nil, sortErr
+	}
+	this.matched = len(rows)
+	if offset > len(rows) {
+		offset = len(rows)
+	}
+	rows = rows[offset:]
+	if limit >= 0 && limit < len(rows) {
+		rows = rows[:limit]
+	}
+	return rows, nil
+}
+
+/**
+ * mysql 排序中 NULL 最小
+ */
+func nullsFirstCompare(a, b driver.Value) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	c, _ := compareValues(a, b)
+	return c
+}
+
+func (this *parser) intValue() (int, error) {
+	value, err := this.term()
+	if err != nil {
+		return 0, err
+	}
+	v, _ := value(&evalContext{})
+	switch n := v.(type) {
+	case int64:
+		return int(n), nil
+	case float64:
+		return int(n), nil
+	}
+	return 0, this.errorf("expect integer")
+}
+
+func (this *database) update(p *parser) (result, error) {
+	name, err := p.ident()
+	if err != nil {
+		return result{}, err
+	}
+	t, err := this.table(name)
+	if err != nil {
+		return result{}, err
+	}
+	if err := p.expect("set"); err != nil {
+		return result{}, err
+	}
+	assignments, err := p.assignments(t)
+	if err != nil {
+		return result{}, err
+	}
+	rows, err := this.filter(p, t)
+	if err != nil {
+		return result{}, err
+	}
+	var res result
+	var lastInsertId int64
+	for _, row := range rows {
+		changed, err := this.assign(t, row, assignments, &evalContext{lastInsertId: &lastInsertId})
+		if err != nil {
+			return result{}, err
+		}
+		if changed {
+			res.rowsAffected++
+		}
+	}
+	res.lastInsertId = lastInsertId
+	return res, nil
+}
+
+func (this *database) delete(p *parser) (result, error) {
+	if err := p.expect("from"); err != nil {
+		return result{}, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return result{}, err
+	}
+	t, err := this.table(name)
+	if err != nil {
+		return result{}, err
+	}
+	rows, err := this.filter(p, t)
+	if err != nil {
+		return result{}, err
+	}
+	deleted := make(map[uintptr]bool, len(rows))
+	for _, row := range rows {
+		deleted[rowId(row)] = true
+	}
+	var removed []map[string]driver.Value
+	var indexes []int
+	kept := t.rows[:0]
+	for i, row := range t.rows {
+		if deleted[rowId(row)] {
+			removed = append(removed, row)
+			indexes = append(indexes, i)
+			continue
+		}
+		kept = append(kept, row)
+	}
+	t.rows = kept
+	this.recordDelete(t, removed, indexes)
+	return result{rowsAffected: int64(len(rows))}, nil
+}
@@ -0,0 +1,333 @@
+package damtest
+
+/**
+ * 无需mysql进程的 IMysqlManager 测试工具
+ * 每个分库由独立的内存sql引擎承载，按 dam 的分库规则(Dna)路由，并记录每个分库执行过的语句：
+ *
+ *   h := damtest.New(2)
+ *   defer h.Close()
+ *   if err := h.LoadSchema(userSchema); err != nil { t.Fatal(err) }
+ *   repository := dam.NewRepository(h.Manager, User{}, config)
+ *   ...
+ *   h.AssertQueried(t, h.ShardOf("yang"), `^insert into user`)
+ *
+ * 引擎只支持常用的mysql子集(见 engine.go)，不支持的语句可用 Expect 指定返回
+ */
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	dam "github.com/seanbit/godam"
+)
+
+/** Expect 与 Queries 中表示任意分库 **/
+const AnyShard = -1
+
+type Query struct {
+	ShardId int
+	SQL     string
+	Args    []interface{}
+}
+
+type Harness struct {
+	Manager dam.IMysqlManager
+
+	shards       map[int]*shard
+	mutex        sync.Mutex
+	queries      []Query
+	expectations []*expectation
+}
+
+type shard struct {
+	id       int
+	harness  *Harness
+	database *database
+}
+
+/**
+ * 创建 shardCount 个分库，分库id为 0..shardCount-1
+ */
+func New(shardCount int) *Harness {
+	return NewWithConfig(shardCount, dam.MysqlConfig{})
+}
+
+/**
+ * 使用自定义配置(如 Metrics、Hooks、StmtCacheSize、Retry)，连接参数被忽略
+ */
+func NewWithConfig(shardCount int, config dam.MysqlConfig) *Harness {
+	if shardCount <= 0 {
+		panic(fmt.Sprintf("damtest: shard count must be positive, get %d", shardCount))
+	}
+	harness := &Harness{shards: make(map[int]*shard, shardCount)}
+	hosts := make(map[int]string, shardCount)
+	dbs := make(map[int]*sqlx.DB, shardCount)
+	for id := 0; id < shardCount; id++ {
+		s := &shard{id: id, harness: harness, database: newDatabase()}
+		harness.shards[id] = s
+		hosts[id] = fmt.Sprintf("damtest-%d", id)
+		if host, ok := config.Hosts[id]; ok {
+			hosts[id] = host
+		}
+		dbs[id] = sqlx.NewDb(sql.OpenDB(&connector{shard: s}), "mysql")
+	}
+	config.Hosts = hosts
+	harness.Manager = dam.NewMysqlManagerWithDbs(config, dbs)
+	return harness
+}
+
+func (this *Harness) Close() error {
+	return this.Manager.Close()
+}
+
+/**
+ * 记录语句，命中 Expect 时返回指定结果，否则交由内存引擎执行
+ */
+func (this *shard) execute(query string, args []driver.Value, undo *undoLog) (result, *resultSet, error) {
+	if expectation := this.harness.record(this.id, query, args); expectation != nil {
+		return expectation.result, expectation.set, expectation.err
+	}
+	return this.database.execute(query, args, undo)
+}
+
+func (this *Harness) record(shardId int, query string, args []driver.Value) *expectation {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	recorded := Query{ShardId: shardId, SQL: query, Args: make([]interface{}, len(args))}
+	for i, arg := range args {
+		recorded.Args[i] = arg
+	}
+	this.queries = append(this.queries, recorded)
+	for i, expectation := range this.expectations {
+		if (expectation.shardId == AnyShard || expectation.shardId == shardId) && expectation.pattern.MatchString(query) {
+			if expectation.times--; expectation.times == 0 {
+				this.expectations = append(this.expectations[:i], this.expectations[i+1:]...)
+			}
+			return expectation
+		}
+	}
+	return nil
+}
+
+func (this *Harness) shard(shardId int) (*shard, error) {
+	s, ok := this.shards[shardId]
+	if !ok {
+		return nil, fmt.Errorf("damtest: shard %d not found", shardId)
+	}
+	return s, nil
+}
+
+/**
+ * 在全部分库执行建表等脚本，语句以分号分隔，不计入 Queries
+ */
+func (this *Harness) LoadSchema(schema string) error {
+	for id := range this.shards {
+		if err := this.LoadShardSchema(id, schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *Harness) LoadShardSchema(shardId int, schema string) error {
+	s, err := this.shard(shardId)
+	if err != nil {
+		return err
+	}
+	for _, statement := range dam.SplitStatements(schema) {
+		if _, _, err := s.database.execute(statement, nil, nil); err != nil {
+			return fmt.Errorf("damtest: shard %d: %w", shardId, err)
+		}
+	}
+	return nil
+}
+
+/**
+ * 向指定分库写入数据，不计入 Queries
+ */
+func (this *Harness) LoadFixtures(shardId int, table string, rows ...map[string]interface{}) error {
+	s, err := this.shard(shardId)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		columns := make([]string, 0, len(row))
+		for column := range row {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+		args := make([]driver.Value, len(columns))
+		for i, column := range columns {
+			if args[i], err = driver.DefaultParameterConverter.ConvertValue(row[column]); err != nil {
+				return fmt.Errorf("damtest: column %s: %w", column, err)
+			}
+		}
+		query := fmt.Sprintf("insert into %s(%s)values(%s)", table, strings.Join(columns, ", "),
+			strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+		if _, _, err := s.database.execute(query, args, nil); err != nil {
+			return fmt.Errorf("damtest: shard %d: %w", shardId, err)
+		}
+	}
+	return nil
+}
+
+/**
+ * 按 keyColumn 的值路由到所在分库写入数据
+ */
+func (this *Harness) LoadFixturesByKey(table, keyColumn string, rows ...map[string]interface{}) error {
+	for _, row := range rows {
+		shardId := this.ShardOf(row[keyColumn])
+		if shardId == AnyShard {
+			return fmt.Errorf("damtest: can not route %s=%v", keyColumn, row[keyColumn])
+		}
+		if err := this.LoadFixtures(shardId, table, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * 分库键所在的分库id，无法路由时返回 AnyShard
+ */
+func (this *Harness) ShardOf(shardKey interface{}) int {
+	db, err := this.Manager.GetDbByUserName(fmt.Sprint(shardKey))
+	if err != nil || db == nil {
+		return AnyShard
+	}
+	return db.ShardId()
+}
+
+/**
+ * 已执行的语句，shardId 为 AnyShard 时返回全部分库的语句
+ */
+func (this *Harness) Queries(shardId int) []Query {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	var queries []Query
+	for _, query := range this.queries {
+		if shardId == AnyShard || query.ShardId == shardId {
+			queries = append(queries, query)
+		}
+	}
+	return queries
+}
+
+/**
+ * 执行过匹配 pattern 的语句的分库id，升序
+ */
+func (this *Harness) ShardsOf(pattern string) []int {
+	re := regexp.MustCompile(pattern)
+	seen := make(map[int]bool)
+	var shards []int
+	for _, query := range this.Queries(AnyShard) {
+		if re.MatchString(query.SQL) && !seen[query.ShardId] {
+			seen[query.ShardId] = true
+			shards = append(shards, query.ShardId)
+		}
+	}
+	sort.Ints(shards)
+	return shards
+}
+
+/**
+ * 清空语句记录与未消费的 Expect，数据保留
+ */
+func (this *Harness) Reset() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.queries = nil
+	this.expectations = nil
+}
+
+/**
+ * 断言分库执行过匹配 pattern 的语句
+ */
+func (this *Harness) AssertQueried(t testing.TB, shardId int, pattern string) {
+	t.Helper()
+	if !this.queried(shardId, pattern) {
+		t.Errorf("aspect query matching %q on shard %d, but get %v", pattern, shardId, this.ShardsOf(pattern))
+	}
+}
+
+/**
+ * 断言分库未执行过匹配 pattern 的语句
+ */
+func (this *Harness) AssertNotQueried(t testing.TB, shardId int, pattern string) {
+	t.Helper()
+	if this.queried(shardId, pattern) {
+		t.Errorf("aspect no query matching %q on shard %d, but get %v", pattern, shardId, this.ShardsOf(pattern))
+	}
+}
+
+func (this *Harness) queried(shardId int, pattern string) bool {
+	for _, id := range this.ShardsOf(pattern) {
+		if shardId == AnyShard || id == shardId {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * 预设结果：Err 不为 nil 时返回错误，Columns 不为 nil 时返回结果集，否则返回 LastInsertId 与 RowsAffected
+ */
+type Expectation struct {
+	/** 生效次数，默认1，小于0时一直生效 **/
+	Times        int
+	Columns      []string
+	Rows         [][]interface{}
+	LastInsertId int64
+	RowsAffected int64
+	Err          error
+}
+
+type expectation struct {
+	shardId int
+	pattern *regexp.Regexp
+	times   int
+	result  result
+	set     *resultSet
+	err     error
+}
+
+/**
+ * 此后在 shardId 上执行且匹配 pattern(正则)的语句不再交由引擎执行，而是返回预设结果
+ * 预设在登记前转换完成，登记后不再修改，可与执行语句的协程并发调用
+ */
+func (this *Harness) Expect(shardId int, pattern string, expect Expectation) {
+	registered := &expectation{
+		shardId: shardId,
+		pattern: regexp.MustCompile(pattern),
+		times:   expect.Times,
+		result:  result{lastInsertId: expect.LastInsertId, rowsAffected: expect.RowsAffected},
+		err:     expect.Err,
+	}
+	if registered.times == 0 {
+		registered.times = 1
+	}
+	if expect.Columns != nil {
+		registered.set = &resultSet{columns: expect.Columns}
+		for _, row := range expect.Rows {
+			values := make([]driver.Value, len(row))
+			for i, value := range row {
+				converted, err := driver.DefaultParameterConverter.ConvertValue(value)
+				if err != nil {
+					panic(fmt.Sprintf("damtest: row value %v: %v", value, err))
+				}
+				values[i] = converted
+			}
+			registered.set.rows = append(registered.set.rows, values)
+		}
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.expectations = append(this.expectations, registered)
+}
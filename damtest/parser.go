@@ -0,0 +1,650 @@
+package damtest
+
+/**
+ * 测试用sql子集的词法与表达式解析
+ */
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenParam
+	tokenSymbol
+)
+
+type token struct {
+	kind tokenKind
+	/** 标识符已转为小写 **/
+	text string
+}
+
+func tokenize(query string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '-' && i+1 < len(query) && query[i+1] == '-', c == '#':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return nil, syntaxError(query[i:])
+			}
+			i += end + 4
+		case isIdentStart(c):
+			start := i
+			for i < len(query) && isIdentPart(query[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: strings.ToLower(query[start:i])})
+		case c == '`':
+			end := strings.IndexByte(query[i+1:], '`')
+			if end < 0 {
+				return nil, syntaxError(query[i:])
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: strings.ToLower(query[i+1 : i+1+end])})
+			i += end + 2
+		case c >= '0' && c <= '9', c == '-' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9' && expectsOperand(tokens):
+			start := i
+			i++
+			for i < len(query) && (query[i] >= '0' && query[i] <= '9' || query[i] == '.' || query[i] == 'e' || query[i] == 'E') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: query[start:i]})
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(query) {
+					return nil, syntaxError(query)
+				}
+				if query[i] == '\\' && i+1 < len(query) {
+					b.WriteByte(query[i+1])
+					i += 2
+					continue
+				}
+				if query[i] == c {
+					if i+1 < len(query) && query[i+1] == c {
+						b.WriteByte(c)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(query[i])
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenString, text: b.String()})
+		case c == '?':
+			tokens = append(tokens, token{kind: tokenParam, text: "?"})
+			i++
+		default:
+			if i+1 < len(query) {
+				if two := query[i : i+2]; two == "!=" || two == "<>" || two == ">=" || two == "<=" {
+					tokens = append(tokens, token{kind: tokenSymbol, text: two})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("(),=<>*+-;.", rune(c)) {
+				return nil, syntaxError(query[i:])
+			}
+			tokens = append(tokens, token{kind: tokenSymbol, text: string(c)})
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$'
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+/**
+ * 负号是否为数字的一部分：前面为运算符或起始位置
+ */
+func expectsOperand(tokens []token) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	last := tokens[len(tokens)-1]
+	return last.kind == tokenSymbol && last.text != ")" || last.kind == tokenIdent && isKeyword(last.text)
+}
+
+func isKeyword(text string) bool {
+	switch text {
+	case "and", "or", "not", "values", "set", "where", "limit", "offset", "in", "default", "between":
+		return true
+	}
+	return false
+}
+
+type parser struct {
+	query  string
+	tokens []token
+	pos    int
+	args   []driver.Value
+	argPos int
+}
+
+func (this *parser) done() bool {
+	return this.pos >= len(this.tokens)
+}
+
+func (this *parser) peek() token {
+	if this.done() {
+		return token{kind: tokenSymbol}
+	}
+	return this.tokens[this.pos]
+}
+
+func (this *parser) next() token {
+	t := this.peek()
+	this.pos++
+	return t
+}
+
+/**
+ * 下一个是关键字或符号 text 时前进
+ */
+func (this *parser) accept(text string) bool {
+	if t := this.peek(); !this.done() && (t.kind == tokenIdent || t.kind == tokenSymbol) && t.text == text {
+		this.pos++
+		return true
+	}
+	return false
+}
+
+func (this *parser) expect(text string) error {
+	if !this.accept(text) {
+		return this.errorf("expect %q", text)
+	}
+	return nil
+}
+
+func (this *parser) ident() (string, error) {
+	t := this.next()
+	if t.kind != tokenIdent {
+		return "", this.errorf("expect identifier")
+	}
+	/** db.table 取表名 **/
+	if this.accept(".") {
+		return this.ident()
+	}
+	return t.text, nil
+}
+
+/**
+ * '(' a, b(10), c ')'，忽略前缀长度
+ */
+func (this *parser) identList() ([]string, error) {
+	if err := this.expect("("); err != nil {
+		return nil, err
+	}
+	var names []string
+	for {
+		name, err := this.ident()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if this.accept("(") {
+			this.next()
+			if err := this.expect(")"); err != nil {
+				return nil, err
+			}
+		}
+		this.accept("asc")
+		this.accept("desc")
+		if this.accept(")") {
+			return names, nil
+		}
+		if err := this.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (this *parser) errorf(format string, args ...interface{}) error {
+	near := ""
+	if !this.done() {
+		near = this.tokens[this.pos].text
+	}
+	return syntaxError(fmt.Sprintf(format, args...) + " near '" + near + "'")
+}
+
+/**
+ * 表达式求值上下文：当前行、insert 的新值(values())与 last_insert_id
+ */
+type evalContext struct {
+	row          map[string]driver.Value
+	inserted     map[string]driver.Value
+	lastInsertId *int64
+}
+
+type expr func(ctx *evalContext) (driver.Value, error)
+
+/**
+ * expr := term { (+|-) term }
+ */
+func (this *parser) expr() (expr, error) {
+	left, err := this.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var sign float64
+		switch {
+		case this.accept("+"):
+			sign = 1
+		case this.accept("-"):
+			sign = -1
+		default:
+			return left, nil
+		}
+		right, err := this.term()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		left = func(ctx *evalContext) (driver.Value, error) {
+			a, err := l(ctx)
+			if err != nil {
+				return nil, err
+			}
+			b, err := r(ctx)
+			if err != nil || a == nil || b == nil {
+				return nil, err
+			}
+			return addValues(a, b, sign), nil
+		}
+	}
+}
+
+/**
+ * term := ? | 数字 | 字符串 | null | true | false | now() | values(col) | last_insert_id(expr) | 列名
+ */
+func (this *parser) term() (expr, error) {
+	t := this.next()
+	switch t.kind {
+	case tokenParam:
+		if this.argPos >= len(this.args) {
+			return nil, this.errorf("not enough arguments")
+		}
+		value := this.args[this.argPos]
+		this.argPos++
+		return constant(normalizeValue(value)), nil
+	case tokenNumber:
+		return constant(parseNumber(t.text)), nil
+	case tokenString:
+		return constant(t.text), nil
+	case tokenIdent:
+		switch t.text {
+		case "null":
+			return constant(nil), nil
+		case "true":
+			return constant(int64(1)), nil
+		case "false":
+			return constant(int64(0)), nil
+		case "current_timestamp", "now":
+			if this.accept("(") {
+				if err := this.expect(")"); err != nil {
+					return nil, err
+				}
+			}
+			return func(ctx *evalContext) (driver.Value, error) { return time.Now(), nil }, nil
+		case "values":
+			if err := this.expect("("); err != nil {
+				return nil, err
+			}
+			column, err := this.ident()
+			if err != nil {
+				return nil, err
+			}
+			if err := this.expect(")"); err != nil {
+				return nil, err
+			}
+			return func(ctx *evalContext) (driver.Value, error) { return ctx.inserted[column], nil }, nil
+		case "last_insert_id":
+			if err := this.expect("("); err != nil {
+				return nil, err
+			}
+			inner, err := this.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := this.expect(")"); err != nil {
+				return nil, err
+			}
+			return func(ctx *evalContext) (driver.Value, error) {
+				value, err := inner(ctx)
+				if err == nil && ctx.lastInsertId != nil {
+					if id, ok := toFloat(value); ok {
+						*ctx.lastInsertId = int64(id)
+					}
+				}
+				return value, err
+			}, nil
+		}
+		this.pos--
+		column, err := this.ident()
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx *evalContext) (driver.Value, error) {
+			value, ok := ctx.row[column]
+			if !ok && ctx.row != nil {
+				return nil, unknownColumnError(column)
+			}
+			return value, nil
+		}, nil
+	}
+	return nil, this.errorf("unexpected token")
+}
+
+func constant(value driver.Value) expr {
+	return func(ctx *evalContext) (driver.Value, error) { return value, nil }
+}
+
+type condition func(ctx *evalContext) (bool, error)
+
+/**
+ * or 连接的 and 条件，支持括号
+ */
+func (this *parser) condition() (condition, error) {
+	left, err := this.andCondition()
+	if err != nil {
+		return nil, err
+	}
+	for this.accept("or") {
+		right, err := this.andCondition()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		left = func(ctx *evalContext) (bool, error) {
+			if ok, err := l(ctx); ok || err != nil {
+				return ok, err
+			}
+			return r(ctx)
+		}
+	}
+	return left, nil
+}
+
+func (this *parser) andCondition() (condition, error) {
+	left, err := this.primaryCondition()
+	if err != nil {
+		return nil, err
+	}
+	for this.accept("and") {
+		right, err := this.primaryCondition()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		left = func(ctx *evalContext) (bool, error) {
+			if ok, err := l(ctx); !ok || err != nil {
+				return ok, err
+			}
+			return r(ctx)
+		}
+	}
+	return left, nil
+}
+
+func (this *parser) primaryCondition() (condition, error) {
+	if this.accept("(") {
+		inner, err := this.condition()
+		if err != nil {
+			return nil, err
+		}
+		return inner, this.expect(")")
+	}
+	if this.accept("not") {
+		inner, err := this.primaryCondition()
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx *evalContext) (bool, error) {
+			ok, err := inner(ctx)
+			return !ok, err
+		}, nil
+	}
+	left, err := this.expr()
+	if err != nil {
+		return nil, err
+	}
+	if this.accept("is") {
+		not := this.accept("not")
+		if err := this.expect("null"); err != nil {
+			return nil, err
+		}
+		return func(ctx *evalContext) (bool, error) {
+			value, err := left(ctx)
+			return (value == nil) != not, err
+		}, nil
+	}
+	not := this.accept("not")
+	if this.accept("in") {
+		if err := this.expect("("); err != nil {
+			return nil, err
+		}
+		var list []expr
+		for {
+			item, err := this.expr()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			if this.accept(")") {
+				break
+			}
+			if err := this.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		return func(ctx *evalContext) (bool, error) {
+			value, err := left(ctx)
+			if err != nil || value == nil {
+				return false, err
+			}
+			for _, item := range list {
+				candidate, err := item(ctx)
+				if err != nil {
+					return false, err
+				}
+				if c, ok := compareValues(value, candidate); ok && c == 0 {
+					return !not, nil
+				}
+			}
+			return not, nil
+		}, nil
+	}
+	if this.accept("between") {
+		low, err := this.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := this.expect("and"); err != nil {
+			return nil, err
+		}
+		high, err := this.expr()
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx *evalContext) (bool, error) {
+			value, err := left(ctx)
+			if err != nil {
+				return false, err
+			}
+			lowValue, _ := low(ctx)
+			highValue, _ := high(ctx)
+			a, okA := compareValues(value, lowValue)
+			b, okB := compareValues(value, highValue)
+			return okA && okB && (a >= 0 && b <= 0) != not, nil
+		}, nil
+	}
+	if not {
+		return nil, this.errorf("expect in or between after not")
+	}
+	operator := this.next()
+	if operator.kind != tokenSymbol {
+		return nil, this.errorf("expect comparison operator")
+	}
+	right, err := this.expr()
+	if err != nil {
+		return nil, err
+	}
+	var match func(c int) bool
+	switch operator.text {
+	case "=":
+		match = func(c int) bool { return c == 0 }
+	case "!=", "<>":
+		match = func(c int) bool { return c != 0 }
+	case "<":
+		match = func(c int) bool { return c < 0 }
+	case "<=":
+		match = func(c int) bool { return c <= 0 }
+	case ">":
+		match = func(c int) bool { return c > 0 }
+	case ">=":
+		match = func(c int) bool { return c >= 0 }
+	default:
+		return nil, this.errorf("unsupported operator %s", operator.text)
+	}
+	return func(ctx *evalContext) (bool, error) {
+		a, err := left(ctx)
+		if err != nil {
+			return false, err
+		}
+		b, err := right(ctx)
+		if err != nil {
+			return false, err
+		}
+		c, ok := compareValues(a, b)
+		return ok && match(c), nil
+	}, nil
+}
+
+/**
+ * 参数统一为 int64/float64/string/time.Time/nil
+ */
+func normalizeValue(value driver.Value) driver.Value {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	}
+	return value
+}
+
+func parseNumber(text string) driver.Value {
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i
+	}
+	f, _ := strconv.ParseFloat(text, 64)
+	return f
+}
+
+func toFloat(value driver.Value) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func addValues(a, b driver.Value, sign float64) driver.Value {
+	if x, ok := a.(int64); ok {
+		if y, ok := b.(int64); ok {
+			return x + int64(sign)*y
+		}
+	}
+	x, _ := toFloat(a)
+	y, _ := toFloat(b)
+	return x + sign*y
+}
+
+/**
+ * 比较两个值，任一为 NULL 时不可比较；数值与可解析的字符串按数值比较
+ */
+func compareValues(a, b driver.Value) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if x, ok := a.(int64); ok {
+		if y, ok := b.(int64); ok {
+			return compareInt(x, y), true
+		}
+	}
+	x, xNumber := toFloat(a)
+	y, yNumber := toFloat(b)
+	if xNumber || yNumber {
+		if !xNumber {
+			x, xNumber = parseFloat(a)
+		}
+		if !yNumber {
+			y, yNumber = parseFloat(b)
+		}
+		if xNumber && yNumber {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	if x, ok := a.(time.Time); ok {
+		if y, ok := b.(time.Time); ok {
+			return compareInt(x.UnixNano(), y.UnixNano()), true
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b)), true
+}
+
+func compareInt(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func parseFloat(value driver.Value) (float64, bool) {
+	text, ok := value.(string)
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	return f, err == nil
+}
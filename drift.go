@@ -0,0 +1,341 @@
+package dam
+
+/**
+ * 分库表结构漂移检查
+ * 从每个分库的 information_schema 读取表、列、索引与字符集，
+ * 与期望结构(或数据中心id最小的分库)比较并输出差异报告
+ */
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type Schema struct {
+	Tables map[string]*TableSchema `json:"tables"`
+}
+
+type TableSchema struct {
+	Name      string                  `json:"name"`
+	Engine    string                  `json:"engine"`
+	Collation string                  `json:"collation"`
+	Columns   map[string]ColumnSchema `json:"columns"`
+	Indexes   map[string]IndexSchema  `json:"indexes"`
+}
+
+type ColumnSchema struct {
+	Name         string  `json:"name"`
+	Position     int     `json:"position"`
+	ColumnType   string  `json:"column_type"`
+	Nullable     bool    `json:"nullable"`
+	Default      *string `json:"default"`
+	CharacterSet string  `json:"character_set,omitempty"`
+	Collation    string  `json:"collation,omitempty"`
+	Extra        string  `json:"extra,omitempty"`
+}
+
+type IndexSchema struct {
+	Name    string   `json:"name"`
+	Unique  bool     `json:"unique"`
+	Columns []string `json:"columns"`
+}
+
+func (this ColumnSchema) definition() string {
+	parts := []string{fmt.Sprintf("#%d", this.Position), this.ColumnType}
+	if this.Nullable {
+		parts = append(parts, "NULL")
+	} else {
+		parts = append(parts, "NOT NULL")
+	}
+	if this.Default != nil {
+		parts = append(parts, "DEFAULT '"+*this.Default+"'")
+	}
+	if this.CharacterSet != "" {
+		parts = append(parts, "CHARSET "+this.CharacterSet)
+	}
+	if this.Collation != "" {
+		parts = append(parts, "COLLATE "+this.Collation)
+	}
+	if this.Extra != "" {
+		parts = append(parts, this.Extra)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (this IndexSchema) definition() string {
+	kind := "KEY"
+	if this.Unique {
+		kind = "UNIQUE KEY"
+	}
+	return fmt.Sprintf("%s (%s)", kind, strings.Join(this.Columns, ", "))
+}
+
+/**
+ * 读取分库当前库(database())的表结构
+ */
+func ReadSchema(ctx context.Context, db *ShardDB) (*Schema, error) {
+	schema := &Schema{Tables: make(map[string]*TableSchema)}
+
+	var tables []struct {
+		Name      string         `db:"table_name"`
+		Engine    sql.NullString `db:"engine"`
+		Collation sql.NullString `db:"table_collation"`
+	}
+	err := db.SelectContext(ctx, &tables, "select table_name as table_name, engine as engine, table_collation as table_collation "+
+		"from information_schema.tables where table_schema=database() and table_type='BASE TABLE'")
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		schema.Tables[table.Name] = &TableSchema{
+			Name:      table.Name,
+			Engine:    table.Engine.String,
+			Collation: table.Collation.String,
+			Columns:   make(map[string]ColumnSchema),
+			Indexes:   make(map[string]IndexSchema),
+		}
+	}
+
+	var columns []struct {
+		Table        string         `db:"table_name"`
+		Name         string         `db:"column_name"`
+		Position     int            `db:"ordinal_position"`
+		ColumnType   string         `db:"column_type"`
+		Nullable     string         `db:"is_nullable"`
+		Default      sql.NullString `db:"column_default"`
+		CharacterSet sql.NullString `db:"character_set_name"`
+		Collation    sql.NullString `db:"collation_name"`
+		Extra        string         `db:"extra"`
+	}
+	err = db.SelectContext(ctx, &columns, "select table_name as table_name, column_name as column_name, "+
+		"ordinal_position as ordinal_position, column_type as column_type, is_nullable as is_nullable, "+
+		"column_default as column_default, character_set_name as character_set_name, collation_name as collation_name, "+
+		"extra as extra from information_schema.columns where table_schema=database()")
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range columns {
+		table, ok := schema.Tables[column.Table]
+		if !ok {
+			continue
+		}
+		var defaultValue *string
+		if column.Default.Valid {
+			value := column.Default.String
+			defaultValue = &value
+		}
+		table.Columns[column.Name] = ColumnSchema{
+			Name:         column.Name,
+			Position:     column.Position,
+			ColumnType:   column.ColumnType,
+			Nullable:     column.Nullable == "YES",
+			Default:      defaultValue,
+			CharacterSet: column.CharacterSet.String,
+			Collation:    column.Collation.String,
+			Extra:        column.Extra,
+		}
+	}
+
+	var indexes []struct {
+		Table     string `db:"table_name"`
+		Name      string `db:"index_name"`
+		NonUnique int    `db:"non_unique"`
+		Column    string `db:"column_name"`
+	}
+	err = db.SelectContext(ctx, &indexes, "select table_name as table_name, index_name as index_name, "+
+		"non_unique as non_unique, column_name as column_name from information_schema.statistics "+
+		"where table_schema=database() order by table_name, index_name, seq_in_index")
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		table, ok := schema.Tables[index.Table]
+		if !ok {
+			continue
+		}
+		indexSchema := table.Indexes[index.Name]
+		indexSchema.Name = index.Name
+		indexSchema.Unique = index.NonUnique == 0
+		indexSchema.Columns = append(indexSchema.Columns, index.Column)
+		table.Indexes[index.Name] = indexSchema
+	}
+	return schema, nil
+}
+
+type SchemaDifference struct {
+	Table string
+	/** table / engine / collation / column / index **/
+	Kind string
+	Name string
+	/** 为空表示缺失 **/
+	Expected string
+	Actual   string
+}
+
+func (this SchemaDifference) String() string {
+	target := this.Table
+	if this.Name != "" {
+		target += "." + this.Name
+	}
+	switch {
+	case this.Actual == "":
+		return fmt.Sprintf("%s %s: missing, expected %s", this.Kind, target, this.Expected)
+	case this.Expected == "":
+		return fmt.Sprintf("%s %s: unexpected %s", this.Kind, target, this.Actual)
+	}
+	return fmt.Sprintf("%s %s: expected %s, actual %s", this.Kind, target, this.Expected, this.Actual)
+}
+
+/**
+ * 比较两个结构，结果按表、类型、名称排序
+ */
+func DiffSchema(expected, actual *Schema) []SchemaDifference {
+	var differences []SchemaDifference
+	for name, expectedTable := range expected.Tables {
+		actualTable, ok := actual.Tables[name]
+		if !ok {
+			differences = append(differences, SchemaDifference{Table: name, Kind: "table", Expected: "present"})
+			continue
+		}
+		differences = append(differences, diffTable(expectedTable, actualTable)...)
+	}
+	for name := range actual.Tables {
+		if _, ok := expected.Tables[name]; !ok {
+			differences = append(differences, SchemaDifference{Table: name, Kind: "table", Actual: "present"})
+		}
+	}
+	sort.Slice(differences, func(i, j int) bool {
+		a, b := differences[i], differences[j]
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return differences
+}
+
+func diffTable(expected, actual *TableSchema) (differences []SchemaDifference) {
+	if expected.Engine != actual.Engine {
+		differences = append(differences, SchemaDifference{Table: expected.Name, Kind: "engine", Expected: expected.Engine, Actual: actual.Engine})
+	}
+	if expected.Collation != actual.Collation {
+		differences = append(differences, SchemaDifference{Table: expected.Name, Kind: "collation", Expected: expected.Collation, Actual: actual.Collation})
+	}
+	expectedColumns := make(map[string]string, len(expected.Columns))
+	for name, column := range expected.Columns {
+		expectedColumns[name] = column.definition()
+	}
+	actualColumns := make(map[string]string, len(actual.Columns))
+	for name, column := range actual.Columns {
+		actualColumns[name] = column.definition()
+	}
+	differences = append(differences, diffDefinitions(expected.Name, "column", expectedColumns, actualColumns)...)
+
+	expectedIndexes := make(map[string]string, len(expected.Indexes))
+	for name, index := range expected.Indexes {
+		expectedIndexes[name] = index.definition()
+	}
+	actualIndexes := make(map[string]string, len(actual.Indexes))
+	for name, index := range actual.Indexes {
+		actualIndexes[name] = index.definition()
+	}
+	return append(differences, diffDefinitions(expected.Name, "index", expectedIndexes, actualIndexes)...)
+}
+
+func diffDefinitions(table, kind string, expected, actual map[string]string) (differences []SchemaDifference) {
+	for name, definition := range expected {
+		if actualDefinition := actual[name]; actualDefinition != definition {
+			differences = append(differences, SchemaDifference{Table: table, Kind: kind, Name: name, Expected: definition, Actual: actualDefinition})
+		}
+	}
+	for name, definition := range actual {
+		if _, ok := expected[name]; !ok {
+			differences = append(differences, SchemaDifference{Table: table, Kind: kind, Name: name, Actual: definition})
+		}
+	}
+	return differences
+}
+
+type ShardDrift struct {
+	ShardId     int
+	Differences []SchemaDifference
+	Err         error
+}
+
+type DriftReport struct {
+	/** 作为参照的分库，使用期望结构时为 -1 **/
+	ReferenceShard int
+	Shards         []ShardDrift
+}
+
+/**
+ * 检查所有分库，expected 为空时以数据中心id最小的分库为参照
+ */
+func CheckDrift(ctx context.Context, manager IMysqlManager, expected *Schema) (*DriftReport, error) {
+	dbs := sortShards(manager.GetAllDbs())
+	report := &DriftReport{ReferenceShard: -1}
+	if expected == nil {
+		if len(dbs) == 0 {
+			return report, nil
+		}
+		reference, err := ReadSchema(ctx, dbs[0])
+		if err != nil {
+			return nil, fmt.Errorf("read reference shard %d: %v", dbs[0].ShardId(), err)
+		}
+		expected = reference
+		report.ReferenceShard = dbs[0].ShardId()
+		dbs = dbs[1:]
+	}
+	for _, db := range dbs {
+		drift := ShardDrift{ShardId: db.ShardId()}
+		if actual, err := ReadSchema(ctx, db); err != nil {
+			drift.Err = err
+		} else {
+			drift.Differences = DiffSchema(expected, actual)
+		}
+		report.Shards = append(report.Shards, drift)
+	}
+	return report, nil
+}
+
+/**
+ * 存在差异或读取失败
+ */
+func (this *DriftReport) HasDrift() bool {
+	for _, shard := range this.Shards {
+		if shard.Err != nil || len(shard.Differences) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *DriftReport) WriteTo(w io.Writer) (int64, error) {
+	counter := &countWriter{w: w}
+	reference := "expected schema"
+	if this.ReferenceShard >= 0 {
+		reference = fmt.Sprintf("shard %d", this.ReferenceShard)
+	}
+	fmt.Fprintf(counter, "reference: %s\n", reference)
+	for _, shard := range this.Shards {
+		switch {
+		case shard.Err != nil:
+			fmt.Fprintf(counter, "shard %d: error: %v\n", shard.ShardId, shard.Err)
+		case len(shard.Differences) == 0:
+			fmt.Fprintf(counter, "shard %d: ok\n", shard.ShardId)
+		default:
+			fmt.Fprintf(counter, "shard %d: %d difference(s)\n", shard.ShardId, len(shard.Differences))
+			for _, difference := range shard.Differences {
+				fmt.Fprintf(counter, "  %s\n", difference)
+			}
+		}
+	}
+	return counter.n, nil
+}
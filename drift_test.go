@@ -0,0 +1,74 @@
+package dam
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func testUserSchema() *Schema {
+	one := "1"
+	return &Schema{Tables: map[string]*TableSchema{
+		"user": {
+			Name: "user", Engine: "InnoDB", Collation: "utf8mb4_general_ci",
+			Columns: map[string]ColumnSchema{
+				"user_id": {Name: "user_id", Position: 1, ColumnType: "bigint(20)"},
+				"enabled": {Name: "enabled", Position: 2, ColumnType: "int(11)", Nullable: true, Default: &one},
+			},
+			Indexes: map[string]IndexSchema{
+				"PRIMARY":   {Name: "PRIMARY", Unique: true, Columns: []string{"user_id"}},
+				"user_name": {Name: "user_name", Unique: true, Columns: []string{"user_name", "delete_time"}},
+			},
+		},
+	}}
+}
+
+func TestDiffSchema(t *testing.T) {
+	expected := testUserSchema()
+	if differences := DiffSchema(expected, testUserSchema()); len(differences) != 0 {
+		t.Fatalf("aspect no differences, but get %v", differences)
+	}
+
+	actual := testUserSchema()
+	zero := "0"
+	user := actual.Tables["user"]
+	user.Collation = "utf8_general_ci"
+	user.Columns["enabled"] = ColumnSchema{Name: "enabled", Position: 2, ColumnType: "int(11)", Nullable: true, Default: &zero}
+	user.Columns["alias_name"] = ColumnSchema{Name: "alias_name", Position: 3, ColumnType: "char(255)", Nullable: true}
+	delete(user.Indexes, "user_name")
+	actual.Tables["order"] = &TableSchema{Name: "order"}
+
+	var got []string
+	for _, difference := range DiffSchema(expected, actual) {
+		got = append(got, difference.String())
+	}
+	want := []string{
+		"table order: unexpected present",
+		"collation user: expected utf8mb4_general_ci, actual utf8_general_ci",
+		"column user.alias_name: unexpected #3 char(255) NULL",
+		"column user.enabled: expected #2 int(11) NULL DEFAULT '1', actual #2 int(11) NULL DEFAULT '0'",
+		"index user.user_name: missing, expected UNIQUE KEY (user_name, delete_time)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("aspect:\n%s\nbut get:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestDriftReport(t *testing.T) {
+	report := &DriftReport{ReferenceShard: 0, Shards: []ShardDrift{{ShardId: 1}}}
+	if report.HasDrift() {
+		t.Error("aspect no drift")
+	}
+	report.Shards = append(report.Shards, ShardDrift{ShardId: 2, Differences: []SchemaDifference{
+		{Table: "user", Kind: "table", Expected: "present"},
+	}})
+	if !report.HasDrift() {
+		t.Error("aspect drift")
+	}
+	var buf bytes.Buffer
+	report.WriteTo(&buf)
+	want := "reference: shard 0\nshard 1: ok\nshard 2: 1 difference(s)\n  table user: missing, expected present\n"
+	if buf.String() != want {
+		t.Errorf("aspect:\n%s\nbut get:\n%s", want, buf.String())
+	}
+}
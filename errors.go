@@ -0,0 +1,154 @@
+package dam
+
+/**
+ * mysql错误分类
+ * ShardDB 返回的驱动错误按错误码包装为带类型的错误，可用 errors.Is 判断，
+ * errors.As 仍可取得原始的 *mysql.MySQLError：
+ *   if errors.Is(err, dam.ErrDuplicateKey) { ... }
+ *   var duplicate *dam.DuplicateKeyError
+ *   if errors.As(err, &duplicate) && duplicate.Key == "user_name" { ... }
+ */
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	ErrDuplicateKey    = errors.New("duplicate key")
+	ErrDeadlock        = errors.New("deadlock")
+	ErrLockWaitTimeout = errors.New("lock wait timeout")
+	ErrConnection      = errors.New("connection error")
+	ErrReadOnly        = errors.New("database is read only")
+	/** 违反约束：唯一键(ErrDuplicateKey 同样满足)、外键、非空、检查约束 **/
+	ErrConstraint = errors.New("constraint violation")
+	/** 数据与列类型不符：超出范围、截断、格式错误 **/
+	ErrData = errors.New("invalid data")
+)
+
+const (
+	mysqlErrTooManyConnections = 1040
+	mysqlErrBadNull            = 1048
+	mysqlErrServerShutdown     = 1053
+	mysqlErrDuplicateEntry     = 1062
+	mysqlErrLockWaitTimeout    = 1205
+	mysqlErrDeadlock           = 1213
+	mysqlErrNoReferencedRowOld = 1216
+	mysqlErrRowIsReferencedOld = 1217
+	mysqlErrOutOfRange         = 1264
+	mysqlErrDataTruncated      = 1265
+	mysqlErrOptionPrevents     = 1290
+	mysqlErrTruncatedValue     = 1292
+	mysqlErrNoDefault          = 1364
+	mysqlErrIncorrectValue     = 1366
+	mysqlErrIllegalValue       = 1367
+	mysqlErrDataTooLong        = 1406
+	mysqlErrRowIsReferenced    = 1451
+	mysqlErrNoReferencedRow    = 1452
+	mysqlErrReadOnlyTx         = 1792
+	mysqlErrReadOnlyMode       = 1836
+	mysqlErrCheckConstraint    = 3819
+)
+
+/**
+ * 带分类的错误，Error 保持原始错误信息
+ */
+type MysqlError struct {
+	/** ErrDeadlock 等分类 **/
+	Kind error
+	Err  error
+}
+
+func (this *MysqlError) Error() string {
+	return this.Err.Error()
+}
+
+func (this *MysqlError) Is(target error) bool {
+	return target == this.Kind
+}
+
+func (this *MysqlError) Unwrap() error {
+	return this.Err
+}
+
+type DuplicateKeyError struct {
+	/** 冲突的索引名，如 PRIMARY、user_name **/
+	Key string
+	/** 冲突的值，联合索引以 - 连接 **/
+	Value string
+	Err   error
+}
+
+func (this *DuplicateKeyError) Error() string {
+	return this.Err.Error()
+}
+
+func (this *DuplicateKeyError) Is(target error) bool {
+	return target == ErrDuplicateKey || target == ErrConstraint
+}
+
+func (this *DuplicateKeyError) Unwrap() error {
+	return this.Err
+}
+
+/** 5.7: for key 'user_name'，8.0: for key 'user.user_name' **/
+var duplicateEntryPattern = regexp.MustCompile(`^Duplicate entry '(.*)' for key '(.+)'$`)
+
+/**
+ * 按错误码包装驱动错误，无法识别或已分类的错误原样返回
+ */
+func ClassifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var classified *MysqlError
+	var duplicate *DuplicateKeyError
+	if errors.As(err, &classified) || errors.As(err, &duplicate) {
+		return err
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case mysqlErrDuplicateEntry:
+			duplicate := &DuplicateKeyError{Err: err}
+			if match := duplicateEntryPattern.FindStringSubmatch(mysqlErr.Message); match != nil {
+				duplicate.Value = match[1]
+				duplicate.Key = match[2][strings.LastIndex(match[2], ".")+1:]
+			}
+			return duplicate
+		case mysqlErrDeadlock:
+			return &MysqlError{Kind: ErrDeadlock, Err: err}
+		case mysqlErrLockWaitTimeout:
+			return &MysqlError{Kind: ErrLockWaitTimeout, Err: err}
+		case mysqlErrOptionPrevents, mysqlErrReadOnlyTx, mysqlErrReadOnlyMode:
+			return &MysqlError{Kind: ErrReadOnly, Err: err}
+		case mysqlErrTooManyConnections, mysqlErrServerShutdown:
+			return &MysqlError{Kind: ErrConnection, Err: err}
+		case mysqlErrBadNull, mysqlErrNoDefault, mysqlErrNoReferencedRowOld, mysqlErrRowIsReferencedOld,
+			mysqlErrRowIsReferenced, mysqlErrNoReferencedRow, mysqlErrCheckConstraint:
+			return &MysqlError{Kind: ErrConstraint, Err: err}
+		case mysqlErrOutOfRange, mysqlErrDataTruncated, mysqlErrTruncatedValue, mysqlErrIncorrectValue,
+			mysqlErrIllegalValue, mysqlErrDataTooLong:
+			return &MysqlError{Kind: ErrData, Err: err}
+		}
+		return err
+	}
+	var netErr net.Error
+	if errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr) {
+		return &MysqlError{Kind: ErrConnection, Err: err}
+	}
+	return err
+}
+
+/**
+ * 死锁、锁等待超时与连接错误可重试；连接错误时写操作可能已执行，重试前需确认幂等
+ */
+func IsRetryable(err error) bool {
+	err = ClassifyError(err)
+	return errors.Is(err, ErrDeadlock) || errors.Is(err, ErrLockWaitTimeout) || errors.Is(err, ErrConnection)
+}
@@ -0,0 +1,73 @@
+package dam
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestClassifyError(t *testing.T) {
+	datas := []struct {
+		err       error
+		kind      error
+		retryable bool
+	}{
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'yang-0' for key 'user_name'"}, ErrDuplicateKey, false},
+		{&mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}, ErrDeadlock, true},
+		{&mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, ErrLockWaitTimeout, true},
+		{&mysql.MySQLError{Number: 1290, Message: "running with the --read-only option"}, ErrReadOnly, false},
+		{&mysql.MySQLError{Number: 1836, Message: "Running in read-only mode"}, ErrReadOnly, false},
+		{&mysql.MySQLError{Number: 1040, Message: "Too many connections"}, ErrConnection, true},
+		{mysql.ErrInvalidConn, ErrConnection, true},
+		{fmt.Errorf("exec: %w", driver.ErrBadConn), ErrConnection, true},
+		{&mysql.MySQLError{Number: 1048, Message: "Column 'user_name' cannot be null"}, ErrConstraint, false},
+		{&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails"}, ErrConstraint, false},
+		{&mysql.MySQLError{Number: 1406, Message: "Data too long for column 'user_name' at row 1"}, ErrData, false},
+		{&mysql.MySQLError{Number: 1264, Message: "Out of range value for column 'enabled' at row 1"}, ErrData, false},
+	}
+	for _, data := range datas {
+		err := ClassifyError(data.err)
+		if !errors.Is(err, data.kind) {
+			t.Errorf("aspect %v for %v, but get %v", data.kind, data.err, err)
+		}
+		if !errors.Is(err, data.err) {
+			t.Errorf("aspect original error %v to be wrapped", data.err)
+		}
+		if IsRetryable(data.err) != data.retryable {
+			t.Errorf("aspect retryable %v for %v", data.retryable, data.err)
+		}
+		if ClassifyError(err) != err {
+			t.Errorf("aspect classified error unchanged for %v", err)
+		}
+	}
+
+	for _, err := range []error{nil, sql.ErrNoRows, &mysql.MySQLError{Number: 1146, Message: "Table doesn't exist"}} {
+		if ClassifyError(err) != err {
+			t.Errorf("aspect %v unchanged", err)
+		}
+	}
+}
+
+func TestDuplicateKeyError(t *testing.T) {
+	for _, message := range []string{
+		"Duplicate entry 'yang-0' for key 'user_name'",
+		"Duplicate entry 'yang-0' for key 'user.user_name'",
+	} {
+		err := ClassifyError(&mysql.MySQLError{Number: 1062, Message: message})
+		var duplicate *DuplicateKeyError
+		if !errors.As(err, &duplicate) || duplicate.Key != "user_name" || duplicate.Value != "yang-0" || !errors.Is(err, ErrConstraint) {
+			t.Errorf("unexpected duplicate key error %+v for %s", duplicate, message)
+		}
+		if want := "Error 1062: " + message; err.Error() != want {
+			t.Errorf("aspect original message %q, but get %q", want, err.Error())
+		}
+		var mysqlErr *mysql.MySQLError
+		if !errors.As(err, &mysqlErr) || mysqlErr.Number != 1062 {
+			t.Error("aspect errors.As to reach *mysql.MySQLError")
+		}
+	}
+}
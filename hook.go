@@ -0,0 +1,200 @@
+package dam
+
+/**
+ * sql执行钩子
+ * ShardDB 的每次查询前后依次回调 MysqlConfig.Hooks，
+ * BeforeQuery 返回的 context 会传给实际执行和 AfterQuery，用于链路追踪等场景
+ */
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+type IQueryHook interface {
+	BeforeQuery(ctx context.Context, event *QueryEvent) context.Context
+	AfterQuery(ctx context.Context, event *QueryEvent)
+}
+
+type QueryEvent struct {
+	ShardId   int
+	Operation string
+	Query     string
+	Args      []interface{}
+	StartTime time.Time
+	/** 以下在 AfterQuery 时可用 **/
+	Duration time.Duration
+	Err      error
+
+	fingerprint string
+}
+
+/**
+ * 语句指纹，字面量替换为 ?
+ */
+func (this *QueryEvent) Fingerprint() string {
+	if this.fingerprint == "" {
+		this.fingerprint = Fingerprint(this.Query)
+	}
+	return this.fingerprint
+}
+
+/**
+ * sql归一化：字符串与数字字面量替换为 ?，in列表与多行values折叠，空白合并，转小写
+ * 例: "SELECT * FROM user WHERE id IN (1, 2, 3) AND name='a'" => "select * from user where id in (?+) and name=?"
+ */
+func Fingerprint(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	space := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"':
+			i = skipQuoted(query, i)
+			c = '?'
+		case c == '-' && i+1 < len(query) && query[i+1] == '-', c == '#':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+			space = true
+			continue
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(query)
+			}
+			space = true
+			continue
+		case c >= '0' && c <= '9' && (space || !isIdentByte(prevByte(b.String()))):
+			for i+1 < len(query) && (isIdentByte(query[i+1]) || query[i+1] == '.') {
+				i++
+			}
+			c = '?'
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			continue
+		case c >= 'A' && c <= 'Z':
+			c += 'a' - 'A'
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteByte(c)
+	}
+	return collapseLists(b.String())
+}
+
+/**
+ * 返回引号结束位置，支持反斜杠转义和重复引号
+ */
+func skipQuoted(query string, start int) int {
+	quote := query[start]
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return len(query) - 1
+}
+
+func prevByte(s string) byte {
+	if len(s) == 0 {
+		return ' '
+	}
+	return s[len(s)-1]
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+/**
+ * (?, ?, ?) => (?+)，values (?+), (?+) => values (?+)
+ */
+func collapseLists(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '(' {
+			if end, ok := placeholderList(s, i); ok {
+				b.WriteString("(?+)")
+				// 多行values只保留第一组
+				for {
+					next := skipSpaces(s, end+1)
+					if next >= len(s) || s[next] != ',' {
+						break
+					}
+					next = skipSpaces(s, next+1)
+					if next >= len(s) || s[next] != '(' {
+						break
+					}
+					rowEnd, isRow := placeholderList(s, next)
+					if !isRow {
+						break
+					}
+					end = rowEnd
+				}
+				i = end
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func skipSpaces(s string, i int) int {
+	for i < len(s) && s[i] == ' ' {
+		i++
+	}
+	return i
+}
+
+/**
+ * 判断 s[start] 开始的括号内是否只有 ? 与逗号
+ */
+func placeholderList(s string, start int) (end int, ok bool) {
+	count := 0
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '?':
+			count++
+		case ',', ' ':
+		case ')':
+			return i, count > 0
+		default:
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+/**
+ * 依次回调 before，返回传递给执行与 after 的 context
+ */
+func runBeforeHooks(ctx context.Context, hooks []IQueryHook, event *QueryEvent) context.Context {
+	for _, hook := range hooks {
+		ctx = hook.BeforeQuery(ctx, event)
+	}
+	return ctx
+}
+
+/**
+ * 逆序回调 after
+ */
+func runAfterHooks(ctx context.Context, hooks []IQueryHook, event *QueryEvent) {
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i].AfterQuery(ctx, event)
+	}
+}
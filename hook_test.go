@@ -0,0 +1,67 @@
+package dam
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	var testData = []struct {
+		query       string
+		fingerprint string
+	}{
+		{"select * from user where user_id=? and delete_time=0 limit 1", "select * from user where user_id=? and delete_time=? limit ?"},
+		{"SELECT  *\n FROM user WHERE user_name = 'yang''s' AND id IN (1, 2, 3)", "select * from user where user_name = ? and id in (?+)"},
+		{"insert into user(user_id, user_name)values(?, ?), (?, ?),(?, ?)", "insert into user(user_id, user_name)values(?+)"},
+		{"select t1.id from user2 t1 -- comment\nwhere t1.a = \"x\\\"y\" /* hint */ and b=-1.5e3", "select t1.id from user2 t1 where t1.a = ? and b=-?"},
+		{"update user set enabled=0x1F where id=?", "update user set enabled=? where id=?"},
+	}
+	for _, data := range testData {
+		if fingerprint := Fingerprint(data.query); fingerprint != data.fingerprint {
+			t.Errorf("aspect fingerprint of %q is %q, but get %q", data.query, data.fingerprint, fingerprint)
+		}
+	}
+}
+
+type recordQueryHook struct {
+	name    string
+	records *[]string
+}
+
+type recordHookKey struct{}
+
+func (this *recordQueryHook) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	*this.records = append(*this.records, "before "+this.name)
+	return context.WithValue(ctx, recordHookKey{}, this.name)
+}
+
+func (this *recordQueryHook) AfterQuery(ctx context.Context, event *QueryEvent) {
+	*this.records = append(*this.records, "after "+this.name+" "+ctx.Value(recordHookKey{}).(string)+" "+event.Err.Error())
+}
+
+func TestShardDBHooks(t *testing.T) {
+	var records []string
+	db := newShardDB(1, "127.0.0.1:3306", nil, MysqlConfig{Hooks: []IQueryHook{
+		&recordQueryHook{name: "a", records: &records},
+		&recordQueryHook{name: "b", records: &records},
+	}}, NopLogger())
+	queryErr := errors.New("failed")
+	err := db.do(context.Background(), operationExec, "delete from user", nil, func(ctx context.Context) error {
+		records = append(records, "exec "+ctx.Value(recordHookKey{}).(string))
+		return queryErr
+	})
+	if err != queryErr {
+		t.Errorf("aspect error %v, but get %v", queryErr, err)
+	}
+	want := []string{"before a", "before b", "exec b", "after b b failed", "after a b failed"}
+	if len(records) != len(want) {
+		t.Fatalf("aspect %v, but get %v", want, records)
+	}
+	for i := range want {
+		if records[i] != want[i] {
+			t.Errorf("aspect %v, but get %v", want, records)
+			break
+		}
+	}
+}
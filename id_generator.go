@@ -0,0 +1,96 @@
+package dam
+
+/**
+ * id生成器
+ * snowflake: 默认实现，由 IMysqlManager.IdGenerator 提供，见 snowflake.go
+ * ulid: 按时间有序的26位字符串id，不支持数值id
+ * segment: 号段分配，从mysql表预取区间，生成连续的数值id，见 segment.go
+ */
+
+import (
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrNumericIdUnsupported = errors.New("id generator does not produce numeric ids")
+
+type IIdGenerator interface {
+	NextId() (int64, error)
+	NextIdString() (string, error)
+}
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+/**
+ * 48位毫秒时间戳 + 80位随机数，同一毫秒内随机部分递增以保证单调
+ */
+type ulidIdGenerator struct {
+	mutex   sync.Mutex
+	lastMs  int64
+	entropy [10]byte
+}
+
+func NewUlidIdGenerator() IIdGenerator {
+	return &ulidIdGenerator{}
+}
+
+func (this *ulidIdGenerator) NextId() (int64, error) {
+	return 0, ErrNumericIdUnsupported
+}
+
+func (this *ulidIdGenerator) NextIdString() (string, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	ms := timeNow().UnixNano() / int64(time.Millisecond)
+	if ms <= this.lastMs {
+		/** 同一毫秒或时钟回拨，沿用上次时间并递增随机部分 **/
+		ms = this.lastMs
+		if !incrementBytes(this.entropy[:]) {
+			return "", errors.New("ulid entropy overflow within one millisecond")
+		}
+	} else if _, err := rand.Read(this.entropy[:]); err != nil {
+		return "", err
+	}
+	this.lastMs = ms
+
+	var id [16]byte
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> uint(40-8*i))
+	}
+	copy(id[6:], this.entropy[:])
+	return encodeUlid(id), nil
+}
+
+/**
+ * 大端递增，溢出返回 false
+ */
+func incrementBytes(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * 128位按 Crockford base32 编码为26个字符(130位)，首字符高2位补零，只含id的高3位，因此不超过 '7'
+ */
+func encodeUlid(id [16]byte) string {
+	var out [26]byte
+	for i := 0; i < 26; i++ {
+		/** 第 i 个字符对应的位区间 [5i-2, 5i+3) **/
+		var value byte
+		for bit := 5*i - 2; bit < 5*i+3; bit++ {
+			value <<= 1
+			if bit >= 0 && id[bit/8]&(0x80>>uint(bit%8)) != 0 {
+				value |= 1
+			}
+		}
+		out[i] = crockfordAlphabet[value]
+	}
+	return string(out[:])
+}
@@ -0,0 +1,98 @@
+package dam
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestUlidIdGenerator(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	now := time.Unix(1600000000, 0)
+	timeNow = func() time.Time { return now }
+
+	generator := NewUlidIdGenerator()
+	if _, err := generator.NextId(); err != ErrNumericIdUnsupported {
+		t.Errorf("aspect ErrNumericIdUnsupported, but get %v", err)
+	}
+	var ids []string
+	for i := 0; i < 100; i++ {
+		if i == 50 {
+			now = now.Add(time.Millisecond)
+		}
+		id, err := generator.NextIdString()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(id) != 26 {
+			t.Fatalf("aspect 26 chars, but get %q", id)
+		}
+		ids = append(ids, id)
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Errorf("aspect monotonic ids, but get %v", ids)
+	}
+	/** 1600000000000 ms 的 ulid 时间部分 **/
+	if ids[0][:10] != "01EJ3PX000" {
+		t.Errorf("aspect time prefix 01EJ3PX000, but get %s", ids[0][:10])
+	}
+}
+
+func TestEncodeUlid(t *testing.T) {
+	var max [16]byte
+	for i := range max {
+		max[i] = 0xff
+	}
+	if id := encodeUlid(max); id != "7ZZZZZZZZZZZZZZZZZZZZZZZZZ" {
+		t.Errorf("aspect max ulid, but get %s", id)
+	}
+	if id := encodeUlid([16]byte{}); id != "00000000000000000000000000" {
+		t.Errorf("aspect zero ulid, but get %s", id)
+	}
+	/** 首字符取高3位，第二个字符取其后5位 **/
+	if id := encodeUlid([16]byte{0xa0}); id[:2] != "50" {
+		t.Errorf("aspect ulid prefix 50, but get %s", id)
+	}
+	if id := encodeUlid([16]byte{0x1f}); id[:2] != "0Z" {
+		t.Errorf("aspect ulid prefix 0Z, but get %s", id)
+	}
+}
+
+type stubIdGenerator struct {
+	id  int64
+	err error
+}
+
+func (this *stubIdGenerator) NextId() (int64, error) {
+	this.id++
+	return this.id, this.err
+}
+
+func (this *stubIdGenerator) NextIdString() (string, error) {
+	return "id-string", this.err
+}
+
+func TestSetGeneratedId(t *testing.T) {
+	var entity struct {
+		Id   int64
+		Code string
+		Rate float64
+	}
+	value := reflect.ValueOf(&entity).Elem()
+	generator := &stubIdGenerator{}
+	if err := setGeneratedId(value.Field(0), generator); err != nil || entity.Id != 1 {
+		t.Errorf("aspect id 1, but get %d, %v", entity.Id, err)
+	}
+	if err := setGeneratedId(value.Field(1), generator); err != nil || entity.Code != "id-string" {
+		t.Errorf("aspect code id-string, but get %q, %v", entity.Code, err)
+	}
+	if err := setGeneratedId(value.Field(2), generator); err == nil {
+		t.Error("aspect error for float id")
+	}
+	generator.err = ErrWorkerLeaseLost
+	if err := setGeneratedId(value.Field(0), generator); !errors.Is(err, ErrWorkerLeaseLost) {
+		t.Errorf("aspect ErrWorkerLeaseLost, but get %v", err)
+	}
+}
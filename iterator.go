@@ -0,0 +1,180 @@
+package dam
+
+/**
+ * 跨分库流式遍历
+ * 按数据中心id顺序逐个分库，以主键 keyset 分页(id > last order by id limit n)，每次只解码一行：
+ *   it := users.Iterate(ctx, dam.IterateOptions{From: checkpoint})
+ *   defer it.Close()
+ *   var user User
+ *   for it.Next(&user) {
+ *       export(user)
+ *       save(it.Checkpoint())
+ *   }
+ *   if err := it.Err(); err != nil { ... }
+ */
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const defaultIterateBatchSize = 500
+
+/**
+ * 遍历位置，可序列化保存后通过 IterateOptions.From 续传
+ */
+type Checkpoint struct {
+	ShardId int `json:"shard_id"`
+	/** 分库内最后处理的主键，为空表示从该分库开头开始 **/
+	LastId string `json:"last_id"`
+}
+
+type IterateOptions struct {
+	/** 每页行数，默认500 **/
+	BatchSize int
+	Scope     DeletedScope
+	/** 从该位置之后继续 **/
+	From *Checkpoint
+}
+
+type RowIterator struct {
+	repository *Repository
+	ctx        context.Context
+	options    IterateOptions
+	shards     []*ShardDB
+	/** 当前分库在 shards 中的下标 **/
+	shard int
+	/** 当前分库最后一行的主键，nil 表示从头开始 **/
+	lastId     interface{}
+	rows       *sqlx.Rows
+	fetched    int
+	checkpoint Checkpoint
+	err        error
+}
+
+func (this *Repository) Iterate(ctx context.Context, options IterateOptions) *RowIterator {
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultIterateBatchSize
+	}
+	iterator := &RowIterator{
+		repository: this,
+		ctx:        ctx,
+		options:    options,
+		shards:     sortShards(this.manager.GetAllDbs()),
+	}
+	if from := options.From; from != nil {
+		for iterator.shard < len(iterator.shards) && iterator.shards[iterator.shard].ShardId() < from.ShardId {
+			iterator.shard++
+		}
+		iterator.checkpoint = *from
+		if iterator.shard < len(iterator.shards) && iterator.shards[iterator.shard].ShardId() == from.ShardId && from.LastId != "" {
+			iterator.lastId, iterator.err = this.parseId(from.LastId)
+		}
+	}
+	return iterator
+}
+
+/**
+ * 读取下一行到 dest(实体指针)，遍历结束或出错时返回 false
+ */
+func (this *RowIterator) Next(dest interface{}) bool {
+	if this.err != nil {
+		return false
+	}
+	value, err := this.repository.entityValue(dest)
+	if err != nil {
+		this.err = err
+		return false
+	}
+	for this.shard < len(this.shards) {
+		db := this.shards[this.shard]
+		if this.rows == nil {
+			query, args := this.repository.pageQuery(this.lastId, this.options).selectSQL(this.repository.columnNames())
+			if this.rows, this.err = db.QueryxContext(this.ctx, query, args...); this.err != nil {
+				this.err = fmt.Errorf("shard %d: %w", db.ShardId(), this.err)
+				return false
+			}
+			this.fetched = 0
+		}
+		if this.rows.Next() {
+			if err := this.rows.StructScan(dest); err != nil {
+				this.err = fmt.Errorf("shard %d: %w", db.ShardId(), err)
+				this.Close()
+				return false
+			}
+			this.fetched++
+			this.lastId = value.FieldByIndex(this.repository.id.index).Interface()
+			this.checkpoint = Checkpoint{ShardId: db.ShardId(), LastId: fmt.Sprint(this.lastId)}
+			return true
+		}
+		err := this.rows.Err()
+		this.Close()
+		if err != nil {
+			this.err = fmt.Errorf("shard %d: %w", db.ShardId(), err)
+			return false
+		}
+		if this.fetched < this.options.BatchSize {
+			/** 不足一页，当前分库结束 **/
+			this.shard++
+			this.lastId = nil
+		}
+	}
+	return false
+}
+
+/**
+ * 最后一次 Next 返回的行之后的位置
+ */
+func (this *RowIterator) Checkpoint() Checkpoint {
+	return this.checkpoint
+}
+
+func (this *RowIterator) Err() error {
+	return this.err
+}
+
+/**
+ * 释放当前分页的连接，提前结束遍历时需调用
+ */
+func (this *RowIterator) Close() error {
+	if this.rows == nil {
+		return nil
+	}
+	err := this.rows.Close()
+	this.rows = nil
+	return err
+}
+
+func (this *Repository) pageQuery(lastId interface{}, options IterateOptions) *Query {
+	query := Table(this.config.Table).OrderBy(this.id.name).Limit(options.BatchSize)
+	query.scope = options.Scope
+	if lastId != nil {
+		query.Where(this.id.name+">?", lastId)
+	}
+	return query
+}
+
+func (this *Repository) columnNames() []string {
+	names := make([]string, 0, len(this.columns))
+	for _, column := range this.columns {
+		names = append(names, column.name)
+	}
+	return names
+}
+
+/**
+ * checkpoint 中的主键按主键字段类型还原，避免字符串与整数比较时的精度问题
+ */
+func (this *Repository) parseId(id string) (interface{}, error) {
+	switch this.typ.FieldByIndex(this.id.index).Type.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(id, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(id, 10, 64)
+	}
+	return id, nil
+}
@@ -0,0 +1,72 @@
+package dam_test
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	dam "github.com/seanbit/godam"
+)
+
+/**
+ * 分库0: 5行(最后一页不足)，分库1: 4行(恰好整页)及1行已软删除，每页2行
+ */
+func newIterateRepository(t *testing.T) *dam.Repository {
+	h, repository := newUserRepository(t, dam.MysqlConfig{})
+	rows := map[int][]int{0: {1, 2, 3, 4, 5}, 1: {11, 12, 13, 14}}
+	for shardId, ids := range rows {
+		for _, id := range ids {
+			row := map[string]interface{}{"user_id": id, "user_name": strconv.Itoa(id), "password": "", "alias_name": ""}
+			if err := h.LoadFixtures(shardId, "user", row); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	deleted := map[string]interface{}{"user_id": 15, "user_name": "15", "password": "", "alias_name": "", "delete_time": time.Now().UnixNano()}
+	if err := h.LoadFixtures(1, "user", deleted); err != nil {
+		t.Fatal(err)
+	}
+	return repository
+}
+
+func iterateIds(t *testing.T, repository *dam.Repository, from *dam.Checkpoint) (ids []int, checkpoints []dam.Checkpoint) {
+	iterator := repository.Iterate(context.Background(), dam.IterateOptions{BatchSize: 2, From: from})
+	defer iterator.Close()
+	var entity user
+	for iterator.Next(&entity) {
+		ids = append(ids, entity.UserId)
+		checkpoints = append(checkpoints, iterator.Checkpoint())
+	}
+	if err := iterator.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids, checkpoints
+}
+
+func TestRepositoryIterate(t *testing.T) {
+	repository := newIterateRepository(t)
+	ids, checkpoints := iterateIds(t, repository, nil)
+	if want := []int{1, 2, 3, 4, 5, 11, 12, 13, 14}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("aspect %v, but get %v", want, ids)
+	}
+	if checkpoint := checkpoints[2]; checkpoint != (dam.Checkpoint{ShardId: 0, LastId: "3"}) {
+		t.Errorf("aspect checkpoint {0 3}, but get %+v", checkpoint)
+	}
+
+	/** 从分库中间续传 **/
+	ids, _ = iterateIds(t, repository, &checkpoints[2])
+	if want := []int{4, 5, 11, 12, 13, 14}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("aspect %v after {0 3}, but get %v", want, ids)
+	}
+	/** 从分库最后一行续传，下一行为下一分库的第一行 **/
+	ids, _ = iterateIds(t, repository, &checkpoints[4])
+	if want := []int{11, 12, 13, 14}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("aspect %v after %+v, but get %v", want, checkpoints[4], ids)
+	}
+	/** 从最后一页的末行续传，没有剩余的行 **/
+	if ids, _ = iterateIds(t, repository, &checkpoints[8]); len(ids) != 0 {
+		t.Errorf("aspect no rows after %+v, but get %v", checkpoints[8], ids)
+	}
+}
@@ -0,0 +1,58 @@
+package dam
+
+import (
+	"context"
+	"testing"
+)
+
+/**
+ * 只提供 GetAllDbs 的 manager
+ */
+type shardsManager struct {
+	IMysqlManager
+	dbs []*ShardDB
+}
+
+func (this *shardsManager) GetAllDbs() []*ShardDB {
+	return this.dbs
+}
+
+func TestRepositoryPageQuery(t *testing.T) {
+	repository := NewRepository(nil, &User{}, RepositoryConfig{Table: "user", IdColumn: "user_id", ShardKeyColumn: "user_name"})
+	query, args := repository.pageQuery(nil, IterateOptions{BatchSize: 100}).selectSQL([]string{"user_id"})
+	if want := "select user_id from user where delete_time=0 order by user_id limit 100"; query != want || len(args) != 0 {
+		t.Errorf("aspect %s, but get %s %v", want, query, args)
+	}
+	query, args = repository.pageQuery(int64(42), IterateOptions{BatchSize: 100, Scope: ScopeWithDeleted}).selectSQL([]string{"user_id"})
+	if want := "select user_id from user where (user_id>?) order by user_id limit 100"; query != want || len(args) != 1 || args[0] != int64(42) {
+		t.Errorf("aspect %s, but get %s %v", want, query, args)
+	}
+}
+
+func TestRepositoryIterateFrom(t *testing.T) {
+	manager := &shardsManager{dbs: []*ShardDB{{shardId: 2}, {shardId: 0}, {shardId: 1}}}
+	repository := NewRepository(manager, &User{}, RepositoryConfig{Table: "user", IdColumn: "user_id", ShardKeyColumn: "user_name"})
+
+	iterator := repository.Iterate(context.Background(), IterateOptions{From: &Checkpoint{ShardId: 1, LastId: "6612345678901234567"}})
+	if iterator.shard != 1 || iterator.lastId != int64(6612345678901234567) || iterator.options.BatchSize != defaultIterateBatchSize {
+		t.Errorf("unexpected iterator shard %d last id %v", iterator.shard, iterator.lastId)
+	}
+	if checkpoint := iterator.Checkpoint(); checkpoint.ShardId != 1 {
+		t.Errorf("aspect checkpoint on shard 1, but get %+v", checkpoint)
+	}
+
+	/** 分库已不存在时从下一个分库开头开始 **/
+	iterator = repository.Iterate(context.Background(), IterateOptions{From: &Checkpoint{ShardId: 3, LastId: "5"}})
+	if iterator.shard != 3 || iterator.lastId != nil || iterator.Next(&User{}) {
+		t.Errorf("aspect exhausted iterator, but get shard %d last id %v", iterator.shard, iterator.lastId)
+	}
+
+	iterator = repository.Iterate(context.Background(), IterateOptions{From: &Checkpoint{ShardId: 0, LastId: "abc"}})
+	if iterator.Next(&User{}) || iterator.Err() == nil {
+		t.Error("aspect error for invalid checkpoint id")
+	}
+	iterator = repository.Iterate(context.Background(), IterateOptions{})
+	if iterator.Next(User{}) || iterator.Err() == nil {
+		t.Error("aspect error for non-pointer dest")
+	}
+}
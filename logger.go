@@ -0,0 +1,155 @@
+package dam
+
+/**
+ * 结构化日志
+ * 管理器通过 MysqlConfig.Logger / RedisConfig.Logger 注入，未注入时使用标准库 log 输出到 stderr
+ * keyvals 为 key, value 交替的字段，如 logger.Info("mysql shard opened", "shard", 0, "host", "127.0.0.1:3306")
+ */
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+type ILogger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+	/** 返回附带固定字段的logger **/
+	With(keyvals ...interface{}) ILogger
+}
+
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+func (this LogLevel) String() string {
+	switch this {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(this))
+}
+
+var defaultLogger ILogger = NewStdLogger(log.New(os.Stderr, "", log.LstdFlags), LogLevelInfo)
+
+/**
+ * 未注入时的默认logger
+ */
+func loggerOrDefault(logger ILogger) ILogger {
+	if logger == nil {
+		return defaultLogger
+	}
+	return logger
+}
+
+/**
+ * 标准库 *log.Logger 适配，输出 logfmt 格式：level=info msg="..." key=value
+ * 低于 level 的日志被丢弃
+ */
+func NewStdLogger(logger *log.Logger, level LogLevel) ILogger {
+	return &stdLogger{logger: logger, level: level}
+}
+
+type stdLogger struct {
+	logger *log.Logger
+	level  LogLevel
+	fields []interface{}
+}
+
+func (this *stdLogger) Debug(msg string, keyvals ...interface{}) {
+	this.output(LogLevelDebug, msg, keyvals)
+}
+
+func (this *stdLogger) Info(msg string, keyvals ...interface{}) {
+	this.output(LogLevelInfo, msg, keyvals)
+}
+
+func (this *stdLogger) Warn(msg string, keyvals ...interface{}) {
+	this.output(LogLevelWarn, msg, keyvals)
+}
+
+func (this *stdLogger) Error(msg string, keyvals ...interface{}) {
+	this.output(LogLevelError, msg, keyvals)
+}
+
+func (this *stdLogger) With(keyvals ...interface{}) ILogger {
+	fields := make([]interface{}, 0, len(this.fields)+len(keyvals))
+	fields = append(fields, this.fields...)
+	fields = append(fields, keyvals...)
+	return &stdLogger{logger: this.logger, level: this.level, fields: fields}
+}
+
+func (this *stdLogger) output(level LogLevel, msg string, keyvals []interface{}) {
+	if level < this.level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("level=")
+	b.WriteString(level.String())
+	b.WriteString(" msg=")
+	b.WriteString(logfmtValue(msg))
+	writeKeyvals(&b, this.fields)
+	writeKeyvals(&b, keyvals)
+	_ = this.logger.Output(3, b.String())
+}
+
+func writeKeyvals(b *strings.Builder, keyvals []interface{}) {
+	for i := 0; i < len(keyvals); i += 2 {
+		b.WriteByte(' ')
+		b.WriteString(fmt.Sprint(keyvals[i]))
+		b.WriteByte('=')
+		if i+1 < len(keyvals) {
+			b.WriteString(logfmtValue(keyvals[i+1]))
+		} else {
+			b.WriteString(`"(MISSING)"`)
+		}
+	}
+}
+
+func logfmtValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case error:
+		s = v.Error()
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+/**
+ * 丢弃全部日志
+ */
+func NopLogger() ILogger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (nopLogger) Info(msg string, keyvals ...interface{})  {}
+func (nopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (nopLogger) Error(msg string, keyvals ...interface{}) {}
+func (this nopLogger) With(keyvals ...interface{}) ILogger { return this }
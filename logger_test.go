@@ -0,0 +1,32 @@
+package dam
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), LogLevelInfo).With("component", "mysql")
+
+	logger.Debug("dropped", "shard", 0)
+	logger.Info("mysql shard opened", "shard", 0, "host", "127.0.0.1:3306")
+	logger.With("shard", 1).Error("open failed", "error", errors.New("dial tcp: refused"), "odd")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{
+		`level=info msg="mysql shard opened" component=mysql shard=0 host=127.0.0.1:3306`,
+		`level=error msg="open failed" component=mysql shard=1 error="dial tcp: refused" odd="(MISSING)"`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("aspect %d lines, but get:\n%s", len(want), buf.String())
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("aspect %s, but get %s", want[i], lines[i])
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package dam
+
+/**
+ * 指标采集
+ * 以 Prometheus 文本格式(0.0.4)输出连接池、查询耗时、redis命令耗时及锁计数
+ * 不依赖 prometheus client，Metrics 本身即为 http.Handler
+ */
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	metricTypeCounter   = "counter"
+	metricTypeGauge     = "gauge"
+	metricTypeHistogram = "histogram"
+)
+
+/** 默认耗时分桶(秒)，与 prometheus DefBuckets 一致 **/
+var defaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Metrics struct {
+	mu         sync.Mutex
+	families   []*metricFamily
+	collectors []*metricCollector
+
+	queryDuration *metricFamily
+	queryErrors   *metricFamily
+	queryRetries  *metricFamily
+	redisDuration *metricFamily
+	redisErrors   *metricFamily
+	redisLocks    *metricFamily
+}
+
+/**
+ * 创建指标集合
+ * 通过 MysqlConfig.Metrics / RedisConfig.Metrics 注入到管理器，再以 http.Handle("/metrics", metrics) 暴露
+ */
+func NewMetrics() *Metrics {
+	m := &Metrics{}
+	m.queryDuration = m.newFamily("godam_mysql_query_duration_seconds", "MySQL query latency by shard and operation.",
+		metricTypeHistogram, "shard", "operation")
+	m.queryErrors = m.newFamily("godam_mysql_query_errors_total", "MySQL queries that returned an error.",
+		metricTypeCounter, "shard", "operation")
+	m.queryRetries = m.newFamily("godam_mysql_retries_total", "MySQL operations retried by shard, operation and reason.",
+		metricTypeCounter, "shard", "operation", "reason")
+	m.redisDuration = m.newFamily("godam_redis_command_duration_seconds", "Redis command latency by command.",
+		metricTypeHistogram, "command")
+	m.redisErrors = m.newFamily("godam_redis_command_errors_total", "Redis commands that returned an error other than redis.Nil.",
+		metricTypeCounter, "command")
+	m.redisLocks = m.newFamily("godam_redis_lock_total", "TryLock attempts by result.",
+		metricTypeCounter, "result")
+	return m
+}
+
+func (this *Metrics) newFamily(name, help, typ string, labels ...string) *metricFamily {
+	f := &metricFamily{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: defaultDurationBuckets,
+		series:  make(map[string]*metricSeries),
+	}
+	this.families = append(this.families, f)
+	return f
+}
+
+/**
+ * 记录一次sql执行
+ */
+func (this *Metrics) ObserveQuery(shardId int, operation string, duration time.Duration, err error) {
+	if this == nil {
+		return
+	}
+	shard := strconv.Itoa(shardId)
+	this.queryDuration.observe(duration.Seconds(), shard, operation)
+	if err != nil {
+		this.queryErrors.add(1, shard, operation)
+	}
+}
+
+/**
+ * 记录一次重试
+ */
+func (this *Metrics) ObserveRetry(shardId int, operation, reason string) {
+	if this == nil {
+		return
+	}
+	this.queryRetries.add(1, strconv.Itoa(shardId), operation, reason)
+}
+
+/**
+ * 记录一次redis命令
+ */
+func (this *Metrics) ObserveRedisCommand(command string, duration time.Duration, err error) {
+	if this == nil {
+		return
+	}
+	this.redisDuration.observe(duration.Seconds(), command)
+	if err != nil {
+		this.redisErrors.add(1, command)
+	}
+}
+
+/**
+ * 记录一次加锁结果
+ */
+func (this *Metrics) ObserveLock(acquired bool) {
+	if this == nil {
+		return
+	}
+	if acquired {
+		this.redisLocks.add(1, "acquired")
+	} else {
+		this.redisLocks.add(1, "failed")
+	}
+}
+
+type metricCollector struct {
+	collect func() []metricSample
+}
+
+/**
+ * 注册采集时回调，用于连接池这类需要在抓取时读取的状态，返回注销函数
+ */
+func (this *Metrics) addCollector(collect func() []metricSample) (remove func()) {
+	if this == nil {
+		return func() {}
+	}
+	collector := &metricCollector{collect: collect}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.collectors = append(this.collectors, collector)
+	return func() {
+		this.mu.Lock()
+		defer this.mu.Unlock()
+		for i, c := range this.collectors {
+			if c == collector {
+				this.collectors = append(this.collectors[:i], this.collectors[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+/**
+ * 以 Prometheus 文本格式写出全部指标
+ */
+func (this *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for _, f := range this.families {
+		f.write(&buf)
+	}
+
+	this.mu.Lock()
+	collectors := append([]*metricCollector(nil), this.collectors...)
+	this.mu.Unlock()
+	var samples []metricSample
+	for _, collector := range collectors {
+		samples = append(samples, collector.collect()...)
+	}
+	writeSamples(&buf, samples)
+	return buf.WriteTo(w)
+}
+
+func (this *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := this.WriteTo(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/**
+ * 抓取时生成的单个样本
+ */
+type metricSample struct {
+	name   string
+	help   string
+	typ    string
+	labels []string // name, value 交替
+	value  float64
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+type metricFamily struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+func (this *metricFamily) get(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := this.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: labelValues}
+		if this.typ == metricTypeHistogram {
+			s.counts = make([]uint64, len(this.buckets))
+		}
+		this.series[key] = s
+	}
+	return s
+}
+
+func (this *metricFamily) add(delta float64, labelValues ...string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.get(labelValues).value += delta
+}
+
+func (this *metricFamily) observe(value float64, labelValues ...string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	s := this.get(labelValues)
+	for i, upper := range this.buckets {
+		if value <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (this *metricFamily) write(buf *bytes.Buffer) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if len(this.series) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(this.series))
+	for key := range this.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", this.name, this.help, this.name, this.typ)
+	for _, key := range keys {
+		s := this.series[key]
+		pairs := make([]string, 0, len(this.labels)*2+2)
+		for i, label := range this.labels {
+			pairs = append(pairs, label, s.labelValues[i])
+		}
+		if this.typ != metricTypeHistogram {
+			writeSeries(buf, this.name, pairs, s.value)
+			continue
+		}
+		for i, upper := range this.buckets {
+			writeSeries(buf, this.name+"_bucket", append(pairs, "le", formatFloat(upper)), float64(s.counts[i]))
+		}
+		writeSeries(buf, this.name+"_bucket", append(pairs, "le", "+Inf"), float64(s.count))
+		writeSeries(buf, this.name+"_sum", pairs, s.sum)
+		writeSeries(buf, this.name+"_count", pairs, float64(s.count))
+	}
+}
+
+func writeSamples(buf *bytes.Buffer, samples []metricSample) {
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].name < samples[j].name
+	})
+	for i, sample := range samples {
+		if i == 0 || samples[i-1].name != sample.name {
+			fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", sample.name, sample.help, sample.name, sample.typ)
+		}
+		writeSeries(buf, sample.name, sample.labels, sample.value)
+	}
+}
+
+func writeSeries(buf *bytes.Buffer, name string, pairs []string, value float64) {
+	buf.WriteString(name)
+	if len(pairs) > 0 {
+		buf.WriteByte('{')
+		for i := 0; i < len(pairs); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, "%s=\"%s\"", pairs[i], escapeLabelValue(pairs[i+1]))
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatFloat(value))
+	buf.WriteByte('\n')
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+type redisMetricsStartKey struct{}
+
+/**
+ * redis 命令耗时采集 hook
+ */
+type redisMetricsHook struct {
+	metrics *Metrics
+}
+
+func (this *redisMetricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisMetricsStartKey{}, time.Now()), nil
+}
+
+func (this *redisMetricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(redisMetricsStartKey{}).(time.Time); ok {
+		this.metrics.ObserveRedisCommand(cmd.Name(), time.Since(start), redisCommandError(cmd))
+	}
+	return nil
+}
+
+func (this *redisMetricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisMetricsStartKey{}, time.Now()), nil
+}
+
+func (this *redisMetricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	start, ok := ctx.Value(redisMetricsStartKey{}).(time.Time)
+	if !ok {
+		return nil
+	}
+	duration := time.Since(start)
+	for _, cmd := range cmds {
+		this.metrics.ObserveRedisCommand(cmd.Name(), duration, redisCommandError(cmd))
+	}
+	return nil
+}
+
+/**
+ * redis.Nil 表示key不存在，不计为错误
+ */
+func redisCommandError(cmd redis.Cmder) error {
+	if err := cmd.Err(); err != nil && err != redis.Nil {
+		return err
+	}
+	return nil
+}
+
+/**
+ * endpoint 区分共用同一 Metrics 的多个redis管理器
+ */
+func redisPoolSamples(endpoint string, stats *redis.PoolStats) []metricSample {
+	labels := []string{"endpoint", endpoint}
+	gauge := func(name, help string, value uint32) metricSample {
+		return metricSample{name: name, help: help, typ: metricTypeGauge, labels: labels, value: float64(value)}
+	}
+	counter := func(name, help string, value uint32) metricSample {
+		return metricSample{name: name, help: help, typ: metricTypeCounter, labels: labels, value: float64(value)}
+	}
+	return []metricSample{
+		counter("godam_redis_pool_hits_total", "Times a free connection was found in the pool.", stats.Hits),
+		counter("godam_redis_pool_misses_total", "Times a free connection was not found in the pool.", stats.Misses),
+		counter("godam_redis_pool_timeouts_total", "Times a wait for a connection timed out.", stats.Timeouts),
+		gauge("godam_redis_pool_total_connections", "Connections in the pool.", stats.TotalConns),
+		gauge("godam_redis_pool_idle_connections", "Idle connections in the pool.", stats.IdleConns),
+		counter("godam_redis_pool_stale_connections_total", "Stale connections removed from the pool.", stats.StaleConns),
+	}
+}
@@ -0,0 +1,87 @@
+package dam
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsWriteTo(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.ObserveQuery(0, operationSelect, 3*time.Millisecond, nil)
+	metrics.ObserveQuery(0, operationSelect, 2*time.Second, errors.New("bad connection"))
+	metrics.ObserveLock(true)
+	metrics.ObserveLock(false)
+	metrics.ObserveLock(false)
+	metrics.addCollector(func() []metricSample {
+		return []metricSample{{name: "godam_test_gauge", help: "test.", typ: metricTypeGauge, labels: []string{"host", `a"b`}, value: 1}}
+	})
+
+	var buf bytes.Buffer
+	if _, err := metrics.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE godam_mysql_query_duration_seconds histogram\n",
+		`godam_mysql_query_duration_seconds_bucket{shard="0",operation="select",le="0.005"} 1`,
+		`godam_mysql_query_duration_seconds_bucket{shard="0",operation="select",le="+Inf"} 2`,
+		`godam_mysql_query_duration_seconds_count{shard="0",operation="select"} 2`,
+		`godam_mysql_query_errors_total{shard="0",operation="select"} 1`,
+		`godam_redis_lock_total{result="acquired"} 1`,
+		`godam_redis_lock_total{result="failed"} 2`,
+		`godam_test_gauge{host="a\"b"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics output missing %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "godam_redis_command_duration_seconds") {
+		t.Errorf("families without series should be omitted, got:\n%s", out)
+	}
+}
+
+func TestMetricsNil(t *testing.T) {
+	var metrics *Metrics
+	metrics.ObserveQuery(0, operationExec, time.Millisecond, nil)
+	metrics.ObserveRedisCommand("get", time.Millisecond, nil)
+	metrics.ObserveLock(true)
+	metrics.addCollector(func() []metricSample { return nil })
+}
+
+func TestMetricsCollectorRemovedOnClose(t *testing.T) {
+	metrics := NewMetrics()
+	for i := 0; i < 2; i++ {
+		manager := NewMysqlManagerWithDbs(MysqlConfig{Metrics: metrics, Logger: NopLogger()}, nil)
+		if len(metrics.collectors) != 1 {
+			t.Errorf("aspect 1 collector while open, but get %d", len(metrics.collectors))
+		}
+		manager.Close()
+		manager.Close()
+		if len(metrics.collectors) != 0 {
+			t.Errorf("aspect collector removed on Close, but get %d", len(metrics.collectors))
+		}
+	}
+}
+
+func TestRedisPoolMetricsEndpointLabel(t *testing.T) {
+	metrics := NewMetrics()
+	for _, host := range []string{"127.0.0.1:1", "127.0.0.1:2"} {
+		manager := NewRedisManager(RedisConfig{Host: host, IdleTimeout: time.Second, Metrics: metrics, Logger: NopLogger()})
+		defer manager.UniversalClient().Close()
+	}
+	var buf bytes.Buffer
+	if _, err := metrics.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`godam_redis_pool_total_connections{endpoint="127.0.0.1:1"} 0`,
+		`godam_redis_pool_total_connections{endpoint="127.0.0.1:2"} 0`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics output missing %q, got:\n%s", want, buf.String())
+		}
+	}
+}
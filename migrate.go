@@ -0,0 +1,387 @@
+package dam
+
+/**
+ * 版本化数据库迁移
+ * 迁移文件命名为 <版本号>_<名称>.up.sql / <版本号>_<名称>.down.sql，版本号为正整数并按数值排序
+ * 每个分库维护 schema_migrations 表记录已执行的版本，执行期间以 GET_LOCK 持有分库级锁，
+ * 保证多个进程同时启动时同一分库只会被一个进程迁移；GET_LOCK 的锁名在整个mysql实例内共享，
+ * 因此锁名拼接 database()，同一实例上的其他库互不阻塞
+ *
+ *   migrations, err := dam.LoadMigrations("./migrations")
+ *   report, err := dam.NewMigrator(dam.Mysql(), migrations, logger).Up(ctx)
+ */
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	migrationsTable           = "schema_migrations"
+	migrationLockPrefix       = "godam_migrate:"
+	defaultMigrationLockWait  = 60 * time.Second
+	migrationsTableDefinition = "create table if not exists " + migrationsTable + " (" +
+		"version BIGINT NOT NULL, " +
+		"name VARCHAR(255) NOT NULL, " +
+		"dirty TINYINT NOT NULL DEFAULT 0, " +
+		"applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, " +
+		"PRIMARY KEY (version)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+)
+
+var (
+	ErrMigrationLocked = errors.New("migration lock is held by another process")
+	ErrMigrationDirty  = errors.New("shard has a dirty migration, fix it manually and clear the dirty flag")
+
+	migrationFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+/**
+ * 读取目录下的迁移文件，按版本号排序
+ */
+func LoadMigrations(dir string) ([]Migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, file := range files {
+		match := migrationFilePattern.FindStringSubmatch(file.Name())
+		if file.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version", file.Name())
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d has different names: %s, %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if strings.TrimSpace(migration.Up) == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing up sql", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+type MigrationResult struct {
+	ShardId int
+	/** 本次执行(up)或回滚(down)的版本 **/
+	Versions []int64
+	Err      error
+}
+
+type MigrationStatus struct {
+	ShardId int
+	/** 当前版本，0 表示未执行过迁移 **/
+	Version int64
+	Dirty   bool
+	Pending []int64
+	Err     error
+}
+
+type Migrator struct {
+	manager    IMysqlManager
+	migrations []Migration
+	logger     ILogger
+	/** 等待迁移锁的时长 **/
+	LockWait time.Duration
+}
+
+func NewMigrator(manager IMysqlManager, migrations []Migration, logger ILogger) *Migrator {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return &Migrator{
+		manager:    manager,
+		migrations: sorted,
+		logger:     loggerOrDefault(logger).With("component", "migrate"),
+		LockWait:   defaultMigrationLockWait,
+	}
+}
+
+/**
+ * 在所有分库上执行未执行的迁移，单个分库失败不影响其余分库，返回各分库结果与第一个错误
+ */
+func (this *Migrator) Up(ctx context.Context) ([]MigrationResult, error) {
+	return this.each(ctx, func(conn *sql.Conn, shardId int, applied []appliedMigration) ([]int64, error) {
+		done := make(map[int64]bool, len(applied))
+		for _, migration := range applied {
+			done[migration.Version] = true
+		}
+		var versions []int64
+		for _, migration := range this.migrations {
+			if done[migration.Version] {
+				continue
+			}
+			if err := this.apply(ctx, conn, migration, migration.Up, true); err != nil {
+				return versions, fmt.Errorf("shard %d migration %d_%s up: %v", shardId, migration.Version, migration.Name, err)
+			}
+			this.logger.Info("migration applied", "shard", shardId, "version", migration.Version, "name", migration.Name)
+			versions = append(versions, migration.Version)
+		}
+		return versions, nil
+	})
+}
+
+/**
+ * 在所有分库上回滚最近的 steps 个迁移
+ */
+func (this *Migrator) Down(ctx context.Context, steps int) ([]MigrationResult, error) {
+	byVersion := make(map[int64]Migration, len(this.migrations))
+	for _, migration := range this.migrations {
+		byVersion[migration.Version] = migration
+	}
+	return this.each(ctx, func(conn *sql.Conn, shardId int, applied []appliedMigration) ([]int64, error) {
+		var versions []int64
+		for i := len(applied) - 1; i >= 0 && len(versions) < steps; i-- {
+			migration, ok := byVersion[applied[i].Version]
+			if !ok || strings.TrimSpace(migration.Down) == "" {
+				return versions, fmt.Errorf("shard %d migration %d: no down sql", shardId, applied[i].Version)
+			}
+			if err := this.apply(ctx, conn, migration, migration.Down, false); err != nil {
+				return versions, fmt.Errorf("shard %d migration %d_%s down: %v", shardId, migration.Version, migration.Name, err)
+			}
+			this.logger.Info("migration rolled back", "shard", shardId, "version", migration.Version, "name", migration.Name)
+			versions = append(versions, migration.Version)
+		}
+		return versions, nil
+	})
+}
+
+/**
+ * 各分库迁移状态
+ */
+func (this *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	var firstErr error
+	for _, db := range sortShards(this.manager.GetAllDbs()) {
+		status := MigrationStatus{ShardId: db.ShardId()}
+		applied, err := this.applied(ctx, db)
+		if err != nil {
+			status.Err = err
+			if firstErr == nil {
+				firstErr = err
+			}
+			statuses = append(statuses, status)
+			continue
+		}
+		done := make(map[int64]bool, len(applied))
+		for _, migration := range applied {
+			done[migration.Version] = true
+			status.Version = migration.Version
+			status.Dirty = status.Dirty || migration.Dirty
+		}
+		for _, migration := range this.migrations {
+			if !done[migration.Version] {
+				status.Pending = append(status.Pending, migration.Version)
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, firstErr
+}
+
+type appliedMigration struct {
+	Version int64  `db:"version"`
+	Name    string `db:"name"`
+	Dirty   bool   `db:"dirty"`
+}
+
+func (this *Migrator) applied(ctx context.Context, db *ShardDB) ([]appliedMigration, error) {
+	var exists int
+	err := db.GetContext(ctx, &exists, "select count(*) from information_schema.tables where table_schema=database() and table_name=?", migrationsTable)
+	if err != nil || exists == 0 {
+		return nil, err
+	}
+	var applied []appliedMigration
+	err = db.SelectContext(ctx, &applied, "select version, name, dirty from "+migrationsTable+" order by version")
+	return applied, err
+}
+
+/**
+ * 在每个分库上加锁后执行 fn
+ */
+func (this *Migrator) each(ctx context.Context, fn func(conn *sql.Conn, shardId int, applied []appliedMigration) ([]int64, error)) ([]MigrationResult, error) {
+	var results []MigrationResult
+	var firstErr error
+	for _, db := range sortShards(this.manager.GetAllDbs()) {
+		result := MigrationResult{ShardId: db.ShardId()}
+		result.Versions, result.Err = this.locked(ctx, db, fn)
+		if result.Err != nil {
+			this.logger.Error("migration failed", "shard", db.ShardId(), "error", result.Err)
+			if firstErr == nil {
+				firstErr = result.Err
+			}
+		}
+		results = append(results, result)
+	}
+	return results, firstErr
+}
+
+func (this *Migrator) locked(ctx context.Context, db *ShardDB, fn func(conn *sql.Conn, shardId int, applied []appliedMigration) ([]int64, error)) ([]int64, error) {
+	// GET_LOCK 与会话绑定，加锁、迁移与释放必须在同一连接上
+	conn, err := db.DB.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "select get_lock(concat(?, database()), ?)", migrationLockPrefix, int(this.LockWait/time.Second)).Scan(&locked); err != nil {
+		return nil, err
+	}
+	if !locked.Valid || locked.Int64 != 1 {
+		return nil, ErrMigrationLocked
+	}
+	defer conn.ExecContext(context.Background(), "select release_lock(concat(?, database()))", migrationLockPrefix)
+
+	if _, err := conn.ExecContext(ctx, migrationsTableDefinition); err != nil {
+		return nil, err
+	}
+	rows, err := conn.QueryContext(ctx, "select version, name, dirty from "+migrationsTable+" order by version")
+	if err != nil {
+		return nil, err
+	}
+	var applied []appliedMigration
+	for rows.Next() {
+		var migration appliedMigration
+		if err := rows.Scan(&migration.Version, &migration.Name, &migration.Dirty); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if migration.Dirty {
+			rows.Close()
+			return nil, fmt.Errorf("shard %d version %d: %w", db.ShardId(), migration.Version, ErrMigrationDirty)
+		}
+		applied = append(applied, migration)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return fn(conn, db.ShardId(), applied)
+}
+
+/**
+ * 执行单个迁移，执行前标记 dirty，成功后清除(up)或删除记录(down)
+ * DDL 无法回滚，执行中途失败时记录保持 dirty，需人工处理
+ */
+func (this *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, script string, up bool) error {
+	var err error
+	if up {
+		_, err = conn.ExecContext(ctx, "insert into "+migrationsTable+"(version, name, dirty)values(?, ?, 1)", migration.Version, migration.Name)
+	} else {
+		_, err = conn.ExecContext(ctx, "update "+migrationsTable+" set dirty=1 where version=?", migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	for _, statement := range SplitStatements(script) {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	if up {
+		_, err = conn.ExecContext(ctx, "update "+migrationsTable+" set dirty=0, applied_at=? where version=?", timeNow(), migration.Version)
+	} else {
+		_, err = conn.ExecContext(ctx, "delete from "+migrationsTable+" where version=?", migration.Version)
+	}
+	return err
+}
+
+/**
+ * 按分号拆分sql脚本，忽略引号与注释中的分号，丢弃空语句
+ */
+func SplitStatements(script string) []string {
+	var statements []string
+	start := 0
+	flush := func(end int) {
+		if statement := strings.TrimSpace(script[start:end]); statement != "" && !isCommentOnly(statement) {
+			statements = append(statements, statement)
+		}
+	}
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(script, i)
+		case c == '-' && i+1 < len(script) && script[i+1] == '-', c == '#':
+			for i < len(script) && script[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(script) && script[i+1] == '*':
+			if end := strings.Index(script[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(script)
+			}
+		case c == ';':
+			flush(i)
+			start = i + 1
+		}
+	}
+	if start < len(script) {
+		flush(len(script))
+	}
+	return statements
+}
+
+func isCommentOnly(statement string) bool {
+	return strings.TrimSpace(Fingerprint(statement)) == ""
+}
+
+func WriteMigrationStatus(w io.Writer, statuses []MigrationStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SHARD\tVERSION\tDIRTY\tPENDING\tERROR")
+	for _, status := range statuses {
+		pending := make([]string, 0, len(status.Pending))
+		for _, version := range status.Pending {
+			pending = append(pending, strconv.FormatInt(version, 10))
+		}
+		errText := ""
+		if status.Err != nil {
+			errText = status.Err.Error()
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%t\t%s\t%s\n", status.ShardId, status.Version, status.Dirty, strings.Join(pending, ","), errText)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,132 @@
+package dam_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	dam "github.com/seanbit/godam"
+	"github.com/seanbit/godam/damtest"
+)
+
+var testMigrations = []dam.Migration{
+	{Version: 1, Name: "create_user", Up: "create table user (user_id bigint not null, primary key (user_id))", Down: "drop table user"},
+	{Version: 2, Name: "create_order", Up: "create table orders (order_id bigint not null, primary key (order_id)); insert into orders(order_id)values(1)", Down: "drop table orders"},
+}
+
+/**
+ * 引擎不支持 GET_LOCK 与 information_schema，预设为加锁成功、迁移表存在
+ */
+func expectMigrationLock(h *damtest.Harness) {
+	h.Expect(damtest.AnyShard, `get_lock\(concat\(\?, database\(\)\)`, damtest.Expectation{Times: -1, Columns: []string{"locked"}, Rows: [][]interface{}{{1}}})
+	h.Expect(damtest.AnyShard, `release_lock\(concat\(\?, database\(\)\)\)`, damtest.Expectation{Times: -1, Columns: []string{"released"}, Rows: [][]interface{}{{1}}})
+	h.Expect(damtest.AnyShard, `information_schema\.tables`, damtest.Expectation{Times: -1, Columns: []string{"count(*)"}, Rows: [][]interface{}{{1}}})
+}
+
+func shardDb(t *testing.T, h *damtest.Harness, shardId int) *dam.ShardDB {
+	for _, db := range h.Manager.GetAllDbs() {
+		if db.ShardId() == shardId {
+			return db
+		}
+	}
+	t.Fatalf("shard %d not found", shardId)
+	return nil
+}
+
+func TestMigratorUpAndDown(t *testing.T) {
+	h := damtest.New(2)
+	defer h.Close()
+	expectMigrationLock(h)
+	ctx := context.Background()
+	migrator := dam.NewMigrator(h.Manager, testMigrations[:1], dam.NopLogger())
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	migrator = dam.NewMigrator(h.Manager, testMigrations, dam.NopLogger())
+	results, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if !reflect.DeepEqual(result.Versions, []int64{2}) {
+			t.Errorf("shard %d: aspect version 2 applied, but get %v", result.ShardId, result.Versions)
+		}
+		var orders int
+		if err := shardDb(t, h, result.ShardId).GetContext(ctx, &orders, "select count(*) from orders"); err != nil || orders != 1 {
+			t.Errorf("shard %d: aspect orders created, but get %d, %v", result.ShardId, orders, err)
+		}
+	}
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.Version != 2 || status.Dirty || len(status.Pending) != 0 {
+			t.Errorf("aspect shard %d at version 2, but get %+v", status.ShardId, status)
+		}
+	}
+
+	results, err = migrator.Down(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if !reflect.DeepEqual(result.Versions, []int64{2}) {
+			t.Errorf("shard %d: aspect version 2 rolled back, but get %v", result.ShardId, result.Versions)
+		}
+	}
+	statuses, _ = migrator.Status(ctx)
+	for _, status := range statuses {
+		if status.Version != 1 || !reflect.DeepEqual(status.Pending, []int64{2}) {
+			t.Errorf("aspect shard %d at version 1 with 2 pending, but get %+v", status.ShardId, status)
+		}
+	}
+}
+
+func TestMigratorDirty(t *testing.T) {
+	h := damtest.New(2)
+	defer h.Close()
+	h.Expect(0, `^insert into orders`, damtest.Expectation{Err: &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}})
+	expectMigrationLock(h)
+	ctx := context.Background()
+	migrator := dam.NewMigrator(h.Manager, testMigrations, dam.NopLogger())
+	results, err := migrator.Up(ctx)
+	if err == nil || results[0].Err == nil || results[1].Err != nil {
+		t.Fatalf("aspect shard 0 to fail alone, but get %+v", results)
+	}
+	if !reflect.DeepEqual(results[0].Versions, []int64{1}) || !reflect.DeepEqual(results[1].Versions, []int64{1, 2}) {
+		t.Errorf("aspect versions [1] and [1 2], but get %v and %v", results[0].Versions, results[1].Versions)
+	}
+	var dirty int
+	if err := shardDb(t, h, 0).GetContext(ctx, &dirty, "select dirty from schema_migrations where version=2"); err != nil || dirty != 1 {
+		t.Errorf("aspect version 2 dirty on shard 0, but get %d, %v", dirty, err)
+	}
+
+	/** dirty 的分库拒绝继续迁移，需人工处理 **/
+	results, err = migrator.Up(ctx)
+	if !errors.Is(err, dam.ErrMigrationDirty) || !errors.Is(results[0].Err, dam.ErrMigrationDirty) || results[1].Err != nil {
+		t.Errorf("aspect ErrMigrationDirty on shard 0, but get %v", err)
+	}
+	statuses, _ := migrator.Status(ctx)
+	if !statuses[0].Dirty || statuses[1].Dirty {
+		t.Errorf("aspect only shard 0 dirty, but get %+v", statuses)
+	}
+}
+
+func TestMigratorLocked(t *testing.T) {
+	h := damtest.New(2)
+	defer h.Close()
+	h.Expect(1, `get_lock`, damtest.Expectation{Columns: []string{"locked"}, Rows: [][]interface{}{{0}}})
+	expectMigrationLock(h)
+	results, err := dam.NewMigrator(h.Manager, testMigrations, dam.NopLogger()).Up(context.Background())
+	if !errors.Is(err, dam.ErrMigrationLocked) || results[0].Err != nil || !errors.Is(results[1].Err, dam.ErrMigrationLocked) {
+		t.Fatalf("aspect ErrMigrationLocked on shard 1, but get %+v", results)
+	}
+	if len(results[1].Versions) != 0 {
+		t.Errorf("aspect nothing applied on locked shard, but get %v", results[1].Versions)
+	}
+	h.AssertNotQueried(t, 1, `schema_migrations`)
+	h.AssertQueried(t, 0, `^create table orders`)
+}
@@ -0,0 +1,93 @@
+package dam
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	script := `
+-- create user table
+CREATE TABLE user (
+  user_id BIGINT NOT NULL COMMENT '用户ID; 主键',
+  alias_name char(255) DEFAULT "a;b" COMMENT '用户别名'
+);
+/* comment; */ INSERT INTO user(user_id) VALUES (1);
+# trailing comment;
+update ` + "`user`" + ` set alias_name='x\';' where user_id=1`
+	statements := SplitStatements(script)
+	if len(statements) != 3 {
+		t.Fatalf("aspect 3 statements, but get %d: %q", len(statements), statements)
+	}
+	if !strings.HasSuffix(statements[0], "COMMENT '用户别名'\n)") {
+		t.Errorf("unexpected first statement %q", statements[0])
+	}
+	if statements[1] != "/* comment; */ INSERT INTO user(user_id) VALUES (1)" {
+		t.Errorf("unexpected second statement %q", statements[1])
+	}
+	if !strings.HasSuffix(statements[2], `set alias_name='x\';' where user_id=1`) {
+		t.Errorf("unexpected third statement %q", statements[2])
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godam_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"0002_add_enabled.up.sql":   "alter table user add enabled INT DEFAULT 1;",
+		"0002_add_enabled.down.sql": "alter table user drop enabled;",
+		"0001_create_user.up.sql":   "create table user (user_id BIGINT);",
+		"10_create_order.up.sql":    "create table `order` (order_id BIGINT);",
+		"README.md":                 "ignored",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrations, err := LoadMigrations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int64
+	for _, migration := range migrations {
+		versions = append(versions, migration.Version)
+	}
+	if !reflect.DeepEqual(versions, []int64{1, 2, 10}) {
+		t.Errorf("aspect versions [1 2 10], but get %v", versions)
+	}
+	if migrations[1].Name != "add_enabled" || migrations[1].Down != "alter table user drop enabled;" {
+		t.Errorf("unexpected migration %+v", migrations[1])
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "0003_only_down.down.sql"), []byte("drop table x;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadMigrations(dir); err == nil {
+		t.Error("aspect error for migration without up sql")
+	}
+}
+
+func TestWriteMigrationStatus(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteMigrationStatus(&buf, []MigrationStatus{
+		{ShardId: 0, Version: 2, Pending: []int64{10}},
+		{ShardId: 1, Version: 1, Dirty: true, Pending: []int64{2, 10}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[2], "true") || !strings.Contains(lines[2], "2,10") {
+		t.Errorf("unexpected status:\n%s", buf.String())
+	}
+}
@@ -4,9 +4,8 @@ import (
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
-	"github.com/seanbit/gokit/foundation"
 	"github.com/seanbit/gokit/validate"
-	"log"
+	"os"
 	"sync"
 	"time"
 )
@@ -20,9 +19,13 @@ type Model struct {
 
 type IMysqlManager interface {
 	Open()
-	GetDbByUserName(userName string) (db *sqlx.DB, err error)
-	GetAllDbs() (dbs []*sqlx.DB)
+	GetDbByUserName(userName string) (db *ShardDB, err error)
+	GetAllDbs() (dbs []*ShardDB)
 	GenerateId() int64
+	NextId() (int64, error)
+	GenerateIds(n int) ([]int64, error)
+	IdGenerator() IIdGenerator
+	Close() error
 }
 
 type MysqlConfig struct{
@@ -35,6 +38,19 @@ type MysqlConfig struct{
 	MaxIdle 	int				`json:"max_idle" validate:"required,min=1"`
 	MaxOpen 	int				`json:"max_open" validate:"required,min=1"`
 	MaxLifetime time.Duration	`json:"max_lifetime" validate:"required,gte=1"`
+	/** 每个分库缓存的预处理语句数，0 不缓存 **/
+	StmtCacheSize int			`json:"stmt_cache_size" validate:"gte=0"`
+	/** 瞬时故障重试策略，为空不重试 **/
+	Retry 		*RetryPolicy	`json:"retry" validate:"-"`
+	Metrics 	*Metrics		`json:"-" validate:"-"`
+	Hooks 		[]IQueryHook	`json:"-" validate:"-"`
+	Logger 		ILogger			`json:"-" validate:"-"`
+	/** 设置后 Open 时从redis租用worker id，WorkerId 不再生效 **/
+	WorkerLease *WorkerLeaseConfig	`json:"-" validate:"-"`
+	/** 时钟回拨策略 wait/fail，默认 wait **/
+	ClockBackward ClockBackwardPolicy	`json:"clock_backward" validate:"omitempty,oneof=wait fail"`
+	/** wait 策略下的最大等待时长，超过则返回错误，默认500ms **/
+	MaxClockBackwardWait time.Duration	`json:"max_clock_backward_wait" validate:"gte=0"`
 }
 
 var (
@@ -59,26 +75,49 @@ func Mysql() IMysqlManager {
 }
 
 func NewMysqlManager(mysqlConfig MysqlConfig) IMysqlManager {
-	idWorker, err := foundation.NewWorker(mysqlConfig.WorkerId)
-	if err != nil {
-		panic(err)
+	snowflake := newSnowflakeIdGenerator(mysqlConfig.ClockBackward, mysqlConfig.MaxClockBackwardWait)
+	if mysqlConfig.WorkerLease == nil {
+		if err := snowflake.setWorker(mysqlConfig.WorkerId, nil); err != nil {
+			panic(err)
+		}
 	}
 	return &mysqlManagerImpl{
 		config:          mysqlConfig,
-		dbMap:           make(map[int]*sqlx.DB),
+		dbMap:           make(map[int]*ShardDB),
 		dataCenterCount: 0,
-		idWorker: 		 idWorker,
+		snowflake: 		 snowflake,
+		logger:          loggerOrDefault(mysqlConfig.Logger).With("component", "mysql"),
+	}
+}
+
+/**
+ * 使用已打开的连接构建管理器，key 为数据中心id，无需也不会再调用 Open
+ * 用于测试(见 damtest)或由调用方自行管理连接的场景，config 中的连接参数被忽略
+ */
+func NewMysqlManagerWithDbs(mysqlConfig MysqlConfig, dbs map[int]*sqlx.DB) IMysqlManager {
+	manager := NewMysqlManager(mysqlConfig).(*mysqlManagerImpl)
+	manager.leaseWorker()
+	for id, db := range dbs {
+		host := mysqlConfig.Hosts[id]
+		manager.dbMap[id] = newShardDB(id, host, db, manager.config, manager.logger)
+		manager.dataCenterCount += 1
 	}
+	manager.removeCollector = manager.config.Metrics.addCollector(manager.statsSamples)
+	manager.opened = true
+	return manager
 }
 
 type mysqlManagerImpl struct {
 	opened bool
 	config MysqlConfig
 	/** 数据中心id 关联 db Map **/
-	dbMap map[int]*sqlx.DB
+	dbMap map[int]*ShardDB
 	/** 数据中心数量 **/
 	dataCenterCount int
-	idWorker foundation.SnowId
+	snowflake *snowflakeIdGenerator
+	logger ILogger
+	/** 注销连接池指标采集，Close 时调用，避免重新 Open 后重复注册 **/
+	removeCollector func()
 }
 
 /**
@@ -90,29 +129,50 @@ func (this *mysqlManagerImpl) Open() {
 		return
 	}
 	if err := validate.ValidateParameter(this.config); err != nil {
-		log.Fatalf("mysql config validate failed:%s", err.Error())
-		return
+		this.logger.Error("mysql config validate failed", "error", err)
+		os.Exit(1)
 	}
+	this.leaseWorker()
 	for id, host := range this.config.Hosts {
 		var dbLink = fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&parseTime=True&loc=Local",
 			this.config.User, this.config.Password, host, this.config.Name)
 		db, err := sqlx.Open(this.config.Type, dbLink)
 		if err != nil {
+			this.logger.Error("mysql shard open failed", "shard", id, "host", host, "error", err)
 			panic(err)
 		}
 		db.SetMaxIdleConns(this.config.MaxIdle)
 		db.SetMaxOpenConns(this.config.MaxOpen)
 		db.SetConnMaxLifetime(this.config.MaxLifetime)
-		this.dbMap[id] = db
+		this.dbMap[id] = newShardDB(id, host, db, this.config, this.logger)
 		this.dataCenterCount += 1
+		this.logger.Info("mysql shard opened", "shard", id, "host", host, "database", this.config.Name)
 	}
+	this.removeCollector = this.config.Metrics.addCollector(this.statsSamples)
 	this.opened = true
 }
 
+/**
+ * 配置了 WorkerLease 时租用worker id
+ */
+func (this *mysqlManagerImpl) leaseWorker() {
+	if this.config.WorkerLease == nil {
+		return
+	}
+	lease, err := acquireWorkerLease(*this.config.WorkerLease, this.logger)
+	if err != nil {
+		this.logger.Error("mysql worker id lease failed", "error", err)
+		panic(err)
+	}
+	if err := this.snowflake.setWorker(lease.workerId, lease); err != nil {
+		panic(err)
+	}
+}
+
 /**
  * 根据用户名基因确定数据库对象
  */
-func (this *mysqlManagerImpl) GetDbByUserName(userName string) (db *sqlx.DB, err error) {
+func (this *mysqlManagerImpl) GetDbByUserName(userName string) (db *ShardDB, err error) {
 	dna, err := Dna(userName)
 	if err != nil {
 		return nil, err
@@ -124,7 +184,7 @@ func (this *mysqlManagerImpl) GetDbByUserName(userName string) (db *sqlx.DB, err
 /**
  * 获取所有数据库对象
  */
-func (this *mysqlManagerImpl) GetAllDbs() (dbs []*sqlx.DB) {
+func (this *mysqlManagerImpl) GetAllDbs() (dbs []*ShardDB) {
 	for _, v := range this.dbMap {
 		dbs = append(dbs, v)
 	}
@@ -132,10 +192,65 @@ func (this *mysqlManagerImpl) GetAllDbs() (dbs []*sqlx.DB) {
 }
 
 /**
- * 分布式id生成
+ * 各分库连接池状态指标
+ */
+func (this *mysqlManagerImpl) statsSamples() (samples []metricSample) {
+	for _, db := range this.dbMap {
+		samples = append(samples, db.statsSamples()...)
+	}
+	return samples
+}
+
+/**
+ * 分布式id生成，租约丢失时 panic，需处理错误请使用 NextId
  */
 func (this *mysqlManagerImpl) GenerateId() int64 {
-	return this.idWorker.GetId()
+	id, err := this.NextId()
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func (this *mysqlManagerImpl) NextId() (int64, error) {
+	return this.snowflake.NextId()
+}
+
+/**
+ * 批量生成雪花id
+ */
+func (this *mysqlManagerImpl) GenerateIds(n int) ([]int64, error) {
+	return this.snowflake.NextIds(n)
+}
+
+/**
+ * 默认的雪花id生成器，需要连续id的表可使用 NewSegmentIdGenerator
+ */
+func (this *mysqlManagerImpl) IdGenerator() IIdGenerator {
+	return this.snowflake
+}
+
+/**
+ * 释放worker id租约并关闭所有分库连接
+ */
+func (this *mysqlManagerImpl) Close() error {
+	var firstErr error
+	if lease := this.snowflake.takeLease(); lease != nil {
+		firstErr = lease.release()
+	}
+	if this.removeCollector != nil {
+		this.removeCollector()
+		this.removeCollector = nil
+	}
+	for id, db := range this.dbMap {
+		if err := db.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("close shard %d: %v", id, err)
+		}
+	}
+	this.dbMap = make(map[int]*ShardDB)
+	this.dataCenterCount = 0
+	this.opened = false
+	return firstErr
 }
 
 //var (
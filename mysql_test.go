@@ -1,6 +1,7 @@
 package dam
 
 import (
+	"context"
 	"fmt"
 	"github.com/seanbit/gokit/encrypt"
 	"github.com/seanbit/gokit/foundation"
@@ -50,8 +51,6 @@ const (
 	sql_user_select_all_with_deleted = "select * from user"
 	// update by id
 	sql_user_update_by_id = "update user set alias_name=?, password=? where user_id=? and delete_time=0"
-	// update to delete by id
-	sql_user_delete_by_id = "update user set delete_time=? where user_id=? and delete_time=0"
 	// update to enable by id
 	sql_user_enabled_by_id = "update user set enabled=1 where user_id=? and delete_time=0"
 	// update to disenable by id
@@ -291,11 +290,7 @@ func (this *userDaoImpl) userDelete(userId int, userName string) error {
 		return foundation.NewError(err, error_code_user_not_exist, error_msg_user_not_exist)
 	}
 	// user delete update
-	res, err := db.Exec(sql_user_delete_by_id, idWorker.GetProjTimeStamp(), userId)
-	if err != nil {
-		return err
-	}
-	row, err := res.RowsAffected()
+	row, err := Table("user").Where("user_id=?", userId).SoftDelete(context.Background(), db)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,293 @@
+package dam
+
+/**
+ * 软删除数据清理
+ * 按表配置保留期，在 GetAllDbs() 的每个分库上分批物理删除 delete_time 早于保留期的行，
+ * 批次之间休眠 BatchInterval；配置 ReplicationLag 与 MaxReplicationLag 后，每批之前检查从库延迟，
+ * 超过上限时暂停直至延迟回落，检查失败则停止该分库的清理。DryRun 只统计待删除行数
+ *
+ *   report, err := dam.NewPurger(dam.Mysql(), dam.PurgeConfig{
+ *       Tables: []dam.PurgeTable{{Table: "user", Retention: 90 * 24 * time.Hour}},
+ *       MaxReplicationLag: 5 * time.Second,
+ *       ReplicationLag: func(ctx context.Context, shardId int) (time.Duration, error) {
+ *           return dam.ReplicaLag(ctx, replicas[shardId])
+ *       },
+ *   }).Run(ctx)
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	defaultPurgeBatchSize     = 500
+	defaultPurgeBatchInterval = 100 * time.Millisecond
+)
+
+type PurgeTable struct {
+	Table string
+	/** 删除后保留时长，delete_time 早于 now-Retention 的行会被清理 **/
+	Retention time.Duration
+}
+
+type PurgeConfig struct {
+	Tables []PurgeTable
+	/** 每批删除行数，默认500 **/
+	BatchSize int
+	/** 批次间隔，默认100ms **/
+	BatchInterval time.Duration
+	/** 只统计不删除 **/
+	DryRun bool
+	/** 从库延迟上限，为0或未配置 ReplicationLag 时不检查 **/
+	MaxReplicationLag time.Duration
+	/** 返回分库从库的最大延迟，可使用 ReplicaLag **/
+	ReplicationLag func(ctx context.Context, shardId int) (time.Duration, error)
+	Logger         ILogger
+}
+
+type PurgeResult struct {
+	ShardId int
+	Table   string
+	/** 已删除行数，DryRun 时为待删除行数 **/
+	Rows    int64
+	Batches int
+	/** 因从库延迟暂停的总时长 **/
+	Throttled time.Duration
+	Duration  time.Duration
+	Err       error
+}
+
+type PurgeReport struct {
+	DryRun  bool
+	Results []PurgeResult
+}
+
+type Purger struct {
+	manager IMysqlManager
+	config  PurgeConfig
+	logger  ILogger
+}
+
+func NewPurger(manager IMysqlManager, config PurgeConfig) *Purger {
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultPurgeBatchSize
+	}
+	if config.BatchInterval <= 0 {
+		config.BatchInterval = defaultPurgeBatchInterval
+	}
+	return &Purger{
+		manager: manager,
+		config:  config,
+		logger:  loggerOrDefault(config.Logger).With("component", "purge"),
+	}
+}
+
+/**
+ * 依次清理每张表的每个分库，单个分库失败不影响其余分库，返回完整报告与第一个错误
+ */
+func (this *Purger) Run(ctx context.Context) (*PurgeReport, error) {
+	report := &PurgeReport{DryRun: this.config.DryRun}
+	var firstErr error
+	dbs := sortShards(this.manager.GetAllDbs())
+	for _, table := range this.config.Tables {
+		cutoff := timeNow().Add(-table.Retention).UnixNano()
+		for _, db := range dbs {
+			result := this.purge(ctx, db, table.Table, cutoff)
+			report.Results = append(report.Results, result)
+			if result.Err != nil {
+				this.logger.Error("purge failed", "table", table.Table, "shard", db.ShardId(), "rows", result.Rows, "error", result.Err)
+				if firstErr == nil {
+					firstErr = result.Err
+				}
+				if ctx.Err() != nil {
+					return report, firstErr
+				}
+				continue
+			}
+			this.logger.Info("purge done", "table", table.Table, "shard", db.ShardId(), "rows", result.Rows,
+				"batches", result.Batches, "dry_run", this.config.DryRun)
+		}
+	}
+	return report, firstErr
+}
+
+func (this *Purger) purge(ctx context.Context, db *ShardDB, table string, cutoff int64) (result PurgeResult) {
+	start := time.Now()
+	result = PurgeResult{ShardId: db.ShardId(), Table: table}
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	if this.config.DryRun {
+		result.Err = db.GetContext(ctx, &result.Rows, purgeCountSQL(table), cutoff)
+		return result
+	}
+	query := purgeDeleteSQL(table, this.config.BatchSize)
+	for {
+		throttled, err := this.waitReplication(ctx, db.ShardId())
+		result.Throttled += throttled
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		res, err := db.ExecContext(ctx, query, cutoff)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		result.Rows += rows
+		result.Batches++
+		if rows < int64(this.config.BatchSize) {
+			return result
+		}
+		select {
+		case <-ctx.Done():
+			result.Err = ctx.Err()
+			return result
+		case <-time.After(this.config.BatchInterval):
+		}
+	}
+}
+
+/**
+ * 从库延迟超过 MaxReplicationLag 时每隔 BatchInterval 重新检查，直至回落，返回等待时长
+ */
+func (this *Purger) waitReplication(ctx context.Context, shardId int) (time.Duration, error) {
+	if this.config.ReplicationLag == nil || this.config.MaxReplicationLag <= 0 {
+		return 0, nil
+	}
+	start := time.Now()
+	for {
+		lag, err := this.config.ReplicationLag(ctx, shardId)
+		if err != nil {
+			return time.Since(start), fmt.Errorf("check replication lag: %w", err)
+		}
+		if lag <= this.config.MaxReplicationLag {
+			return time.Since(start), nil
+		}
+		this.logger.Debug("purge throttled", "shard", shardId, "lag", lag, "max_lag", this.config.MaxReplicationLag)
+		select {
+		case <-ctx.Done():
+			return time.Since(start), ctx.Err()
+		case <-time.After(this.config.BatchInterval):
+		}
+	}
+}
+
+/**
+ * 在从库上执行 show slave status，返回 Seconds_Behind_Master；复制未运行(值为 NULL)时返回错误
+ */
+func ReplicaLag(ctx context.Context, replica *sqlx.DB) (time.Duration, error) {
+	rows, err := replica.QueryxContext(ctx, "show slave status")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var lag time.Duration
+	found := false
+	for rows.Next() {
+		status := make(map[string]interface{})
+		if err := rows.MapScan(status); err != nil {
+			return 0, err
+		}
+		found = true
+		seconds, err := replicaSecondsBehind(status["Seconds_Behind_Master"])
+		if err != nil {
+			return 0, err
+		}
+		/** 多源复制取最大延迟 **/
+		if seconds > lag {
+			lag = seconds
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, errors.New("not a replica")
+	}
+	return lag, nil
+}
+
+func replicaSecondsBehind(value interface{}) (time.Duration, error) {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		return 0, errors.New("replication is not running")
+	case []byte:
+		text = string(v)
+	case int64:
+		return time.Duration(v) * time.Second, nil
+	default:
+		text = fmt.Sprint(v)
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Seconds_Behind_Master %q", text)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func purgeCountSQL(table string) string {
+	return fmt.Sprintf("select count(*) from %s where %s!=0 and %s<?", table, columnDeleteTime, columnDeleteTime)
+}
+
+func purgeDeleteSQL(table string, batchSize int) string {
+	return fmt.Sprintf("delete from %s where %s!=0 and %s<? limit %d", table, columnDeleteTime, columnDeleteTime, batchSize)
+}
+
+/**
+ * 各表删除总行数
+ */
+func (this *PurgeReport) Rows() map[string]int64 {
+	rows := make(map[string]int64)
+	for _, result := range this.Results {
+		rows[result.Table] += result.Rows
+	}
+	return rows
+}
+
+func (this *PurgeReport) WriteTo(w io.Writer) (int64, error) {
+	counter := &countWriter{w: w}
+	tw := tabwriter.NewWriter(counter, 0, 4, 2, ' ', 0)
+	rowsTitle := "DELETED"
+	if this.DryRun {
+		rowsTitle = "PENDING"
+	}
+	fmt.Fprintf(tw, "TABLE\tSHARD\t%s\tBATCHES\tTHROTTLED\tDURATION\tERROR\n", rowsTitle)
+	for _, result := range this.Results {
+		errText := ""
+		if result.Err != nil {
+			errText = result.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\t%s\n", result.Table, result.ShardId, result.Rows, result.Batches,
+			result.Throttled.Round(time.Millisecond), result.Duration.Round(time.Millisecond), errText)
+	}
+	err := tw.Flush()
+	return counter.n, err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (this *countWriter) Write(p []byte) (int, error) {
+	n, err := this.w.Write(p)
+	this.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,173 @@
+package dam_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	dam "github.com/seanbit/godam"
+	"github.com/seanbit/godam/damtest"
+)
+
+const purgeSchema = `
+CREATE TABLE user (
+user_id BIGINT NOT NULL,
+user_name char(255) DEFAULT NULL,
+delete_time BIGINT NOT NULL DEFAULT 0,
+PRIMARY KEY (user_id)
+);`
+
+/**
+ * 分库0: 5行超过保留期、1行未超过、1行未删除；分库1: 2行超过保留期
+ */
+func newPurgeHarness(t *testing.T) *damtest.Harness {
+	h := damtest.New(2)
+	t.Cleanup(func() { h.Close() })
+	if err := h.LoadSchema(purgeSchema); err != nil {
+		t.Fatal(err)
+	}
+	expired := time.Now().Add(-48 * time.Hour).UnixNano()
+	recent := time.Now().Add(-time.Hour).UnixNano()
+	rows := map[int][]int64{0: {expired, expired, expired, expired, expired, recent, 0}, 1: {expired, expired}}
+	for shardId, deleteTimes := range rows {
+		for i, deleteTime := range deleteTimes {
+			row := map[string]interface{}{"user_id": shardId*100 + i, "delete_time": deleteTime}
+			if err := h.LoadFixtures(shardId, "user", row); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return h
+}
+
+func purgeTables() []dam.PurgeTable {
+	return []dam.PurgeTable{{Table: "user", Retention: 24 * time.Hour}}
+}
+
+func countRows(t *testing.T, h *damtest.Harness, shardId int) int64 {
+	for _, db := range h.Manager.GetAllDbs() {
+		if db.ShardId() == shardId {
+			count, err := dam.Table("user").WithDeleted().Count(context.Background(), db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return count
+		}
+	}
+	t.Fatalf("shard %d not found", shardId)
+	return 0
+}
+
+func TestPurgerBatches(t *testing.T) {
+	h := newPurgeHarness(t)
+	report, err := dam.NewPurger(h.Manager, dam.PurgeConfig{
+		Tables:        purgeTables(),
+		BatchSize:     2,
+		BatchInterval: time.Millisecond,
+		Logger:        dam.NopLogger(),
+	}).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		rows    int64
+		batches int
+	}{{5, 3}, {2, 2}}
+	if len(report.Results) != len(want) {
+		t.Fatalf("aspect %d results, but get %+v", len(want), report.Results)
+	}
+	for i, result := range report.Results {
+		if result.ShardId != i || result.Rows != want[i].rows || result.Batches != want[i].batches {
+			t.Errorf("aspect shard %d purged %d rows in %d batches, but get %+v", i, want[i].rows, want[i].batches, result)
+		}
+	}
+	if remain := countRows(t, h, 0); remain != 2 {
+		t.Errorf("aspect 2 rows remain on shard 0, but get %d", remain)
+	}
+	if remain := countRows(t, h, 1); remain != 0 {
+		t.Errorf("aspect no rows remain on shard 1, but get %d", remain)
+	}
+	if shards := h.ShardsOf(`^delete from user where delete_time!=0 and delete_time<\? limit 2$`); len(shards) != 2 {
+		t.Errorf("aspect batch deletes on both shards, but get %v", shards)
+	}
+}
+
+func TestPurgerDryRun(t *testing.T) {
+	h := newPurgeHarness(t)
+	report, err := dam.NewPurger(h.Manager, dam.PurgeConfig{
+		Tables: purgeTables(),
+		DryRun: true,
+		Logger: dam.NopLogger(),
+	}).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := report.Rows()["user"]; !report.DryRun || rows != 7 {
+		t.Errorf("aspect 7 pending rows, but get %d", rows)
+	}
+	h.AssertNotQueried(t, damtest.AnyShard, `^delete`)
+	if remain := countRows(t, h, 0); remain != 7 {
+		t.Errorf("aspect dry run keeps 7 rows on shard 0, but get %d", remain)
+	}
+}
+
+func TestPurgerReplicationLag(t *testing.T) {
+	h := newPurgeHarness(t)
+	lags := map[int][]time.Duration{0: {10 * time.Second, 3 * time.Second, 0}}
+	checks := make(map[int]int)
+	lagErr := errors.New("replica unreachable")
+	report, err := dam.NewPurger(h.Manager, dam.PurgeConfig{
+		Tables:            purgeTables(),
+		BatchSize:         10,
+		BatchInterval:     time.Millisecond,
+		MaxReplicationLag: time.Second,
+		ReplicationLag: func(ctx context.Context, shardId int) (time.Duration, error) {
+			checks[shardId]++
+			if shardId == 1 {
+				return 0, lagErr
+			}
+			lag := lags[shardId][0]
+			lags[shardId] = lags[shardId][1:]
+			return lag, nil
+		},
+		Logger: dam.NopLogger(),
+	}).Run(context.Background())
+	if !errors.Is(err, lagErr) {
+		t.Errorf("aspect replication lag error, but get %v", err)
+	}
+	/** 分库0 等待两次后删除5行；分库1 检查失败不删除 **/
+	if result := report.Results[0]; result.Rows != 5 || result.Throttled <= 0 || checks[0] != 3 {
+		t.Errorf("aspect throttled purge on shard 0 after 3 checks, but get %+v, %d checks", result, checks[0])
+	}
+	if result := report.Results[1]; result.Rows != 0 || !errors.Is(result.Err, lagErr) {
+		t.Errorf("aspect no purge on shard 1, but get %+v", result)
+	}
+	h.AssertNotQueried(t, 1, `^delete`)
+}
+
+func TestReplicaLag(t *testing.T) {
+	replica := damtest.New(1)
+	defer replica.Close()
+	db := replica.Manager.GetAllDbs()[0].DB
+	ctx := context.Background()
+
+	replica.Expect(0, `^show slave status`, damtest.Expectation{
+		Columns: []string{"Slave_IO_State", "Seconds_Behind_Master"},
+		Rows:    [][]interface{}{{"Waiting for master to send event", 3}, {"Waiting for master to send event", 7}},
+	})
+	if lag, err := dam.ReplicaLag(ctx, db); err != nil || lag != 7*time.Second {
+		t.Errorf("aspect max lag 7s, but get %s, %v", lag, err)
+	}
+	replica.Expect(0, `^show slave status`, damtest.Expectation{
+		Columns: []string{"Slave_IO_State", "Seconds_Behind_Master"},
+		Rows:    [][]interface{}{{"", nil}},
+	})
+	if _, err := dam.ReplicaLag(ctx, db); err == nil {
+		t.Error("aspect error when replication is not running")
+	}
+	replica.Expect(0, `^show slave status`, damtest.Expectation{Columns: []string{"Seconds_Behind_Master"}})
+	if _, err := dam.ReplicaLag(ctx, db); err == nil {
+		t.Error("aspect error on master")
+	}
+}
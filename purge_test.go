@@ -0,0 +1,49 @@
+package dam
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPurgeSQL(t *testing.T) {
+	if sql := purgeCountSQL("user"); sql != "select count(*) from user where delete_time!=0 and delete_time<?" {
+		t.Errorf("unexpected count sql %q", sql)
+	}
+	if sql := purgeDeleteSQL("user", 500); sql != "delete from user where delete_time!=0 and delete_time<? limit 500" {
+		t.Errorf("unexpected delete sql %q", sql)
+	}
+}
+
+func TestPurgeReport(t *testing.T) {
+	report := &PurgeReport{Results: []PurgeResult{
+		{ShardId: 0, Table: "user", Rows: 1200, Batches: 3},
+		{ShardId: 1, Table: "user", Rows: 10, Batches: 1},
+		{ShardId: 0, Table: "order", Err: errors.New("lock wait timeout")},
+	}}
+	rows := report.Rows()
+	if rows["user"] != 1210 || rows["order"] != 0 {
+		t.Errorf("unexpected rows %v", rows)
+	}
+
+	var buf bytes.Buffer
+	n, err := report.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("aspect written %d, but get %d", buf.Len(), n)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "TABLE  SHARD  DELETED") || !strings.Contains(out, "lock wait timeout") {
+		t.Errorf("unexpected report:\n%s", out)
+	}
+}
+
+func TestPurgerDefaults(t *testing.T) {
+	purger := NewPurger(nil, PurgeConfig{Logger: NopLogger()})
+	if purger.config.BatchSize != defaultPurgeBatchSize || purger.config.BatchInterval != defaultPurgeBatchInterval {
+		t.Errorf("unexpected defaults %+v", purger.config)
+	}
+}
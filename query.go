@@ -0,0 +1,210 @@
+package dam
+
+/**
+ * 软删除感知的查询层
+ * 适用于行结构嵌入 Model 的表：查询与更新默认只作用于 delete_time=0 的行，
+ * WithDeleted() 包含已删除行，OnlyDeleted() 只查已删除行
+ *
+ *   var users []*User
+ *   err := dam.Table("user").Where("user_name=?", userName).Select(ctx, db, &users)
+ *   rows, err := dam.Table("user").Where("user_id=?", userId).SoftDelete(ctx, db)
+ *
+ * 写操作同时以 update_time 与 context 中的操作人(见 WithActor)更新审计列
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	columnCreateTime = "create_time"
+	columnUpdateTime = "update_time"
+	columnUpdateUser = "update_user"
+	columnDeleteTime = "delete_time"
+)
+
+type DeletedScope int
+
+const (
+	/** 默认，只包含未删除行 **/
+	ScopeNotDeleted DeletedScope = iota
+	/** 包含已删除行 **/
+	ScopeWithDeleted
+	/** 只包含已删除行 **/
+	ScopeOnlyDeleted
+)
+
+var timeNow = time.Now
+
+type Query struct {
+	table   string
+	where   []string
+	args    []interface{}
+	scope   DeletedScope
+	orderBy string
+	limit   int
+}
+
+func Table(table string) *Query {
+	return &Query{table: table}
+}
+
+/**
+ * 追加条件，多次调用以 and 连接
+ */
+func (this *Query) Where(condition string, args ...interface{}) *Query {
+	this.where = append(this.where, condition)
+	this.args = append(this.args, args...)
+	return this
+}
+
+func (this *Query) WithDeleted() *Query {
+	this.scope = ScopeWithDeleted
+	return this
+}
+
+func (this *Query) OnlyDeleted() *Query {
+	this.scope = ScopeOnlyDeleted
+	return this
+}
+
+func (this *Query) OrderBy(orderBy string) *Query {
+	this.orderBy = orderBy
+	return this
+}
+
+func (this *Query) Limit(limit int) *Query {
+	this.limit = limit
+	return this
+}
+
+/**
+ * 查询多行到 dest(切片指针)，columns 为空时查询全部列
+ */
+func (this *Query) Select(ctx context.Context, db *ShardDB, dest interface{}, columns ...string) error {
+	query, args := this.selectSQL(columns)
+	return db.SelectContext(ctx, dest, query, args...)
+}
+
+/**
+ * 查询单行到 dest(结构体指针)，无数据时返回 sql.ErrNoRows
+ */
+func (this *Query) Get(ctx context.Context, db *ShardDB, dest interface{}, columns ...string) error {
+	limit := this.limit
+	this.limit = 1
+	query, args := this.selectSQL(columns)
+	this.limit = limit
+	return db.GetContext(ctx, dest, query, args...)
+}
+
+func (this *Query) Count(ctx context.Context, db *ShardDB) (count int64, err error) {
+	query, args := this.selectSQL([]string{"count(*)"})
+	err = db.GetContext(ctx, &count, query, args...)
+	return count, err
+}
+
+/**
+ * 更新列，返回影响行数
+ */
+func (this *Query) Update(ctx context.Context, db *ShardDB, set map[string]interface{}) (int64, error) {
+	if len(set) == 0 {
+		return 0, errors.New("update without columns")
+	}
+	query, args := this.updateSQL(withAuditColumns(ctx, set), this.scope)
+	return this.exec(ctx, db, query, args)
+}
+
+/**
+ * 软删除：delete_time 置为当前纳秒时间戳，只作用于未删除行
+ */
+func (this *Query) SoftDelete(ctx context.Context, db *ShardDB) (int64, error) {
+	set := withAuditColumns(ctx, map[string]interface{}{columnDeleteTime: timeNow().UnixNano()})
+	query, args := this.updateSQL(set, ScopeNotDeleted)
+	return this.exec(ctx, db, query, args)
+}
+
+/**
+ * 恢复软删除的行，只作用于已删除行
+ */
+func (this *Query) Restore(ctx context.Context, db *ShardDB) (int64, error) {
+	query, args := this.updateSQL(withAuditColumns(ctx, map[string]interface{}{columnDeleteTime: 0}), ScopeOnlyDeleted)
+	return this.exec(ctx, db, query, args)
+}
+
+func (this *Query) exec(ctx context.Context, db *ShardDB, query string, args []interface{}) (int64, error) {
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (this *Query) selectSQL(columns []string) (string, []interface{}) {
+	var b strings.Builder
+	b.WriteString("select ")
+	if len(columns) == 0 {
+		b.WriteString("*")
+	} else {
+		b.WriteString(strings.Join(columns, ", "))
+	}
+	b.WriteString(" from ")
+	b.WriteString(this.table)
+	this.writeWhere(&b, this.scope)
+	if this.orderBy != "" {
+		b.WriteString(" order by ")
+		b.WriteString(this.orderBy)
+	}
+	if this.limit > 0 {
+		fmt.Fprintf(&b, " limit %d", this.limit)
+	}
+	return b.String(), this.args
+}
+
+func (this *Query) updateSQL(set map[string]interface{}, scope DeletedScope) (string, []interface{}) {
+	columns := make([]string, 0, len(set))
+	for column := range set {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	var b strings.Builder
+	args := make([]interface{}, 0, len(set)+len(this.args))
+	b.WriteString("update ")
+	b.WriteString(this.table)
+	b.WriteString(" set ")
+	for i, column := range columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(column)
+		b.WriteString("=?")
+		args = append(args, set[column])
+	}
+	this.writeWhere(&b, scope)
+	if this.limit > 0 {
+		fmt.Fprintf(&b, " limit %d", this.limit)
+	}
+	return b.String(), append(args, this.args...)
+}
+
+func (this *Query) writeWhere(b *strings.Builder, scope DeletedScope) {
+	conditions := make([]string, 0, len(this.where)+1)
+	for _, condition := range this.where {
+		conditions = append(conditions, "("+condition+")")
+	}
+	switch scope {
+	case ScopeNotDeleted:
+		conditions = append(conditions, columnDeleteTime+"=0")
+	case ScopeOnlyDeleted:
+		conditions = append(conditions, columnDeleteTime+"!=0")
+	}
+	if len(conditions) > 0 {
+		b.WriteString(" where ")
+		b.WriteString(strings.Join(conditions, " and "))
+	}
+}
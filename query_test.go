@@ -0,0 +1,51 @@
+package dam
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQuerySelectSQL(t *testing.T) {
+	var testData = []struct {
+		query   *Query
+		columns []string
+		sql     string
+		args    []interface{}
+	}{
+		{Table("user"), nil, "select * from user where delete_time=0", nil},
+		{Table("user").Where("user_name=?", "yang").Where("enabled=? or user_id=?", 1, 2), []string{"user_id"},
+			"select user_id from user where (user_name=?) and (enabled=? or user_id=?) and delete_time=0", []interface{}{"yang", 1, 2}},
+		{Table("user").WithDeleted().OrderBy("user_id desc").Limit(10), nil, "select * from user order by user_id desc limit 10", nil},
+		{Table("user").OnlyDeleted().Where("user_id=?", 3), []string{"user_id", "delete_time"},
+			"select user_id, delete_time from user where (user_id=?) and delete_time!=0", []interface{}{3}},
+	}
+	for _, data := range testData {
+		sql, args := data.query.selectSQL(data.columns)
+		if sql != data.sql {
+			t.Errorf("aspect sql is %q, but get %q", data.sql, sql)
+		}
+		if !reflect.DeepEqual(args, data.args) {
+			t.Errorf("aspect args is %v, but get %v", data.args, args)
+		}
+	}
+}
+
+func TestQueryUpdateSQL(t *testing.T) {
+	query := Table("user").Where("user_id=?", 3)
+
+	sql, args := query.updateSQL(map[string]interface{}{"password": "p", "alias_name": "a"}, query.scope)
+	if want := "update user set alias_name=?, password=? where (user_id=?) and delete_time=0"; sql != want {
+		t.Errorf("aspect sql is %q, but get %q", want, sql)
+	}
+	if want := []interface{}{"a", "p", 3}; !reflect.DeepEqual(args, want) {
+		t.Errorf("aspect args is %v, but get %v", want, args)
+	}
+
+	sql, args = query.WithDeleted().updateSQL(map[string]interface{}{columnDeleteTime: 0}, ScopeOnlyDeleted)
+	if want := "update user set delete_time=? where (user_id=?) and delete_time!=0"; sql != want {
+		t.Errorf("aspect sql is %q, but get %q", want, sql)
+	}
+	if want := []interface{}{0, 3}; !reflect.DeepEqual(args, want) {
+		t.Errorf("aspect args is %v, but get %v", want, args)
+	}
+}
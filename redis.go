@@ -1,7 +1,7 @@
 package dam
 
 import (
-	"fmt"
+	"context"
 	"github.com/go-redis/redis/v7"
 	"sync"
 	"time"
@@ -9,7 +9,12 @@ import (
 
 type IRedisManager interface {
 	Open()
+	// standalone 与 sentinel 模式的原生客户端；cluster 模式与内存实现(NewMemoryRedisManager)返回 nil，调用方需判断
 	Client() *redis.Client
+	// 任意部署模式的原生客户端；内存实现返回 nil
+	UniversalClient() redis.UniversalClient
+	// 返回绑定 ctx 的管理器，其命令经 ctx 传给 RedisConfig.Hooks，用于链路追踪与超时控制
+	WithContext(ctx context.Context) IRedisManager
 
 	// base set & get
 	Set(key string, value interface{}, expiration time.Duration) error
@@ -34,11 +39,21 @@ type IRedisManager interface {
 }
 
 type RedisConfig struct{
-	Host        string			`json:"host" validate:"required,tcp_addr"`
+	/** 部署模式 standalone/sentinel/cluster，默认 standalone **/
+	Mode 		RedisMode		`json:"mode" validate:"omitempty,oneof=standalone sentinel cluster"`
+	/** standalone 模式的地址 **/
+	Host        string			`json:"host" validate:"omitempty,tcp_addr"`
+	/** sentinel 模式的主节点名 **/
+	MasterName 	string			`json:"master_name" validate:"gte=0"`
+	/** sentinel 模式为哨兵地址，cluster 模式为种子节点地址 **/
+	Addrs 		[]string		`json:"addrs" validate:"omitempty,dive,tcp_addr"`
 	Password    string			`json:"password" validate:"gte=0"`
 	MaxIdle     int				`json:"max_idle" validate:"required,min=1"`
 	MaxActive   int				`json:"max_active" validate:"required,min=1"`
 	IdleTimeout time.Duration	`json:"idle_timeout" validate:"required,gte=1"`
+	Metrics 	*Metrics		`json:"-" validate:"-"`
+	Hooks 		[]redis.Hook	`json:"-" validate:"-"`
+	Logger 		ILogger			`json:"-" validate:"-"`
 }
 
 var (
@@ -63,20 +78,30 @@ func Redis() IRedisManager {
 }
 
 func NewRedisManager(redisConfig RedisConfig) IRedisManager {
+	client, err := newRedisClient(redisConfig)
+	if err != nil {
+		panic(err)
+	}
+	if redisConfig.Metrics != nil {
+		client.AddHook(&redisMetricsHook{metrics: redisConfig.Metrics})
+		redisConfig.Metrics.addCollector(func() []metricSample {
+			return redisPoolSamples(redisConfig.Endpoint(), redisPoolStats(client))
+		})
+	}
+	for _, hook := range redisConfig.Hooks {
+		client.AddHook(hook)
+	}
 	return &redisManagerImpl{
 		config:redisConfig,
-		client: redis.NewClient(&redis.Options{
-			Addr:     redisConfig.Host,
-			Password: redisConfig.Password,
-			DB:       0,  // use default DB
-			IdleTimeout:redisConfig.IdleTimeout,
-		}),
+		client: client,
+		logger: loggerOrDefault(redisConfig.Logger).With("component", "redis", "host", redisConfig.Endpoint()),
 	}
 }
 
 type redisManagerImpl struct {
 	config RedisConfig
-	client *redis.Client
+	client redis.UniversalClient
+	logger ILogger
 }
 
 /**
@@ -84,22 +109,41 @@ type redisManagerImpl struct {
  */
 func (this *redisManagerImpl) Open() {
 	pong, err := this.client.Ping().Result()
-	fmt.Println(pong, err)
-	// Output: PONG <nil>
 	// 初始化后通讯失败
 	if err != nil {
+		this.logger.Error("redis ping failed", "error", err)
 		panic(err)
 	}
+	this.logger.Info("redis opened", "pong", pong)
 }
 
 /**
- * redis client
+ * redis client，cluster 模式下为 nil
  */
 func (this *redisManagerImpl) Client() *redis.Client {
+	client, _ := this.client.(*redis.Client)
+	return client
+}
+
+/**
+ * 各部署模式通用的 redis client
+ */
+func (this *redisManagerImpl) UniversalClient() redis.UniversalClient {
 	return this.client
 }
 
 
+/**
+ * 绑定 context，与原管理器共享连接池
+ */
+func (this *redisManagerImpl) WithContext(ctx context.Context) IRedisManager {
+	if ctx == nil {
+		panic("nil context")
+	}
+	clone := *this
+	clone.client = redisClientWithContext(this.client, ctx)
+	return &clone
+}
 
 /**
  * 存
@@ -241,8 +285,10 @@ func (this *redisManagerImpl) TryLock(key string, expiration time.Duration) (res
 	resp := this.client.SetNX(key, 1, expiration)
 	lockSuccess, err := resp.Result()
 	if err != nil || !lockSuccess {
+		this.config.Metrics.ObserveLock(false)
 		return false
 	}
+	this.config.Metrics.ObserveLock(true)
 	return true
 }
 
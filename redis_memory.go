@@ -0,0 +1,373 @@
+package dam
+
+/**
+ * 内存实现的 IRedisManager，用于单元测试，无需redis进程
+ * 支持字符串(含过期)、hash 与 SetNX 锁，返回值与错误语义与 redisManagerImpl 一致：
+ * Get 不存在时返回 ""、nil，HashGet 不存在时返回 redis.Nil，HashMGet 不存在的域为 nil
+ * 过期时间以 timeNow 计算；Client/UniversalClient 返回 nil，依赖原生客户端的功能(如 WorkerLease)不可用
+ * NewMemoryRedisManagerWithConfig 使用配置中的 Metrics 记录 TryLock 结果，连接参数被忽略
+ */
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+var errMemoryRedisWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+type memoryRedisEntry struct {
+	value string
+	hash  map[string]string
+	/** 零值表示不过期 **/
+	expireAt time.Time
+}
+
+type memoryRedisManager struct {
+	mutex   sync.Mutex
+	entries map[string]*memoryRedisEntry
+	metrics *Metrics
+}
+
+func NewMemoryRedisManager() IRedisManager {
+	return NewMemoryRedisManagerWithConfig(RedisConfig{})
+}
+
+func NewMemoryRedisManagerWithConfig(config RedisConfig) IRedisManager {
+	return &memoryRedisManager{entries: make(map[string]*memoryRedisEntry), metrics: config.Metrics}
+}
+
+func (this *memoryRedisManager) Open() {
+}
+
+func (this *memoryRedisManager) Client() *redis.Client {
+	return nil
+}
+
+func (this *memoryRedisManager) UniversalClient() redis.UniversalClient {
+	return nil
+}
+
+/**
+ * 无钩子可回调，返回自身
+ */
+func (this *memoryRedisManager) WithContext(ctx context.Context) IRedisManager {
+	return this
+}
+
+/**
+ * 取未过期的key，需持有锁
+ */
+func (this *memoryRedisManager) entry(key string) *memoryRedisEntry {
+	entry, ok := this.entries[key]
+	if !ok {
+		return nil
+	}
+	if !entry.expireAt.IsZero() && !timeNow().Before(entry.expireAt) {
+		delete(this.entries, key)
+		return nil
+	}
+	return entry
+}
+
+/**
+ * 取hash，key不存在时返回 nil，类型不符时返回错误，需持有锁
+ */
+func (this *memoryRedisManager) hash(key string) (map[string]string, error) {
+	entry := this.entry(key)
+	if entry == nil {
+		return nil, nil
+	}
+	if entry.hash == nil {
+		return nil, errMemoryRedisWrongType
+	}
+	return entry.hash, nil
+}
+
+func (this *memoryRedisManager) Set(key string, value interface{}, expiration time.Duration) error {
+	text, err := formatRedisArg(value)
+	if err != nil {
+		return err
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.set(key, text, expiration)
+	return nil
+}
+
+func (this *memoryRedisManager) set(key, value string, expiration time.Duration) {
+	entry := &memoryRedisEntry{value: value}
+	if expiration > 0 {
+		entry.expireAt = timeNow().Add(expiration)
+	}
+	this.entries[key] = entry
+}
+
+func (this *memoryRedisManager) Get(key string) (string, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	entry := this.entry(key)
+	if entry == nil {
+		return "", nil
+	}
+	if entry.hash != nil {
+		return "", errMemoryRedisWrongType
+	}
+	return entry.value, nil
+}
+
+func (this *memoryRedisManager) Delete(key string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	delete(this.entries, key)
+}
+
+func (this *memoryRedisManager) HashExists(key, field string) (bool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	hash, err := this.hash(key)
+	if err != nil {
+		return false, err
+	}
+	_, ok := hash[field]
+	return ok, nil
+}
+
+func (this *memoryRedisManager) HashLen(key string) (int64, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	hash, err := this.hash(key)
+	return int64(len(hash)), err
+}
+
+func (this *memoryRedisManager) HashSet(key string, values ...interface{}) error {
+	return this.hashSet("hset", key, values)
+}
+
+func (this *memoryRedisManager) HashMSet(key string, values ...interface{}) error {
+	return this.hashSet("hmset", key, values)
+}
+
+/**
+ * 参数形式与 go-redis 一致：field, value 交替，或单个 map[string]interface{}/map[string]string/[]string
+ */
+func (this *memoryRedisManager) hashSet(command, key string, values []interface{}) error {
+	var args []interface{}
+	if len(values) == 1 {
+		switch value := values[0].(type) {
+		case map[string]interface{}:
+			for field, v := range value {
+				args = append(args, field, v)
+			}
+		case map[string]string:
+			for field, v := range value {
+				args = append(args, field, v)
+			}
+		case []string:
+			for _, v := range value {
+				args = append(args, v)
+			}
+		case []interface{}:
+			args = value
+		default:
+			args = values
+		}
+	} else {
+		args = values
+	}
+	if len(args) == 0 || len(args)%2 != 0 {
+		return fmt.Errorf("ERR wrong number of arguments for '%s' command", command)
+	}
+	fields := make(map[string]string, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		field, err := formatRedisArg(args[i])
+		if err != nil {
+			return err
+		}
+		value, err := formatRedisArg(args[i+1])
+		if err != nil {
+			return err
+		}
+		fields[field] = value
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	hash, err := this.hash(key)
+	if err != nil {
+		return err
+	}
+	if hash == nil {
+		hash = make(map[string]string, len(fields))
+		this.entries[key] = &memoryRedisEntry{hash: hash}
+	}
+	for field, value := range fields {
+		hash[field] = value
+	}
+	return nil
+}
+
+func (this *memoryRedisManager) HashGet(key, field string) (string, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	hash, err := this.hash(key)
+	if err != nil {
+		return "", err
+	}
+	value, ok := hash[field]
+	if !ok {
+		return "", redis.Nil
+	}
+	return value, nil
+}
+
+func (this *memoryRedisManager) HashMGet(key string, fields ...string) ([]interface{}, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	hash, err := this.hash(key)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		if value, ok := hash[field]; ok {
+			values[i] = value
+		}
+	}
+	return values, nil
+}
+
+func (this *memoryRedisManager) HashDelete(key string, fields ...string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	hash, err := this.hash(key)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		delete(hash, field)
+	}
+	if hash != nil && len(hash) == 0 {
+		delete(this.entries, key)
+	}
+	return nil
+}
+
+func (this *memoryRedisManager) HashKeys(key string) ([]string, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	hash, err := this.hash(key)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(hash))
+	for field := range hash {
+		keys = append(keys, field)
+	}
+	return keys, nil
+}
+
+func (this *memoryRedisManager) HashVals(key string) ([]string, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	hash, err := this.hash(key)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0, len(hash))
+	for _, value := range hash {
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (this *memoryRedisManager) HashGetAll(key string) (map[string]string, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	hash, err := this.hash(key)
+	if err != nil {
+		return nil, err
+	}
+	all := make(map[string]string, len(hash))
+	for field, value := range hash {
+		all[field] = value
+	}
+	return all, nil
+}
+
+func (this *memoryRedisManager) TryLock(key string, expiration time.Duration) (result bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.entry(key) != nil {
+		this.metrics.ObserveLock(false)
+		return false
+	}
+	this.set(key, "1", expiration)
+	this.metrics.ObserveLock(true)
+	return true
+}
+
+func (this *memoryRedisManager) ReleaseLock(key string) (result bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.entry(key) == nil {
+		return false
+	}
+	delete(this.entries, key)
+	return true
+}
+
+/**
+ * 与 go-redis 参数编码一致
+ */
+func formatRedisArg(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case encoding.BinaryMarshaler:
+		b, err := v.MarshalBinary()
+		return string(b), err
+	}
+	return "", fmt.Errorf("redis: can't marshal %T (implement encoding.BinaryMarshaler)", value)
+}
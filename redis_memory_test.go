@@ -0,0 +1,110 @@
+package dam
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+func TestMemoryRedisStrings(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	now := time.Unix(1600000000, 0)
+	timeNow = func() time.Time { return now }
+
+	manager := NewMemoryRedisManager()
+	if err := manager.Set("a", 12, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := manager.Get("a"); value != "12" || err != nil {
+		t.Errorf("aspect 12, but get %q, %v", value, err)
+	}
+	now = now.Add(time.Second)
+	if value, err := manager.Get("a"); value != "" || err != nil {
+		t.Errorf("aspect expired key to be empty, but get %q, %v", value, err)
+	}
+	if err := manager.Set("b", struct{}{}, 0); err == nil {
+		t.Error("aspect marshal error")
+	}
+
+	if !manager.TryLock("lock", time.Minute) || manager.TryLock("lock", time.Minute) {
+		t.Error("aspect only the first TryLock to succeed")
+	}
+	if !manager.ReleaseLock("lock") || manager.ReleaseLock("lock") {
+		t.Error("aspect only the first ReleaseLock to succeed")
+	}
+	manager.TryLock("lock", time.Minute)
+	now = now.Add(time.Minute)
+	if !manager.TryLock("lock", time.Minute) {
+		t.Error("aspect TryLock to succeed after expiration")
+	}
+}
+
+func TestMemoryRedisLockMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	manager := NewMemoryRedisManagerWithConfig(RedisConfig{Metrics: metrics})
+	manager.TryLock("lock", time.Minute)
+	manager.TryLock("lock", time.Minute)
+	manager.TryLock("other", time.Minute)
+	var buf bytes.Buffer
+	if _, err := metrics.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`godam_redis_lock_total{result="acquired"} 2`, `godam_redis_lock_total{result="failed"} 1`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("aspect %s in:\n%s", want, buf.String())
+		}
+	}
+	if manager.Client() != nil || manager.UniversalClient() != nil {
+		t.Error("aspect nil client")
+	}
+}
+
+func TestMemoryRedisHash(t *testing.T) {
+	manager := NewMemoryRedisManager()
+	if _, err := manager.HashGet("h", "f"); err != redis.Nil {
+		t.Errorf("aspect redis.Nil, but get %v", err)
+	}
+	if err := manager.HashSet("h", "f"); err == nil {
+		t.Error("aspect wrong number of arguments error")
+	}
+	if err := manager.HashSet("h", "f1", 1, "f2", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.HashMSet("h", map[string]interface{}{"f3": "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := manager.HashGet("h", "f2"); value != "1" || err != nil {
+		t.Errorf("aspect 1, but get %q, %v", value, err)
+	}
+	if values, _ := manager.HashMGet("h", "f1", "none"); !reflect.DeepEqual(values, []interface{}{"1", nil}) {
+		t.Errorf("unexpected HashMGet %v", values)
+	}
+	if length, _ := manager.HashLen("h"); length != 3 {
+		t.Errorf("aspect 3 fields, but get %d", length)
+	}
+	keys, _ := manager.HashKeys("h")
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"f1", "f2", "f3"}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+	if all, _ := manager.HashGetAll("h"); !reflect.DeepEqual(all, map[string]string{"f1": "1", "f2": "1", "f3": "c"}) {
+		t.Errorf("unexpected HashGetAll %v", all)
+	}
+	manager.HashDelete("h", "f1", "f2", "f3")
+	if exists, _ := manager.HashExists("h", "f1"); exists {
+		t.Error("aspect deleted field not to exist")
+	}
+
+	manager.Set("s", "v", 0)
+	if _, err := manager.HashGet("s", "f"); err != errMemoryRedisWrongType {
+		t.Errorf("aspect WRONGTYPE, but get %v", err)
+	}
+	if _, err := manager.HashMGet("missing", "f"); err != nil {
+		t.Errorf("aspect no error for missing key, but get %v", err)
+	}
+}
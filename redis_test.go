@@ -2,6 +2,7 @@ package dam
 
 import (
 	"fmt"
+	"os"
 	"sync"
 	"testing"
 	"time"
@@ -9,9 +10,17 @@ import (
 
 var redisManager IRedisManager
 var key_saved string = "github.com/seanbit/goweb/dam/redis_test/key_saved"
+/**
+ * 默认使用内存实现，设置 GODAM_REDIS_HOST 时连接真实redis
+ */
 func redisStart()  {
+	host := os.Getenv("GODAM_REDIS_HOST")
+	if host == "" {
+		redisManager = NewMemoryRedisManager()
+		return
+	}
 	config := RedisConfig{
-		Host:        "127.0.0.1:6379",
+		Host:        host,
 		Password:    "",
 		MaxIdle:     30,
 		MaxActive:   30,
@@ -0,0 +1,103 @@
+package dam
+
+/**
+ * redis部署模式
+ *   standalone: 单节点，使用 Host
+ *   sentinel:   哨兵，使用 MasterName 与哨兵地址 Addrs，主从切换后自动连接新主节点
+ *   cluster:    集群，使用种子节点地址 Addrs，按 key 路由到所在节点
+ * 三种模式下 IRedisManager 的行为一致；Client 返回 *redis.Client(sentinel 模式为自动切换主节点的客户端)，
+ * cluster 模式下为 nil，需兼容各模式时使用 UniversalClient，
+ * 需要逐节点执行的命令(如 SCAN)可断言为 *redis.ClusterClient 后使用 ForEachMaster
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-redis/redis/v7"
+)
+
+type RedisMode string
+
+const (
+	RedisModeStandalone RedisMode = "standalone"
+	RedisModeSentinel   RedisMode = "sentinel"
+	RedisModeCluster    RedisMode = "cluster"
+)
+
+/**
+ * 按部署模式创建客户端，配置不完整时返回错误
+ */
+func newRedisClient(config RedisConfig) (redis.UniversalClient, error) {
+	switch config.Mode {
+	case "", RedisModeStandalone:
+		if config.Host == "" {
+			return nil, errors.New("redis standalone mode requires host")
+		}
+		return redis.NewClient(&redis.Options{
+			Addr:        config.Host,
+			Password:    config.Password,
+			DB:          0,
+			IdleTimeout: config.IdleTimeout,
+		}), nil
+	case RedisModeSentinel:
+		if config.MasterName == "" || len(config.Addrs) == 0 {
+			return nil, errors.New("redis sentinel mode requires master_name and addrs")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    config.MasterName,
+			SentinelAddrs: config.Addrs,
+			Password:      config.Password,
+			DB:            0,
+			IdleTimeout:   config.IdleTimeout,
+		}), nil
+	case RedisModeCluster:
+		if len(config.Addrs) == 0 {
+			return nil, errors.New("redis cluster mode requires addrs")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:       config.Addrs,
+			Password:    config.Password,
+			IdleTimeout: config.IdleTimeout,
+		}), nil
+	}
+	return nil, fmt.Errorf("redis mode %q is unsupported", config.Mode)
+}
+
+/**
+ * 用于日志与命令行展示的地址：standalone 为 Host，sentinel 为 master@哨兵地址，cluster 为种子节点地址
+ */
+func (this RedisConfig) Endpoint() string {
+	switch this.Mode {
+	case RedisModeSentinel:
+		return this.MasterName + "@" + strings.Join(this.Addrs, ",")
+	case RedisModeCluster:
+		return strings.Join(this.Addrs, ",")
+	}
+	return this.Host
+}
+
+/**
+ * 连接池状态，*redis.Client 与 *redis.ClusterClient(汇总全部节点)均支持
+ */
+func redisPoolStats(client redis.UniversalClient) *redis.PoolStats {
+	if pooled, ok := client.(interface{ PoolStats() *redis.PoolStats }); ok {
+		return pooled.PoolStats()
+	}
+	return &redis.PoolStats{}
+}
+
+/**
+ * 绑定 context 的客户端，与原客户端共享连接池与钩子
+ */
+func redisClientWithContext(client redis.UniversalClient, ctx context.Context) redis.UniversalClient {
+	switch c := client.(type) {
+	case *redis.Client:
+		return c.WithContext(ctx)
+	case *redis.ClusterClient:
+		return c.WithContext(ctx)
+	}
+	return client
+}
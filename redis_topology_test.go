@@ -0,0 +1,246 @@
+package dam
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+func TestNewRedisClientModes(t *testing.T) {
+	cases := []struct {
+		config   RedisConfig
+		endpoint string
+		cluster  bool
+	}{
+		{RedisConfig{Host: "127.0.0.1:6379"}, "127.0.0.1:6379", false},
+		{RedisConfig{Mode: RedisModeStandalone, Host: "127.0.0.1:6379"}, "127.0.0.1:6379", false},
+		{RedisConfig{Mode: RedisModeSentinel, MasterName: "mymaster", Addrs: []string{"10.0.0.1:26379", "10.0.0.2:26379"}}, "mymaster@10.0.0.1:26379,10.0.0.2:26379", false},
+		{RedisConfig{Mode: RedisModeCluster, Addrs: []string{"10.0.0.1:7000", "10.0.0.2:7000"}}, "10.0.0.1:7000,10.0.0.2:7000", true},
+	}
+	for _, c := range cases {
+		client, err := newRedisClient(c.config)
+		if err != nil {
+			t.Errorf("%s: aspect client, but get %v", c.config.Mode, err)
+			continue
+		}
+		if _, ok := client.(*redis.ClusterClient); ok != c.cluster {
+			t.Errorf("%s: aspect cluster client %v, but get %T", c.config.Mode, c.cluster, client)
+		}
+		if endpoint := c.config.Endpoint(); endpoint != c.endpoint {
+			t.Errorf("%s: aspect endpoint %s, but get %s", c.config.Mode, c.endpoint, endpoint)
+		}
+		client.Close()
+	}
+}
+
+func TestNewRedisClientInvalid(t *testing.T) {
+	configs := []RedisConfig{
+		{},
+		{Mode: RedisModeSentinel, Addrs: []string{"10.0.0.1:26379"}},
+		{Mode: RedisModeSentinel, MasterName: "mymaster"},
+		{Mode: RedisModeCluster, Host: "127.0.0.1:6379"},
+		{Mode: "ring", Host: "127.0.0.1:6379"},
+	}
+	for _, config := range configs {
+		if _, err := newRedisClient(config); err == nil {
+			t.Errorf("aspect error for %+v, but get nil", config)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("aspect NewRedisManager panic on invalid config")
+		}
+	}()
+	NewRedisManager(RedisConfig{Mode: RedisModeCluster})
+}
+
+func TestRedisManagerClient(t *testing.T) {
+	configs := []RedisConfig{
+		{Host: "127.0.0.1:1"},
+		{Mode: RedisModeSentinel, MasterName: "mymaster", Addrs: []string{"127.0.0.1:1"}},
+		{Mode: RedisModeCluster, Addrs: []string{"127.0.0.1:1"}},
+	}
+	for _, config := range configs {
+		config.IdleTimeout = time.Second
+		config.Logger = NopLogger()
+		manager := NewRedisManager(config)
+		if manager.UniversalClient() == nil {
+			t.Errorf("%s: aspect universal client, but get nil", config.Mode)
+		}
+		if cluster := config.Mode == RedisModeCluster; (manager.Client() == nil) != cluster {
+			t.Errorf("%s: aspect nil client only in cluster mode, but get %v", config.Mode, manager.Client())
+		}
+		manager.UniversalClient().Close()
+	}
+}
+
+func TestRedisManagerClusterMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	manager := NewRedisManager(RedisConfig{
+		Mode:        RedisModeCluster,
+		Addrs:       []string{"127.0.0.1:1"},
+		IdleTimeout: time.Second,
+		Metrics:     metrics,
+		Logger:      NopLogger(),
+	})
+	defer manager.UniversalClient().Close()
+	var buf bytes.Buffer
+	if _, err := metrics.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "godam_redis_pool_total_connections") {
+		t.Errorf("aspect cluster pool metrics, but get %s", buf.String())
+	}
+}
+
+/**
+ * 连接环境变量指定的redis，未设置或不可达时跳过：
+ *   GODAM_REDIS_HOST=127.0.0.1:6379
+ *   GODAM_REDIS_SENTINEL=mymaster@127.0.0.1:26379,127.0.0.1:26380
+ *   GODAM_REDIS_CLUSTER=127.0.0.1:7000,127.0.0.1:7001
+ */
+func redisTopologyManager(t *testing.T, mode RedisMode) IRedisManager {
+	config := RedisConfig{Mode: mode, IdleTimeout: time.Minute, Logger: NopLogger()}
+	var name string
+	switch mode {
+	case RedisModeStandalone:
+		name = "GODAM_REDIS_HOST"
+		config.Host = os.Getenv(name)
+	case RedisModeSentinel:
+		name = "GODAM_REDIS_SENTINEL"
+		if parts := strings.SplitN(os.Getenv(name), "@", 2); len(parts) == 2 {
+			config.MasterName, config.Addrs = parts[0], strings.Split(parts[1], ",")
+		}
+	case RedisModeCluster:
+		name = "GODAM_REDIS_CLUSTER"
+		if addrs := os.Getenv(name); addrs != "" {
+			config.Addrs = strings.Split(addrs, ",")
+		}
+	}
+	if _, err := newRedisClient(config); err != nil {
+		t.Skipf("%s not set: %v", name, err)
+	}
+	manager := NewRedisManager(config)
+	t.Cleanup(func() { manager.UniversalClient().Close() })
+	if err := manager.UniversalClient().Ping().Err(); err != nil {
+		t.Skipf("%s unavailable: %v", config.Endpoint(), err)
+	}
+	return manager
+}
+
+func TestRedisManagerBehavior(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testRedisManagerBehavior(t, NewMemoryRedisManager())
+	})
+	for _, mode := range []RedisMode{RedisModeStandalone, RedisModeSentinel, RedisModeCluster} {
+		mode := mode
+		t.Run(string(mode), func(t *testing.T) {
+			testRedisManagerBehavior(t, redisTopologyManager(t, mode))
+		})
+	}
+}
+
+/**
+ * 各部署模式及内存实现须有一致的返回值与错误语义
+ */
+func testRedisManagerBehavior(t *testing.T, manager IRedisManager) {
+	prefix := fmt.Sprintf("godam:test:%d:", time.Now().UnixNano())
+	key, hash, lock := prefix+"key", prefix+"hash", prefix+"lock"
+	defer func() {
+		manager.Delete(key)
+		manager.Delete(hash)
+		manager.Delete(lock)
+	}()
+
+	if value, err := manager.Get(key); value != "" || err != nil {
+		t.Errorf("aspect \"\", nil for missing key, but get %q, %v", value, err)
+	}
+	if err := manager.Set(key, "value", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := manager.Get(key); value != "value" || err != nil {
+		t.Errorf("aspect value, but get %q, %v", value, err)
+	}
+	manager.Delete(key)
+	if value, _ := manager.Get(key); value != "" {
+		t.Errorf("aspect key deleted, but get %q", value)
+	}
+
+	if _, err := manager.HashGet(hash, "f1"); err != redis.Nil {
+		t.Errorf("aspect redis.Nil for missing field, but get %v", err)
+	}
+	if err := manager.HashSet(hash, "f1", "a", "f2", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := manager.HashExists(hash, "f1"); !exists || err != nil {
+		t.Errorf("aspect f1 exists, but get %v, %v", exists, err)
+	}
+	if length, err := manager.HashLen(hash); length != 2 || err != nil {
+		t.Errorf("aspect hash len 2, but get %d, %v", length, err)
+	}
+	if value, err := manager.HashGet(hash, "f1"); value != "a" || err != nil {
+		t.Errorf("aspect a, but get %q, %v", value, err)
+	}
+	if values, err := manager.HashMGet(hash, "f1", "f3"); err != nil || !reflect.DeepEqual(values, []interface{}{"a", nil}) {
+		t.Errorf("aspect [a <nil>], but get %v, %v", values, err)
+	}
+	if err := manager.HashDelete(hash, "f1"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := manager.HashKeys(hash); err != nil || !reflect.DeepEqual(keys, []string{"f2"}) {
+		t.Errorf("aspect [f2], but get %v, %v", keys, err)
+	}
+	if vals, err := manager.HashVals(hash); err != nil || !reflect.DeepEqual(vals, []string{"b"}) {
+		t.Errorf("aspect [b], but get %v, %v", vals, err)
+	}
+	if all, err := manager.HashGetAll(hash); err != nil || !reflect.DeepEqual(all, map[string]string{"f2": "b"}) {
+		t.Errorf("aspect map[f2:b], but get %v, %v", all, err)
+	}
+
+	if !manager.TryLock(lock, time.Minute) || manager.TryLock(lock, time.Minute) {
+		t.Error("aspect only the first TryLock to succeed")
+	}
+	if !manager.ReleaseLock(lock) || manager.ReleaseLock(lock) {
+		t.Error("aspect only the first ReleaseLock to succeed")
+	}
+
+	client := manager.UniversalClient()
+	if client == nil {
+		return
+	}
+	config := WorkerLeaseConfig{Redis: manager, KeyPrefix: prefix + "worker:", TTL: time.Minute, Heartbeat: time.Hour}
+	first, err := acquireWorkerLease(config, NopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.release()
+	second, err := acquireWorkerLease(config, NopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.release()
+	if first.workerId != 0 || second.workerId != 1 {
+		t.Errorf("aspect worker ids 0 and 1, but get %d and %d", first.workerId, second.workerId)
+	}
+	if renewed, err := workerLeaseRenewScript.Run(client, []string{first.key}, first.token, first.ttl.Milliseconds()).Int(); renewed != 1 || err != nil {
+		t.Errorf("aspect renew by holder, but get %d, %v", renewed, err)
+	}
+	if renewed, err := workerLeaseRenewScript.Run(client, []string{first.key}, second.token, first.ttl.Milliseconds()).Int(); renewed != 0 || err != nil {
+		t.Errorf("aspect renew by other token refused, but get %d, %v", renewed, err)
+	}
+	if released, err := workerLeaseReleaseScript.Run(client, []string{first.key}, second.token).Int(); released != 0 || err != nil {
+		t.Errorf("aspect release by other token refused, but get %d, %v", released, err)
+	}
+	if err := first.release(); err != nil {
+		t.Fatal(err)
+	}
+	if exists := client.Exists(first.key).Val(); exists != 0 {
+		t.Errorf("aspect lease key released, but get exists %d", exists)
+	}
+}
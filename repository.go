@@ -0,0 +1,413 @@
+package dam
+
+/**
+ * 通用分库仓储
+ * 基于 db 标签反射任意嵌入 Model 的结构体，按分库键字段路由，
+ * 提供 Insert/Get/Update/SoftDelete/Restore/FindAll，替代每个实体手写的 dao
+ *
+ *   users := dam.NewRepository(dam.Mysql(), User{}, dam.RepositoryConfig{
+ *       Table: "user", IdColumn: "user_id", ShardKeyColumn: "user_name",
+ *   })
+ *   err := users.Insert(ctx, &User{UserName: "yang", Password: password})
+ *
+ * 配置 VersionColumn 后 Update 启用乐观锁：以实体中的版本号为条件更新并自增，
+ * 版本不一致时返回 ErrStaleVersion，调用方重新读取后重试
+ */
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	ErrShardKeyChanged = errors.New("shard key column can not be updated")
+	ErrStaleVersion    = errors.New("stale version")
+)
+
+/**
+ * 乐观锁冲突，errors.Is(err, ErrStaleVersion) 成立
+ */
+type StaleVersionError struct {
+	Table   string
+	Id      interface{}
+	Version int64
+}
+
+func (this *StaleVersionError) Error() string {
+	return fmt.Sprintf("%s %v: stale version %d", this.Table, this.Id, this.Version)
+}
+
+func (this *StaleVersionError) Is(target error) bool {
+	return target == ErrStaleVersion
+}
+
+type RepositoryConfig struct {
+	/** 表名 **/
+	Table string
+	/** 主键列，Insert 时为零值则以 IdGenerator 填充 **/
+	IdColumn string
+	/** 分库键列，其值经 Dna 计算所在分库 **/
+	ShardKeyColumn string
+	/** 可选，版本列(整数)，用于乐观锁 **/
+	VersionColumn string
+	/** 可选，Insert 生成主键的id生成器，默认为 manager 的雪花id **/
+	IdGenerator IIdGenerator
+}
+
+type Repository struct {
+	manager  IMysqlManager
+	config   RepositoryConfig
+	typ      reflect.Type
+	columns  []repositoryColumn
+	id       repositoryColumn
+	shardKey repositoryColumn
+	version  *repositoryColumn
+	model    []int
+}
+
+type repositoryColumn struct {
+	name  string
+	index []int
+	/** 是否为 Model 中的公共字段 **/
+	model bool
+}
+
+var modelType = reflect.TypeOf(Model{})
+
+/**
+ * prototype 为实体结构体或其指针，必须嵌入 Model，配置的列需存在对应 db 标签
+ */
+func NewRepository(manager IMysqlManager, prototype interface{}, config RepositoryConfig) *Repository {
+	typ := reflect.TypeOf(prototype)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("repository %s: prototype must be a struct, get %T", config.Table, prototype))
+	}
+	model := modelFieldIndex(typ)
+	if model == nil {
+		panic(fmt.Sprintf("repository %s: %s does not embed dam.Model", config.Table, typ))
+	}
+	repository := &Repository{
+		manager: manager,
+		config:  config,
+		typ:     typ,
+		columns: structColumns(typ, nil, false),
+		model:   model,
+	}
+	var ok bool
+	if repository.id, ok = repository.column(config.IdColumn); !ok {
+		panic(fmt.Sprintf("repository %s: id column %q not found in %s", config.Table, config.IdColumn, typ))
+	}
+	if repository.shardKey, ok = repository.column(config.ShardKeyColumn); !ok {
+		panic(fmt.Sprintf("repository %s: shard key column %q not found in %s", config.Table, config.ShardKeyColumn, typ))
+	}
+	if config.VersionColumn != "" {
+		version, ok := repository.column(config.VersionColumn)
+		if !ok {
+			panic(fmt.Sprintf("repository %s: version column %q not found in %s", config.Table, config.VersionColumn, typ))
+		}
+		switch typ.FieldByIndex(version.index).Type.Kind() {
+		case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		default:
+			panic(fmt.Sprintf("repository %s: version column %q must be an integer", config.Table, config.VersionColumn))
+		}
+		repository.version = &version
+	}
+	return repository
+}
+
+/**
+ * 按 sqlx 规则展开字段：匿名结构体递归，db 标签为列名，无标签时为小写字段名，"-" 忽略
+ */
+func structColumns(typ reflect.Type, parent []int, model bool) (columns []repositoryColumn) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		index := append(append([]int(nil), parent...), i)
+		tag := field.Tag.Get("db")
+		if tag == "-" || field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			columns = append(columns, structColumns(field.Type, index, model || field.Type == modelType)...)
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		columns = append(columns, repositoryColumn{name: name, index: index, model: model})
+	}
+	return columns
+}
+
+func (this *Repository) column(name string) (repositoryColumn, bool) {
+	for _, column := range this.columns {
+		if column.name == name {
+			return column, true
+		}
+	}
+	return repositoryColumn{}, false
+}
+
+/**
+ * 实体指针 => 结构体 reflect.Value
+ */
+func (this *Repository) entityValue(entity interface{}) (reflect.Value, error) {
+	value := reflect.ValueOf(entity)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Type() != this.typ {
+		return reflect.Value{}, fmt.Errorf("repository %s: entity must be *%s, get %T", this.config.Table, this.typ, entity)
+	}
+	return value.Elem(), nil
+}
+
+/**
+ * 根据分库键获取db
+ */
+func (this *Repository) Db(shardKey interface{}) (*ShardDB, error) {
+	return this.manager.GetDbByUserName(fmt.Sprint(shardKey))
+}
+
+/**
+ * 新增，主键为零值时生成分布式id并回写
+ * create_time/update_time 取当前时间，update_user 取 context 中的操作人
+ */
+func (this *Repository) Insert(ctx context.Context, entity interface{}) error {
+	value, err := this.entityValue(entity)
+	if err != nil {
+		return err
+	}
+	db, err := this.Db(value.FieldByIndex(this.shardKey.index).Interface())
+	if err != nil {
+		return err
+	}
+	if id := value.FieldByIndex(this.id.index); isZero(id) {
+		if err := setGeneratedId(id, this.idGenerator()); err != nil {
+			return fmt.Errorf("repository %s: %w", this.config.Table, err)
+		}
+	}
+	this.fillInsertAudit(ctx, value, auditTime())
+	_, err = db.ExecContext(ctx, this.insertSQL(1), this.insertArgs(value)...)
+	return err
+}
+
+func (this *Repository) idGenerator() IIdGenerator {
+	if this.config.IdGenerator != nil {
+		return this.config.IdGenerator
+	}
+	return this.manager.IdGenerator()
+}
+
+func (this *Repository) fillInsertAudit(ctx context.Context, value reflect.Value, now time.Time) {
+	model := value.FieldByIndex(this.model).Addr().Interface().(*Model)
+	model.CreateTime = now
+	model.UpdateTime = now
+	model.UpdateUser = ActorFromContext(ctx)
+}
+
+/**
+ * rows 行的 insert 语句
+ */
+func (this *Repository) insertSQL(rows int) string {
+	columns := this.columnNames()
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	return fmt.Sprintf("insert into %s(%s)values%s", this.config.Table,
+		strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat(row+", ", rows), ", "))
+}
+
+func (this *Repository) insertArgs(value reflect.Value) []interface{} {
+	args := make([]interface{}, 0, len(this.columns))
+	for _, column := range this.columns {
+		args = append(args, value.FieldByIndex(column.index).Interface())
+	}
+	return args
+}
+
+/**
+ * 根据主键查询未删除的实体到 dest，不存在时返回 sql.ErrNoRows
+ */
+func (this *Repository) Get(ctx context.Context, dest interface{}, id, shardKey interface{}) error {
+	db, err := this.Db(shardKey)
+	if err != nil {
+		return err
+	}
+	return this.byId(id).Get(ctx, db, dest)
+}
+
+/**
+ * 只更新与库中当前值不同的列，返回实际更新的列名；实体不存在时返回 sql.ErrNoRows
+ * 有变更时同时写入 update_time 与 update_user 并回写到实体
+ * 启用乐观锁时版本号不一致返回 *StaleVersionError，成功后实体版本号加一
+ */
+func (this *Repository) Update(ctx context.Context, entity interface{}) (changed []string, err error) {
+	value, err := this.entityValue(entity)
+	if err != nil {
+		return nil, err
+	}
+	id := value.FieldByIndex(this.id.index).Interface()
+	shardKey := value.FieldByIndex(this.shardKey.index).Interface()
+	db, err := this.Db(shardKey)
+	if err != nil {
+		return nil, err
+	}
+	current := reflect.New(this.typ)
+	if err := this.byId(id).Get(ctx, db, current.Interface()); err != nil {
+		return nil, err
+	}
+	if !reflect.DeepEqual(current.Elem().FieldByIndex(this.shardKey.index).Interface(), shardKey) {
+		return nil, ErrShardKeyChanged
+	}
+	var version int64
+	if this.version != nil {
+		version = intValue(value.FieldByIndex(this.version.index))
+		if intValue(current.Elem().FieldByIndex(this.version.index)) != version {
+			return nil, &StaleVersionError{Table: this.config.Table, Id: id, Version: version}
+		}
+	}
+
+	set := make(map[string]interface{})
+	for _, column := range this.columns {
+		if column.model || column.name == this.id.name || column.name == this.shardKey.name ||
+			this.version != nil && column.name == this.version.name {
+			continue
+		}
+		newValue := value.FieldByIndex(column.index).Interface()
+		if !reflect.DeepEqual(current.Elem().FieldByIndex(column.index).Interface(), newValue) {
+			set[column.name] = newValue
+			changed = append(changed, column.name)
+		}
+	}
+	if len(set) == 0 {
+		return nil, nil
+	}
+	set = withAuditColumns(ctx, set)
+	query := this.byId(id)
+	if this.version != nil {
+		set[this.version.name] = version + 1
+		query.Where(this.version.name+"=?", version)
+	}
+	rows, err := query.Update(ctx, db, set)
+	if err != nil {
+		return nil, err
+	}
+	if this.version != nil {
+		if rows == 0 {
+			return nil, &StaleVersionError{Table: this.config.Table, Id: id, Version: version}
+		}
+		setIntValue(value.FieldByIndex(this.version.index), version+1)
+	}
+	model := value.FieldByIndex(this.model).Addr().Interface().(*Model)
+	model.UpdateTime = set[columnUpdateTime].(time.Time)
+	model.UpdateUser = set[columnUpdateUser].(string)
+	return changed, nil
+}
+
+/**
+ * 软删除，不存在未删除的实体时返回 sql.ErrNoRows
+ */
+func (this *Repository) SoftDelete(ctx context.Context, id, shardKey interface{}) error {
+	db, err := this.Db(shardKey)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(this.byId(id).SoftDelete(ctx, db))
+}
+
+/**
+ * 恢复软删除，不存在已删除的实体时返回 sql.ErrNoRows
+ */
+func (this *Repository) Restore(ctx context.Context, id, shardKey interface{}) error {
+	db, err := this.Db(shardKey)
+	if err != nil {
+		return err
+	}
+	return affectedOrNoRows(this.byId(id).Restore(ctx, db))
+}
+
+/**
+ * 查询全部分库，dest 为切片指针；某个分库失败时继续其余分库，返回已查到的数据与第一个错误
+ */
+func (this *Repository) FindAll(ctx context.Context, dest interface{}, scope DeletedScope) error {
+	slice := reflect.ValueOf(dest)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("repository %s: dest must be a pointer to slice, get %T", this.config.Table, dest)
+	}
+	var firstErr error
+	for _, db := range this.manager.GetAllDbs() {
+		rows := reflect.New(slice.Elem().Type())
+		query := Table(this.config.Table)
+		query.scope = scope
+		if err := query.Select(ctx, db, rows.Interface()); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		slice.Elem().Set(reflect.AppendSlice(slice.Elem(), rows.Elem()))
+	}
+	return firstErr
+}
+
+func (this *Repository) byId(id interface{}) *Query {
+	return Table(this.config.Table).Where(this.id.name+"=?", id)
+}
+
+func affectedOrNoRows(rows int64, err error) error {
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func isZero(value reflect.Value) bool {
+	return reflect.DeepEqual(value.Interface(), reflect.Zero(value.Type()).Interface())
+}
+
+func intValue(value reflect.Value) int64 {
+	switch value.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(value.Uint())
+	}
+	return value.Int()
+}
+
+func setIntValue(value reflect.Value, i int64) {
+	switch value.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value.SetUint(uint64(i))
+	default:
+		value.SetInt(i)
+	}
+}
+
+/**
+ * 字符串主键使用 NextIdString，整数主键使用 NextId
+ */
+func setGeneratedId(value reflect.Value, generator IIdGenerator) error {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Uint64:
+		id, err := generator.NextId()
+		if err != nil {
+			return err
+		}
+		setIntValue(value, id)
+	case reflect.String:
+		id, err := generator.NextIdString()
+		if err != nil {
+			return err
+		}
+		value.SetString(id)
+	default:
+		return fmt.Errorf("id field of kind %s can not hold a generated id", value.Kind())
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package dam_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	dam "github.com/seanbit/godam"
+	"github.com/seanbit/godam/damtest"
+)
+
+const versionedUserSchema = `
+CREATE TABLE versioned_user (
+user_id BIGINT NOT NULL,
+user_name char(255) DEFAULT NULL,
+alias_name char(255) DEFAULT NULL,
+version BIGINT NOT NULL DEFAULT 0,
+create_time timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+update_time timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+update_user char(255) DEFAULT 'system',
+delete_time BIGINT NOT NULL DEFAULT 0,
+PRIMARY KEY (user_id)
+);`
+
+type versionedUser struct {
+	dam.Model
+	UserId    int64  `db:"user_id"`
+	UserName  string `db:"user_name"`
+	AliasName string `db:"alias_name"`
+	Version   int64  `db:"version"`
+}
+
+func newVersionedUserRepository(t *testing.T) (*damtest.Harness, *dam.Repository) {
+	h := damtest.New(2)
+	t.Cleanup(func() { h.Close() })
+	if err := h.LoadSchema(versionedUserSchema); err != nil {
+		t.Fatal(err)
+	}
+	return h, dam.NewRepository(h.Manager, versionedUser{}, dam.RepositoryConfig{
+		Table: "versioned_user", IdColumn: "user_id", ShardKeyColumn: "user_name", VersionColumn: "version",
+	})
+}
+
+func TestRepositoryUpdateVersion(t *testing.T) {
+	h, repository := newVersionedUserRepository(t)
+	ctx := context.Background()
+	entity := &versionedUser{UserId: 1, UserName: "10086", AliasName: "yang"}
+	if err := repository.Insert(ctx, entity); err != nil {
+		t.Fatal(err)
+	}
+
+	entity.AliasName = "sean"
+	if changed, err := repository.Update(ctx, entity); err != nil || len(changed) != 1 || changed[0] != "alias_name" {
+		t.Fatalf("aspect alias_name updated, but get %v, %v", changed, err)
+	}
+	if entity.Version != 1 {
+		t.Errorf("aspect entity version 1, but get %d", entity.Version)
+	}
+	var saved versionedUser
+	if err := repository.Get(ctx, &saved, entity.UserId, entity.UserName); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Version != 1 || saved.AliasName != "sean" {
+		t.Errorf("aspect version 1 and alias sean saved, but get %d, %s", saved.Version, saved.AliasName)
+	}
+
+	/** 实体版本落后于库中版本 **/
+	stale := saved
+	stale.Version = 0
+	stale.AliasName = "stale"
+	_, err := repository.Update(ctx, &stale)
+	var staleErr *dam.StaleVersionError
+	if !errors.As(err, &staleErr) || staleErr.Version != 0 {
+		t.Errorf("aspect *StaleVersionError for version 0, but get %v", err)
+	}
+
+	/** 读取后、更新前版本被并发修改，更新影响0行 **/
+	h.Expect(h.ShardOf(entity.UserName), `^update versioned_user`, damtest.Expectation{RowsAffected: 0})
+	saved.AliasName = "concurrent"
+	if _, err := repository.Update(ctx, &saved); !errors.Is(err, dam.ErrStaleVersion) {
+		t.Errorf("aspect ErrStaleVersion, but get %v", err)
+	}
+	if saved.Version != 1 {
+		t.Errorf("aspect entity version unchanged on conflict, but get %d", saved.Version)
+	}
+}
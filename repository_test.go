@@ -0,0 +1,103 @@
+package dam
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRepositoryColumns(t *testing.T) {
+	repository := NewRepository(nil, &User{}, RepositoryConfig{Table: "user", IdColumn: "user_id", ShardKeyColumn: "user_name"})
+
+	var names []string
+	var modelNames []string
+	for _, column := range repository.columns {
+		names = append(names, column.name)
+		if column.model {
+			modelNames = append(modelNames, column.name)
+		}
+	}
+	if want := []string{"create_time", "update_time", "update_user", "delete_time", "user_id", "user_name", "password", "alias_name", "enabled"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("aspect columns %v, but get %v", want, names)
+	}
+	if want := []string{"create_time", "update_time", "update_user", "delete_time"}; !reflect.DeepEqual(modelNames, want) {
+		t.Errorf("aspect model columns %v, but get %v", want, modelNames)
+	}
+	if repository.id.name != "user_id" || repository.shardKey.name != "user_name" {
+		t.Errorf("unexpected id %q or shard key %q", repository.id.name, repository.shardKey.name)
+	}
+
+	user := &User{UserName: "yang"}
+	value, err := repository.entityValue(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := value.FieldByIndex(repository.shardKey.index).Interface(); name != "yang" {
+		t.Errorf("aspect shard key yang, but get %v", name)
+	}
+	if _, err := repository.entityValue(User{}); err == nil {
+		t.Error("aspect error for non-pointer entity")
+	}
+}
+
+func TestRepositoryInvalidPrototype(t *testing.T) {
+	type plain struct {
+		Id int64 `db:"id"`
+	}
+	var testData = []struct {
+		prototype interface{}
+		config    RepositoryConfig
+	}{
+		{plain{}, RepositoryConfig{Table: "plain", IdColumn: "id", ShardKeyColumn: "id"}},
+		{1, RepositoryConfig{Table: "int"}},
+		{User{}, RepositoryConfig{Table: "user", IdColumn: "id", ShardKeyColumn: "user_name"}},
+		{User{}, RepositoryConfig{Table: "user", IdColumn: "user_id", ShardKeyColumn: "name"}},
+	}
+	for _, data := range testData {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("aspect panic for %T with %+v", data.prototype, data.config)
+				}
+			}()
+			NewRepository(nil, data.prototype, data.config)
+		}()
+	}
+}
+
+type versionedUser struct {
+	User
+	Version int64 `db:"version"`
+}
+
+func TestRepositoryVersionColumn(t *testing.T) {
+	repository := NewRepository(nil, versionedUser{}, RepositoryConfig{
+		Table: "user", IdColumn: "user_id", ShardKeyColumn: "user_name", VersionColumn: "version",
+	})
+	if repository.version == nil || repository.version.name != "version" {
+		t.Fatalf("aspect version column, but get %+v", repository.version)
+	}
+	entity := &versionedUser{Version: 3}
+	value, _ := repository.entityValue(entity)
+	setIntValue(value.FieldByIndex(repository.version.index), intValue(value.FieldByIndex(repository.version.index))+1)
+	if entity.Version != 4 {
+		t.Errorf("aspect version 4, but get %d", entity.Version)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("aspect panic for non-integer version column")
+		}
+	}()
+	NewRepository(nil, User{}, RepositoryConfig{Table: "user", IdColumn: "user_id", ShardKeyColumn: "user_name", VersionColumn: "alias_name"})
+}
+
+func TestStaleVersionError(t *testing.T) {
+	var err error = &StaleVersionError{Table: "user", Id: 1, Version: 2}
+	if !errors.Is(err, ErrStaleVersion) {
+		t.Error("aspect errors.Is(err, ErrStaleVersion)")
+	}
+	if err.Error() != "user 1: stale version 2" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
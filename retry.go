@@ -0,0 +1,145 @@
+package dam
+
+/**
+ * 瞬时故障重试
+ * 通过 MysqlConfig.Retry 开启：
+ *   读操作(Query/Queryx/QueryRowx/Select/Get)在连接错误与锁等待超时时重试，
+ *   Transaction 在死锁时回滚并重试整个事务；写操作不自动重试
+ * 退避时间按指数增长并加入随机抖动，总耗时超过 MaxElapsed 时不再重试
+ */
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+const (
+	operationTransaction = "transaction"
+
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = 10 * time.Millisecond
+	defaultRetryMaxBackoff     = time.Second
+	defaultRetryMaxElapsed     = 5 * time.Second
+)
+
+type RetryPolicy struct {
+	/** 含首次执行的最大次数，默认3 **/
+	MaxAttempts int `json:"max_attempts"`
+	/** 首次重试前的等待，默认10ms **/
+	InitialBackoff time.Duration `json:"initial_backoff"`
+	/** 单次等待上限，默认1s **/
+	MaxBackoff time.Duration `json:"max_backoff"`
+	/** 含首次执行的总耗时上限，默认5s **/
+	MaxElapsed time.Duration `json:"max_elapsed"`
+}
+
+func (this RetryPolicy) withDefaults() RetryPolicy {
+	if this.MaxAttempts <= 0 {
+		this.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if this.InitialBackoff <= 0 {
+		this.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if this.MaxBackoff <= 0 {
+		this.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if this.MaxElapsed <= 0 {
+		this.MaxElapsed = defaultRetryMaxElapsed
+	}
+	return this
+}
+
+/**
+ * 第 attempt 次失败后的等待：InitialBackoff*2^(attempt-1)，不超过 MaxBackoff，取其 [1/2, 1] 区间的随机值
+ */
+func (this RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := this.InitialBackoff
+	for i := 1; i < attempt && backoff < this.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > this.MaxBackoff {
+		backoff = this.MaxBackoff
+	}
+	half := int64(backoff / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+/**
+ * 按策略执行 fn，未配置重试策略时只执行一次
+ */
+func (this *ShardDB) retry(ctx context.Context, operation string, retryable func(error) bool, fn func() error) error {
+	if this.retryPolicy == nil {
+		return fn()
+	}
+	policy := *this.retryPolicy
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !retryable(err) || attempt >= policy.MaxAttempts {
+			return err
+		}
+		wait := policy.backoff(attempt)
+		if time.Since(start)+wait > policy.MaxElapsed {
+			return err
+		}
+		reason := retryReason(err)
+		this.metrics.ObserveRetry(this.shardId, operation, reason)
+		this.logger.Debug("mysql retry", "operation", operation, "attempt", attempt, "reason", reason, "backoff", wait, "error", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+/**
+ * 在事务中执行 fn，fn 中经 tx 执行的语句同样记录指标并回调钩子；fn 返回错误或 panic 时回滚；发生死锁时按重试策略重新执行整个事务，
+ * 因此 fn 可能被调用多次，不应有事务外的副作用
+ */
+func (this *ShardDB) Transaction(ctx context.Context, fn func(tx *ShardTx) error) error {
+	return this.retry(ctx, operationTransaction, isTransactionRetryable, func() (err error) {
+		tx, err := this.BeginTxx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				tx.Rollback()
+				panic(recovered)
+			}
+		}()
+		if err := fn(tx); err != nil {
+			tx.Rollback()
+			return ClassifyError(err)
+		}
+		return tx.Commit()
+	})
+}
+
+/**
+ * 读操作可安全重试
+ */
+func isReadRetryable(err error) bool {
+	return errors.Is(err, ErrConnection) || errors.Is(err, ErrLockWaitTimeout)
+}
+
+func isTransactionRetryable(err error) bool {
+	return errors.Is(err, ErrDeadlock)
+}
+
+func retryReason(err error) string {
+	switch {
+	case errors.Is(err, ErrDeadlock):
+		return "deadlock"
+	case errors.Is(err, ErrLockWaitTimeout):
+		return "lock_wait_timeout"
+	case errors.Is(err, ErrConnection):
+		return "connection"
+	}
+	return "other"
+}
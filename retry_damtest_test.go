@@ -0,0 +1,74 @@
+package dam_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	dam "github.com/seanbit/godam"
+	"github.com/seanbit/godam/damtest"
+)
+
+func TestTransactionDeadlockRetry(t *testing.T) {
+	metrics := dam.NewMetrics()
+	h := damtest.NewWithConfig(1, dam.MysqlConfig{
+		Metrics: metrics,
+		Retry:   &dam.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+	defer h.Close()
+	if err := h.LoadSchema("create table counter (id int not null primary key, value int not null default 0)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.LoadFixtures(0, "counter", map[string]interface{}{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	db := h.Manager.GetAllDbs()[0]
+	ctx := context.Background()
+	increment := func(calls *int) func(tx *dam.ShardTx) error {
+		return func(tx *dam.ShardTx) error {
+			*calls++
+			if _, err := tx.ExecContext(ctx, "update counter set value=value+1 where id=?", 1); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, "update counter set value=value+10 where id=?", 1)
+			return err
+		}
+	}
+
+	/** 第一次执行的第二条语句死锁，回滚后重新执行整个事务 **/
+	h.Expect(0, `value\+10`, damtest.Expectation{Err: &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}})
+	calls := 0
+	if err := db.Transaction(ctx, increment(&calls)); err != nil {
+		t.Fatal(err)
+	}
+	var value int
+	if err := db.Get(&value, "select value from counter where id=?", 1); err != nil || calls != 2 || value != 11 {
+		t.Errorf("aspect 2 calls and value 11, but get %d calls, value %d, %v", calls, value, err)
+	}
+	var buf bytes.Buffer
+	metrics.WriteTo(&buf)
+	if want := `godam_mysql_retries_total{shard="0",operation="transaction",reason="deadlock"} 1`; !strings.Contains(buf.String(), want) {
+		t.Errorf("aspect %s in:\n%s", want, buf.String())
+	}
+
+	/** 唯一键冲突不重试 **/
+	h.Expect(0, `value\+10`, damtest.Expectation{Err: &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}})
+	calls = 0
+	if err := db.Transaction(ctx, increment(&calls)); !errors.Is(err, dam.ErrDuplicateKey) || calls != 1 {
+		t.Errorf("aspect duplicate key without retry, but get %d calls, %v", calls, err)
+	}
+	if err := db.Get(&value, "select value from counter where id=?", 1); err != nil || value != 11 {
+		t.Errorf("aspect rollback keeps value 11, but get %d, %v", value, err)
+	}
+
+	/** 每次都死锁时执行 MaxAttempts 次后返回 **/
+	h.Expect(0, `value\+10`, damtest.Expectation{Times: 3, Err: &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}})
+	calls = 0
+	if err := db.Transaction(ctx, increment(&calls)); !errors.Is(err, dam.ErrDeadlock) || calls != 3 {
+		t.Errorf("aspect deadlock after 3 calls, but get %d calls, %v", calls, err)
+	}
+}
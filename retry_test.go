@@ -0,0 +1,113 @@
+package dam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}.withDefaults()
+	if policy.MaxAttempts != defaultRetryMaxAttempts || policy.MaxElapsed != defaultRetryMaxElapsed {
+		t.Errorf("unexpected defaults %+v", policy)
+	}
+	for attempt, max := range []time.Duration{10, 20, 40, 50, 50} {
+		max *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if wait := policy.backoff(attempt + 1); wait < max/2 || wait > max {
+				t.Fatalf("aspect backoff of attempt %d in [%s, %s], but get %s", attempt+1, max/2, max, wait)
+			}
+		}
+	}
+}
+
+func TestShardDBRetry(t *testing.T) {
+	metrics := NewMetrics()
+	shard := &ShardDB{shardId: 2, metrics: metrics, logger: NopLogger(),
+		retryPolicy: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, MaxElapsed: time.Second}}
+	ctx := context.Background()
+	connectionErr := ClassifyError(mysql.ErrInvalidConn)
+
+	calls := 0
+	err := shard.retry(ctx, operationSelect, isReadRetryable, func() error {
+		calls++
+		if calls < 3 {
+			return connectionErr
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("aspect success after 3 calls, but get %d calls, %v", calls, err)
+	}
+
+	calls = 0
+	err = shard.retry(ctx, operationSelect, isReadRetryable, func() error {
+		calls++
+		return connectionErr
+	})
+	if err != connectionErr || calls != 3 {
+		t.Errorf("aspect give up after 3 calls, but get %d calls, %v", calls, err)
+	}
+
+	calls = 0
+	duplicate := ClassifyError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"})
+	err = shard.retry(ctx, operationSelect, isReadRetryable, func() error {
+		calls++
+		return duplicate
+	})
+	if err != duplicate || calls != 1 {
+		t.Errorf("aspect no retry for duplicate key, but get %d calls", calls)
+	}
+
+	deadlock := ClassifyError(&mysql.MySQLError{Number: 1213, Message: "Deadlock found"})
+	if !isTransactionRetryable(deadlock) || isReadRetryable(deadlock) || isTransactionRetryable(connectionErr) {
+		t.Error("unexpected retryable classification")
+	}
+
+	var buf bytes.Buffer
+	metrics.WriteTo(&buf)
+	if want := `godam_mysql_retries_total{shard="2",operation="select",reason="connection"} 4`; !strings.Contains(buf.String(), want) {
+		t.Errorf("aspect %s in:\n%s", want, buf.String())
+	}
+}
+
+func TestShardDBRetryBudget(t *testing.T) {
+	shard := &ShardDB{logger: NopLogger(),
+		retryPolicy: &RetryPolicy{MaxAttempts: 10, InitialBackoff: 50 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, MaxElapsed: 10 * time.Millisecond}}
+	calls := 0
+	failure := ClassifyError(mysql.ErrInvalidConn)
+	shard.retry(context.Background(), operationGet, isReadRetryable, func() error {
+		calls++
+		return failure
+	})
+	if calls != 1 {
+		t.Errorf("aspect no retry beyond budget, but get %d calls", calls)
+	}
+
+	shard.retryPolicy.MaxElapsed = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	err := shard.retry(ctx, operationGet, isReadRetryable, func() error {
+		calls++
+		cancel()
+		return failure
+	})
+	if calls != 1 || !errors.Is(err, ErrConnection) {
+		t.Errorf("aspect stop on cancelled context, but get %d calls, %v", calls, err)
+	}
+
+	shard.retryPolicy = nil
+	calls = 0
+	shard.retry(context.Background(), operationGet, isReadRetryable, func() error {
+		calls++
+		return failure
+	})
+	if calls != 1 {
+		t.Errorf("aspect single call without policy, but get %d", calls)
+	}
+}
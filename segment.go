@@ -0,0 +1,165 @@
+package dam
+
+/**
+ * 号段id分配
+ * 每次从号段表取一段 (max_id-step, max_id]，当前号段使用超过10%时异步预取下一段(双缓冲)，
+ * 号段表需预先创建：
+ *   create table id_segment (
+ *     biz_tag varchar(128) not null primary key,
+ *     max_id bigint not null default 0,
+ *     update_time datetime not null default current_timestamp on update current_timestamp
+ *   )
+ * 号段表只应存在于一个分库，所有实例使用同一个 ShardDB 以保证id不重复
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+const (
+	defaultSegmentTable = "id_segment"
+	defaultSegmentStep  = 1000
+)
+
+type SegmentConfig struct {
+	/** 号段表，默认 id_segment **/
+	Table string
+	/** 业务标识，号段表主键 **/
+	BizTag string
+	/** 每次取号数量，默认1000 **/
+	Step   int64
+	Logger ILogger
+}
+
+type idSegment struct {
+	next int64
+	max  int64
+}
+
+type segmentIdGenerator struct {
+	db     *ShardDB
+	config SegmentConfig
+	logger ILogger
+
+	mutex sync.Mutex
+	/** 号段加载完成时广播 **/
+	cond    *sync.Cond
+	current *idSegment
+	buffer  *idSegment
+	loading bool
+}
+
+func NewSegmentIdGenerator(db *ShardDB, config SegmentConfig) IIdGenerator {
+	if db == nil {
+		panic("segment id generator: db is required")
+	}
+	if config.BizTag == "" {
+		panic("segment id generator: biz tag is required")
+	}
+	if config.Table == "" {
+		config.Table = defaultSegmentTable
+	}
+	if config.Step <= 0 {
+		config.Step = defaultSegmentStep
+	}
+	generator := &segmentIdGenerator{
+		db:     db,
+		config: config,
+		logger: loggerOrDefault(config.Logger).With("component", "segment", "biz_tag", config.BizTag),
+	}
+	generator.cond = sync.NewCond(&generator.mutex)
+	return generator
+}
+
+func (this *segmentIdGenerator) NextId() (int64, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for {
+		if segment := this.current; segment != nil && segment.next <= segment.max {
+			id := segment.next
+			segment.next++
+			if this.buffer == nil && !this.loading && segment.max-segment.next < this.config.Step*9/10 {
+				this.loading = true
+				go this.preload()
+			}
+			return id, nil
+		}
+		if this.buffer != nil {
+			this.current, this.buffer = this.buffer, nil
+			continue
+		}
+		if this.loading {
+			this.cond.Wait()
+			continue
+		}
+		/** 无可用号段，同步加载 **/
+		this.loading = true
+		this.mutex.Unlock()
+		segment, err := this.fetch(context.Background())
+		this.mutex.Lock()
+		this.loading = false
+		this.cond.Broadcast()
+		if err != nil {
+			return 0, err
+		}
+		this.current = segment
+	}
+}
+
+func (this *segmentIdGenerator) NextIdString() (string, error) {
+	id, err := this.NextId()
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+func (this *segmentIdGenerator) preload() {
+	segment, err := this.fetch(context.Background())
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.loading = false
+	if err != nil {
+		this.logger.Warn("segment preload failed", "error", err)
+	} else {
+		this.buffer = segment
+	}
+	this.cond.Broadcast()
+}
+
+/**
+ * 以 last_insert_id 在一条语句内完成取号与读取，无需事务
+ */
+func (this *segmentIdGenerator) fetch(ctx context.Context) (*idSegment, error) {
+	max, err := this.advance(ctx)
+	if err == errSegmentMissing {
+		if _, err = this.db.ExecContext(ctx, "insert ignore into "+this.config.Table+"(biz_tag, max_id) values (?, 0)", this.config.BizTag); err == nil {
+			max, err = this.advance(ctx)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("segment %s: %v", this.config.BizTag, err)
+	}
+	this.logger.Debug("segment loaded", "min", max-this.config.Step+1, "max", max)
+	return &idSegment{next: max - this.config.Step + 1, max: max}, nil
+}
+
+var errSegmentMissing = errors.New("biz tag not found")
+
+func (this *segmentIdGenerator) advance(ctx context.Context) (int64, error) {
+	result, err := this.db.ExecContext(ctx, "update "+this.config.Table+" set max_id=last_insert_id(max_id+?) where biz_tag=?",
+		this.config.Step, this.config.BizTag)
+	if err != nil {
+		return 0, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return 0, err
+	} else if affected == 0 {
+		return 0, errSegmentMissing
+	}
+	return result.LastInsertId()
+}
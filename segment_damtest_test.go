@@ -0,0 +1,195 @@
+package dam_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dam "github.com/seanbit/godam"
+	"github.com/seanbit/godam/damtest"
+)
+
+const segmentSchema = `
+create table id_segment (
+  biz_tag varchar(128) not null primary key,
+  max_id bigint not null default 0,
+  update_time datetime not null default current_timestamp on update current_timestamp
+);`
+
+/**
+ * 统计号段表的 update，可阻塞第 blockAt 次 update 直至 release 关闭；finished 满后不再通知
+ */
+type segmentHook struct {
+	mutex    sync.Mutex
+	started  int
+	blockAt  int
+	entered  chan struct{}
+	release  chan struct{}
+	finished chan struct{}
+}
+
+func newSegmentHook(blockAt int) *segmentHook {
+	return &segmentHook{blockAt: blockAt, entered: make(chan struct{}), release: make(chan struct{}), finished: make(chan struct{}, 16)}
+}
+
+func (this *segmentHook) BeforeQuery(ctx context.Context, event *dam.QueryEvent) context.Context {
+	if !strings.HasPrefix(event.Query, "update id_segment") {
+		return ctx
+	}
+	this.mutex.Lock()
+	this.started++
+	block := this.started == this.blockAt
+	this.mutex.Unlock()
+	if block {
+		close(this.entered)
+		<-this.release
+	}
+	return ctx
+}
+
+func (this *segmentHook) AfterQuery(ctx context.Context, event *dam.QueryEvent) {
+	if !strings.HasPrefix(event.Query, "update id_segment") {
+		return
+	}
+	select {
+	case this.finished <- struct{}{}:
+	default:
+	}
+}
+
+func (this *segmentHook) updates() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.started
+}
+
+/** 等待 n 次 update 完成 **/
+func (this *segmentHook) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-this.finished:
+		case <-time.After(time.Second):
+			t.Fatalf("aspect %d finished updates, but get %d", n, i)
+		}
+	}
+}
+
+func newSegmentGenerator(t *testing.T, hook *segmentHook, step int64) (*damtest.Harness, dam.IIdGenerator) {
+	h := damtest.NewWithConfig(1, dam.MysqlConfig{Hooks: []dam.IQueryHook{hook}})
+	t.Cleanup(func() { h.Close() })
+	if err := h.LoadSchema(segmentSchema); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.LoadFixtures(0, "id_segment", map[string]interface{}{"biz_tag": "order", "max_id": 0}); err != nil {
+		t.Fatal(err)
+	}
+	return h, dam.NewSegmentIdGenerator(h.Manager.GetAllDbs()[0], dam.SegmentConfig{BizTag: "order", Step: step, Logger: dam.NopLogger()})
+}
+
+func nextIds(t *testing.T, generator dam.IIdGenerator, from, to int64) {
+	t.Helper()
+	for want := from; want <= to; want++ {
+		if id, err := generator.NextId(); err != nil || id != want {
+			t.Fatalf("aspect id %d, but get %d, %v", want, id, err)
+		}
+	}
+}
+
+func TestSegmentMissingBizTag(t *testing.T) {
+	h, existing := newSegmentGenerator(t, newSegmentHook(0), 10)
+	nextIds(t, existing, 1, 1)
+	h.AssertNotQueried(t, 0, `^insert`)
+
+	missing := dam.NewSegmentIdGenerator(h.Manager.GetAllDbs()[0], dam.SegmentConfig{BizTag: "payment", Step: 10, Logger: dam.NopLogger()})
+	nextIds(t, missing, 1, 1)
+	h.AssertQueried(t, 0, `^insert ignore into id_segment`)
+}
+
+func TestSegmentPreload(t *testing.T) {
+	hook := newSegmentHook(0)
+	_, generator := newSegmentGenerator(t, hook, 10)
+	/** 取第一个id后号段已使用超过10%，后台预取下一段 **/
+	nextIds(t, generator, 1, 1)
+	hook.wait(t, 2)
+	nextIds(t, generator, 2, 10)
+	if updates := hook.updates(); updates != 2 {
+		t.Errorf("aspect no load while consuming the preloaded segment, but get %d updates", updates)
+	}
+	nextIds(t, generator, 11, 20)
+}
+
+func TestSegmentWaitForPreload(t *testing.T) {
+	hook := newSegmentHook(2)
+	_, generator := newSegmentGenerator(t, hook, 10)
+	nextIds(t, generator, 1, 1)
+	<-hook.entered
+	nextIds(t, generator, 2, 10)
+
+	/** 两个号段都已用完且预取未完成，等待预取而不是再次加载 **/
+	done := make(chan int64, 1)
+	go func() {
+		id, _ := generator.NextId()
+		done <- id
+	}()
+	select {
+	case id := <-done:
+		t.Fatalf("aspect waiting for preload, but get %d", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(hook.release)
+	if id := <-done; id != 11 {
+		t.Errorf("aspect id 11 from preloaded segment, but get %d", id)
+	}
+	hook.wait(t, 2)
+	if updates := hook.updates(); updates > 3 {
+		t.Errorf("aspect no synchronous load, but get %d updates", updates)
+	}
+}
+
+func TestSegmentSyncFallback(t *testing.T) {
+	hook := newSegmentHook(2)
+	h, generator := newSegmentGenerator(t, hook, 10)
+	nextIds(t, generator, 1, 1)
+	<-hook.entered
+	h.Expect(0, `^update id_segment`, damtest.Expectation{Err: errors.New("connection reset")})
+	close(hook.release)
+	hook.wait(t, 2)
+
+	/** 预取失败，号段用完后同步加载 **/
+	nextIds(t, generator, 2, 11)
+	if updates := hook.updates(); updates < 3 {
+		t.Errorf("aspect synchronous load, but get %d updates", updates)
+	}
+}
+
+func TestSegmentConcurrent(t *testing.T) {
+	_, generator := newSegmentGenerator(t, newSegmentHook(0), 5)
+	const workers, perWorker = 8, 50
+	var mutex sync.Mutex
+	seen := make(map[int64]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				id, err := generator.NextId()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mutex.Lock()
+				seen[id] = true
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != workers*perWorker {
+		t.Errorf("aspect %d unique ids, but get %d", workers*perWorker, len(seen))
+	}
+}
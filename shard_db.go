@@ -0,0 +1,379 @@
+package dam
+
+/**
+ * 分库db对象
+ * 包装 *sqlx.DB，GetDbByUserName/GetAllDbs 返回此对象
+ * 查询、预处理与事务方法在此统一经过 do 执行，以便记录指标、回调钩子并按 ClassifyError 包装错误，
+ * Begin/Prepare 系列返回的 ShardTx/ShardStmt 见 shard_tx.go
+ * 配置 StmtCacheSize 后 Exec/Query/Select/Get 等使用缓存的预处理语句
+ * 未包装的方法(如 PrepareNamed、Conn)与 this.DB 直接访问 *sqlx.DB，不经过钩子与指标
+ */
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	operationExec       = "exec"
+	operationQuery      = "query"
+	operationQueryRow   = "query_row"
+	operationSelect     = "select"
+	operationGet        = "get"
+	operationNamedExec  = "named_exec"
+	operationNamedQuery = "named_query"
+	operationPrepare    = "prepare"
+	operationBegin      = "begin"
+	operationCommit     = "commit"
+	operationRollback   = "rollback"
+)
+
+type ShardDB struct {
+	*sqlx.DB
+	shardId int
+	host    string
+	metrics *Metrics
+	hooks   []IQueryHook
+	logger  ILogger
+	/** 未开启语句缓存时为 nil **/
+	stmts *stmtCache
+	/** 未配置重试时为 nil **/
+	retryPolicy *RetryPolicy
+}
+
+func newShardDB(shardId int, host string, db *sqlx.DB, config MysqlConfig, logger ILogger) *ShardDB {
+	shardDB := &ShardDB{
+		DB:      db,
+		shardId: shardId,
+		host:    host,
+		metrics: config.Metrics,
+		hooks:   config.Hooks,
+		logger:  loggerOrDefault(logger).With("shard", shardId, "host", host),
+	}
+	if config.StmtCacheSize > 0 {
+		shardDB.stmts = newStmtCache(db, config.StmtCacheSize)
+	}
+	if config.Retry != nil {
+		policy := config.Retry.withDefaults()
+		shardDB.retryPolicy = &policy
+	}
+	return shardDB
+}
+
+/**
+ * 数据中心id
+ */
+func (this *ShardDB) ShardId() int {
+	return this.shardId
+}
+
+/**
+ * 数据库地址
+ */
+func (this *ShardDB) Host() string {
+	return this.host
+}
+
+/**
+ * 关闭缓存的预处理语句与连接池
+ */
+func (this *ShardDB) Close() error {
+	if this.stmts != nil {
+		this.stmts.close()
+	}
+	return this.DB.Close()
+}
+
+/**
+ * 执行一次数据库操作，记录耗时并回调钩子
+ */
+func (this *ShardDB) do(ctx context.Context, operation, query string, args []interface{}, fn func(ctx context.Context) error) error {
+	event := &QueryEvent{
+		ShardId:   this.shardId,
+		Operation: operation,
+		Query:     query,
+		Args:      args,
+		StartTime: time.Now(),
+	}
+	ctx = runBeforeHooks(ctx, this.hooks, event)
+	event.Err = ClassifyError(fn(ctx))
+	event.Duration = time.Since(event.StartTime)
+	this.metrics.ObserveQuery(this.shardId, operation, event.Duration, event.Err)
+	runAfterHooks(ctx, this.hooks, event)
+	return event.Err
+}
+
+/**
+ * 可重试的读操作，每次尝试都会记录指标并回调钩子
+ */
+func (this *ShardDB) doRead(ctx context.Context, operation, query string, args []interface{}, fn func(ctx context.Context) error) error {
+	return this.retry(ctx, operation, isReadRetryable, func() error {
+		return this.do(ctx, operation, query, args, fn)
+	})
+}
+
+func (this *ShardDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return this.ExecContext(context.Background(), query, args...)
+}
+
+func (this *ShardDB) ExecContext(ctx context.Context, query string, args ...interface{}) (result sql.Result, err error) {
+	err = this.do(ctx, operationExec, query, args, func(ctx context.Context) error {
+		if this.stmts != nil {
+			return this.stmts.run(ctx, query, func(stmt *sqlx.Stmt) error {
+				result, err = stmt.ExecContext(ctx, args...)
+				return err
+			})
+		}
+		result, err = this.DB.ExecContext(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
+
+func (this *ShardDB) MustExec(query string, args ...interface{}) sql.Result {
+	return this.MustExecContext(context.Background(), query, args...)
+}
+
+func (this *ShardDB) MustExecContext(ctx context.Context, query string, args ...interface{}) sql.Result {
+	result, err := this.ExecContext(ctx, query, args...)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func (this *ShardDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return this.QueryContext(context.Background(), query, args...)
+}
+
+func (this *ShardDB) QueryContext(ctx context.Context, query string, args ...interface{}) (rows *sql.Rows, err error) {
+	err = this.doRead(ctx, operationQuery, query, args, func(ctx context.Context) error {
+		if this.stmts != nil {
+			return this.stmts.run(ctx, query, func(stmt *sqlx.Stmt) error {
+				rows, err = stmt.QueryContext(ctx, args...)
+				return err
+			})
+		}
+		rows, err = this.DB.QueryContext(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
+
+func (this *ShardDB) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+	return this.QueryxContext(context.Background(), query, args...)
+}
+
+func (this *ShardDB) QueryxContext(ctx context.Context, query string, args ...interface{}) (rows *sqlx.Rows, err error) {
+	err = this.doRead(ctx, operationQuery, query, args, func(ctx context.Context) error {
+		if this.stmts != nil {
+			return this.stmts.run(ctx, query, func(stmt *sqlx.Stmt) error {
+				rows, err = stmt.QueryxContext(ctx, args...)
+				return err
+			})
+		}
+		rows, err = this.DB.QueryxContext(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
+
+func (this *ShardDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return this.QueryRowContext(context.Background(), query, args...)
+}
+
+func (this *ShardDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) (row *sql.Row) {
+	_ = this.doRead(ctx, operationQueryRow, query, args, func(ctx context.Context) error {
+		if this.stmts != nil {
+			return this.stmts.run(ctx, query, func(stmt *sqlx.Stmt) error {
+				row = stmt.QueryRowContext(ctx, args...)
+				return row.Err()
+			})
+		}
+		row = this.DB.QueryRowContext(ctx, query, args...)
+		return row.Err()
+	})
+	return row
+}
+
+func (this *ShardDB) QueryRowx(query string, args ...interface{}) *sqlx.Row {
+	return this.QueryRowxContext(context.Background(), query, args...)
+}
+
+func (this *ShardDB) QueryRowxContext(ctx context.Context, query string, args ...interface{}) (row *sqlx.Row) {
+	_ = this.doRead(ctx, operationQueryRow, query, args, func(ctx context.Context) error {
+		if this.stmts != nil {
+			return this.stmts.run(ctx, query, func(stmt *sqlx.Stmt) error {
+				row = stmt.QueryRowxContext(ctx, args...)
+				return row.Err()
+			})
+		}
+		row = this.DB.QueryRowxContext(ctx, query, args...)
+		return row.Err()
+	})
+	return row
+}
+
+func (this *ShardDB) Select(dest interface{}, query string, args ...interface{}) error {
+	return this.SelectContext(context.Background(), dest, query, args...)
+}
+
+func (this *ShardDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return this.doRead(ctx, operationSelect, query, args, func(ctx context.Context) error {
+		if this.stmts != nil {
+			return this.stmts.run(ctx, query, func(stmt *sqlx.Stmt) error {
+				return stmt.SelectContext(ctx, dest, args...)
+			})
+		}
+		return this.DB.SelectContext(ctx, dest, query, args...)
+	})
+}
+
+func (this *ShardDB) Get(dest interface{}, query string, args ...interface{}) error {
+	return this.GetContext(context.Background(), dest, query, args...)
+}
+
+func (this *ShardDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return this.doRead(ctx, operationGet, query, args, func(ctx context.Context) error {
+		if this.stmts != nil {
+			return this.stmts.run(ctx, query, func(stmt *sqlx.Stmt) error {
+				return stmt.GetContext(ctx, dest, args...)
+			})
+		}
+		return this.DB.GetContext(ctx, dest, query, args...)
+	})
+}
+
+func (this *ShardDB) NamedExec(query string, arg interface{}) (sql.Result, error) {
+	return this.NamedExecContext(context.Background(), query, arg)
+}
+
+func (this *ShardDB) NamedExecContext(ctx context.Context, query string, arg interface{}) (result sql.Result, err error) {
+	err = this.do(ctx, operationNamedExec, query, nil, func(ctx context.Context) error {
+		result, err = this.DB.NamedExecContext(ctx, query, arg)
+		return err
+	})
+	return result, err
+}
+
+func (this *ShardDB) NamedQuery(query string, arg interface{}) (*sqlx.Rows, error) {
+	return this.NamedQueryContext(context.Background(), query, arg)
+}
+
+func (this *ShardDB) NamedQueryContext(ctx context.Context, query string, arg interface{}) (rows *sqlx.Rows, err error) {
+	err = this.do(ctx, operationNamedQuery, query, nil, func(ctx context.Context) error {
+		rows, err = this.DB.NamedQueryContext(ctx, query, arg)
+		return err
+	})
+	return rows, err
+}
+
+/**
+ * 预处理语句，不经过语句缓存，由调用方关闭
+ */
+func (this *ShardDB) Prepare(query string) (*ShardStmt, error) {
+	return this.PreparexContext(context.Background(), query)
+}
+
+func (this *ShardDB) PrepareContext(ctx context.Context, query string) (*ShardStmt, error) {
+	return this.PreparexContext(ctx, query)
+}
+
+func (this *ShardDB) Preparex(query string) (*ShardStmt, error) {
+	return this.PreparexContext(context.Background(), query)
+}
+
+func (this *ShardDB) PreparexContext(ctx context.Context, query string) (stmt *ShardStmt, err error) {
+	err = this.do(ctx, operationPrepare, query, nil, func(ctx context.Context) error {
+		prepared, err := this.DB.PreparexContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		stmt = &ShardStmt{Stmt: prepared, db: this, query: query}
+		return nil
+	})
+	return stmt, err
+}
+
+/**
+ * 开启事务，ctx 同时用于 commit/rollback 的钩子
+ */
+func (this *ShardDB) Begin() (*ShardTx, error) {
+	return this.BeginTxx(context.Background(), nil)
+}
+
+func (this *ShardDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*ShardTx, error) {
+	return this.BeginTxx(ctx, opts)
+}
+
+func (this *ShardDB) Beginx() (*ShardTx, error) {
+	return this.BeginTxx(context.Background(), nil)
+}
+
+func (this *ShardDB) BeginTxx(ctx context.Context, opts *sql.TxOptions) (tx *ShardTx, err error) {
+	err = this.do(ctx, operationBegin, operationBegin, nil, func(hookCtx context.Context) error {
+		begun, err := this.DB.BeginTxx(hookCtx, opts)
+		if err != nil {
+			return err
+		}
+		tx = &ShardTx{Tx: begun, db: this, ctx: ctx}
+		return nil
+	})
+	return tx, err
+}
+
+func (this *ShardDB) MustBegin() *ShardTx {
+	return this.MustBeginTx(context.Background(), nil)
+}
+
+func (this *ShardDB) MustBeginTx(ctx context.Context, opts *sql.TxOptions) *ShardTx {
+	tx, err := this.BeginTxx(ctx, opts)
+	if err != nil {
+		panic(err)
+	}
+	return tx
+}
+
+/**
+ * 连接池状态指标
+ */
+func (this *ShardDB) statsSamples() []metricSample {
+	stats := this.Stats()
+	labels := []string{"shard", strconv.Itoa(this.shardId)}
+	gauge := func(name, help string, value float64) metricSample {
+		return metricSample{name: name, help: help, typ: metricTypeGauge, labels: labels, value: value}
+	}
+	counter := func(name, help string, value float64) metricSample {
+		return metricSample{name: name, help: help, typ: metricTypeCounter, labels: labels, value: value}
+	}
+	samples := []metricSample{
+		gauge("godam_mysql_max_open_connections", "Maximum number of open connections to the shard.", float64(stats.MaxOpenConnections)),
+		gauge("godam_mysql_open_connections", "Established connections to the shard, in use and idle.", float64(stats.OpenConnections)),
+		gauge("godam_mysql_in_use_connections", "Connections currently in use.", float64(stats.InUse)),
+		gauge("godam_mysql_idle_connections", "Idle connections.", float64(stats.Idle)),
+		counter("godam_mysql_wait_count_total", "Connections waited for.", float64(stats.WaitCount)),
+		counter("godam_mysql_wait_duration_seconds_total", "Time blocked waiting for a new connection.", stats.WaitDuration.Seconds()),
+		counter("godam_mysql_max_idle_closed_total", "Connections closed due to SetMaxIdleConns.", float64(stats.MaxIdleClosed)),
+		counter("godam_mysql_max_lifetime_closed_total", "Connections closed due to SetConnMaxLifetime.", float64(stats.MaxLifetimeClosed)),
+	}
+	if this.stmts != nil {
+		samples = append(samples, this.stmts.samples(this.shardId)...)
+	}
+	return samples
+}
+
+/**
+ * 按数据中心id排序，GetAllDbs 的顺序不固定
+ */
+func sortShards(dbs []*ShardDB) []*ShardDB {
+	sorted := append([]*ShardDB(nil), dbs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].shardId < sorted[j].shardId
+	})
+	return sorted
+}
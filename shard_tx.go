@@ -0,0 +1,312 @@
+package dam
+
+/**
+ * 分库事务与预处理语句
+ * ShardDB 的 Begin/Prepare 系列返回 ShardTx/ShardStmt，其中执行的语句与 begin/commit/rollback
+ * 同样经过 do，记录指标、回调钩子并按 ClassifyError 包装错误
+ * 事务中的语句不自动重试，死锁重试见 Transaction
+ */
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type ShardTx struct {
+	*sqlx.Tx
+	db *ShardDB
+	/** Begin 时的 context，commit/rollback 的钩子沿用它以串联链路 **/
+	ctx context.Context
+	/** 已提交或回滚 **/
+	done int32
+}
+
+/**
+ * 重复的 Commit/Rollback 直接返回 sql.ErrTxDone，不记录指标，便于 defer tx.Rollback()
+ */
+func (this *ShardTx) Commit() error {
+	if !atomic.CompareAndSwapInt32(&this.done, 0, 1) {
+		return sql.ErrTxDone
+	}
+	return this.db.do(this.ctx, operationCommit, operationCommit, nil, func(ctx context.Context) error {
+		return this.Tx.Commit()
+	})
+}
+
+func (this *ShardTx) Rollback() error {
+	if !atomic.CompareAndSwapInt32(&this.done, 0, 1) {
+		return sql.ErrTxDone
+	}
+	return this.db.do(this.ctx, operationRollback, operationRollback, nil, func(ctx context.Context) error {
+		return this.Tx.Rollback()
+	})
+}
+
+func (this *ShardTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return this.ExecContext(context.Background(), query, args...)
+}
+
+func (this *ShardTx) ExecContext(ctx context.Context, query string, args ...interface{}) (result sql.Result, err error) {
+	err = this.db.do(ctx, operationExec, query, args, func(ctx context.Context) error {
+		result, err = this.Tx.ExecContext(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
+
+func (this *ShardTx) MustExec(query string, args ...interface{}) sql.Result {
+	return this.MustExecContext(context.Background(), query, args...)
+}
+
+func (this *ShardTx) MustExecContext(ctx context.Context, query string, args ...interface{}) sql.Result {
+	result, err := this.ExecContext(ctx, query, args...)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func (this *ShardTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return this.QueryContext(context.Background(), query, args...)
+}
+
+func (this *ShardTx) QueryContext(ctx context.Context, query string, args ...interface{}) (rows *sql.Rows, err error) {
+	err = this.db.do(ctx, operationQuery, query, args, func(ctx context.Context) error {
+		rows, err = this.Tx.QueryContext(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
+
+func (this *ShardTx) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+	return this.QueryxContext(context.Background(), query, args...)
+}
+
+func (this *ShardTx) QueryxContext(ctx context.Context, query string, args ...interface{}) (rows *sqlx.Rows, err error) {
+	err = this.db.do(ctx, operationQuery, query, args, func(ctx context.Context) error {
+		rows, err = this.Tx.QueryxContext(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
+
+func (this *ShardTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return this.QueryRowContext(context.Background(), query, args...)
+}
+
+func (this *ShardTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) (row *sql.Row) {
+	_ = this.db.do(ctx, operationQueryRow, query, args, func(ctx context.Context) error {
+		row = this.Tx.QueryRowContext(ctx, query, args...)
+		return row.Err()
+	})
+	return row
+}
+
+func (this *ShardTx) QueryRowx(query string, args ...interface{}) *sqlx.Row {
+	return this.QueryRowxContext(context.Background(), query, args...)
+}
+
+func (this *ShardTx) QueryRowxContext(ctx context.Context, query string, args ...interface{}) (row *sqlx.Row) {
+	_ = this.db.do(ctx, operationQueryRow, query, args, func(ctx context.Context) error {
+		row = this.Tx.QueryRowxContext(ctx, query, args...)
+		return row.Err()
+	})
+	return row
+}
+
+func (this *ShardTx) Select(dest interface{}, query string, args ...interface{}) error {
+	return this.SelectContext(context.Background(), dest, query, args...)
+}
+
+func (this *ShardTx) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return this.db.do(ctx, operationSelect, query, args, func(ctx context.Context) error {
+		return this.Tx.SelectContext(ctx, dest, query, args...)
+	})
+}
+
+func (this *ShardTx) Get(dest interface{}, query string, args ...interface{}) error {
+	return this.GetContext(context.Background(), dest, query, args...)
+}
+
+func (this *ShardTx) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return this.db.do(ctx, operationGet, query, args, func(ctx context.Context) error {
+		return this.Tx.GetContext(ctx, dest, query, args...)
+	})
+}
+
+func (this *ShardTx) NamedExec(query string, arg interface{}) (sql.Result, error) {
+	return this.NamedExecContext(context.Background(), query, arg)
+}
+
+func (this *ShardTx) NamedExecContext(ctx context.Context, query string, arg interface{}) (result sql.Result, err error) {
+	err = this.db.do(ctx, operationNamedExec, query, nil, func(ctx context.Context) error {
+		result, err = this.Tx.NamedExecContext(ctx, query, arg)
+		return err
+	})
+	return result, err
+}
+
+func (this *ShardTx) NamedQuery(query string, arg interface{}) (*sqlx.Rows, error) {
+	return this.NamedQueryContext(context.Background(), query, arg)
+}
+
+func (this *ShardTx) NamedQueryContext(ctx context.Context, query string, arg interface{}) (rows *sqlx.Rows, err error) {
+	err = this.db.do(ctx, operationNamedQuery, query, nil, func(ctx context.Context) error {
+		rows, err = sqlx.NamedQueryContext(ctx, this.Tx, query, arg)
+		return err
+	})
+	return rows, err
+}
+
+func (this *ShardTx) Prepare(query string) (*ShardStmt, error) {
+	return this.PreparexContext(context.Background(), query)
+}
+
+func (this *ShardTx) PrepareContext(ctx context.Context, query string) (*ShardStmt, error) {
+	return this.PreparexContext(ctx, query)
+}
+
+func (this *ShardTx) Preparex(query string) (*ShardStmt, error) {
+	return this.PreparexContext(context.Background(), query)
+}
+
+func (this *ShardTx) PreparexContext(ctx context.Context, query string) (stmt *ShardStmt, err error) {
+	err = this.db.do(ctx, operationPrepare, query, nil, func(ctx context.Context) error {
+		prepared, err := this.Tx.PreparexContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		stmt = &ShardStmt{Stmt: prepared, db: this.db, query: query, inTx: true}
+		return nil
+	})
+	return stmt, err
+}
+
+/**
+ * 将事务外预处理的语句绑定到本事务，stmt 可为 *ShardStmt、*sqlx.Stmt 或 *sql.Stmt
+ */
+func (this *ShardTx) Stmtx(stmt interface{}) *ShardStmt {
+	return this.StmtxContext(context.Background(), stmt)
+}
+
+func (this *ShardTx) StmtxContext(ctx context.Context, stmt interface{}) *ShardStmt {
+	query := ""
+	if shardStmt, ok := stmt.(*ShardStmt); ok {
+		stmt, query = shardStmt.Stmt, shardStmt.query
+	}
+	return &ShardStmt{Stmt: this.Tx.StmtxContext(ctx, stmt), db: this.db, query: query, inTx: true}
+}
+
+type ShardStmt struct {
+	*sqlx.Stmt
+	db    *ShardDB
+	query string
+	/** 事务中的语句不重试 **/
+	inTx bool
+}
+
+func (this *ShardStmt) do(ctx context.Context, operation string, args []interface{}, fn func(ctx context.Context) error) error {
+	return this.db.do(ctx, operation, this.query, args, fn)
+}
+
+func (this *ShardStmt) doRead(ctx context.Context, operation string, args []interface{}, fn func(ctx context.Context) error) error {
+	if this.inTx {
+		return this.do(ctx, operation, args, fn)
+	}
+	return this.db.doRead(ctx, operation, this.query, args, fn)
+}
+
+func (this *ShardStmt) Exec(args ...interface{}) (sql.Result, error) {
+	return this.ExecContext(context.Background(), args...)
+}
+
+func (this *ShardStmt) ExecContext(ctx context.Context, args ...interface{}) (result sql.Result, err error) {
+	err = this.do(ctx, operationExec, args, func(ctx context.Context) error {
+		result, err = this.Stmt.ExecContext(ctx, args...)
+		return err
+	})
+	return result, err
+}
+
+func (this *ShardStmt) MustExec(args ...interface{}) sql.Result {
+	return this.MustExecContext(context.Background(), args...)
+}
+
+func (this *ShardStmt) MustExecContext(ctx context.Context, args ...interface{}) sql.Result {
+	result, err := this.ExecContext(ctx, args...)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func (this *ShardStmt) Query(args ...interface{}) (*sql.Rows, error) {
+	return this.QueryContext(context.Background(), args...)
+}
+
+func (this *ShardStmt) QueryContext(ctx context.Context, args ...interface{}) (rows *sql.Rows, err error) {
+	err = this.doRead(ctx, operationQuery, args, func(ctx context.Context) error {
+		rows, err = this.Stmt.QueryContext(ctx, args...)
+		return err
+	})
+	return rows, err
+}
+
+func (this *ShardStmt) Queryx(args ...interface{}) (*sqlx.Rows, error) {
+	return this.QueryxContext(context.Background(), args...)
+}
+
+func (this *ShardStmt) QueryxContext(ctx context.Context, args ...interface{}) (rows *sqlx.Rows, err error) {
+	err = this.doRead(ctx, operationQuery, args, func(ctx context.Context) error {
+		rows, err = this.Stmt.QueryxContext(ctx, args...)
+		return err
+	})
+	return rows, err
+}
+
+func (this *ShardStmt) QueryRow(args ...interface{}) *sql.Row {
+	return this.QueryRowContext(context.Background(), args...)
+}
+
+func (this *ShardStmt) QueryRowContext(ctx context.Context, args ...interface{}) (row *sql.Row) {
+	_ = this.doRead(ctx, operationQueryRow, args, func(ctx context.Context) error {
+		row = this.Stmt.QueryRowContext(ctx, args...)
+		return row.Err()
+	})
+	return row
+}
+
+func (this *ShardStmt) QueryRowx(args ...interface{}) *sqlx.Row {
+	return this.QueryRowxContext(context.Background(), args...)
+}
+
+func (this *ShardStmt) QueryRowxContext(ctx context.Context, args ...interface{}) (row *sqlx.Row) {
+	_ = this.doRead(ctx, operationQueryRow, args, func(ctx context.Context) error {
+		row = this.Stmt.QueryRowxContext(ctx, args...)
+		return row.Err()
+	})
+	return row
+}
+
+func (this *ShardStmt) Select(dest interface{}, args ...interface{}) error {
+	return this.SelectContext(context.Background(), dest, args...)
+}
+
+func (this *ShardStmt) SelectContext(ctx context.Context, dest interface{}, args ...interface{}) error {
+	return this.doRead(ctx, operationSelect, args, func(ctx context.Context) error {
+		return this.Stmt.SelectContext(ctx, dest, args...)
+	})
+}
+
+func (this *ShardStmt) Get(dest interface{}, args ...interface{}) error {
+	return this.GetContext(context.Background(), dest, args...)
+}
+
+func (this *ShardStmt) GetContext(ctx context.Context, dest interface{}, args ...interface{}) error {
+	return this.doRead(ctx, operationGet, args, func(ctx context.Context) error {
+		return this.Stmt.GetContext(ctx, dest, args...)
+	})
+}
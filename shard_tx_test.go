@@ -0,0 +1,100 @@
+package dam_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	dam "github.com/seanbit/godam"
+	"github.com/seanbit/godam/damtest"
+)
+
+type operationHook struct {
+	mutex      sync.Mutex
+	operations []string
+}
+
+func (this *operationHook) BeforeQuery(ctx context.Context, event *dam.QueryEvent) context.Context {
+	return ctx
+}
+
+func (this *operationHook) AfterQuery(ctx context.Context, event *dam.QueryEvent) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.operations = append(this.operations, event.Operation)
+}
+
+func (this *operationHook) take() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	operations := this.operations
+	this.operations = nil
+	return operations
+}
+
+func TestShardTxHooks(t *testing.T) {
+	hook := &operationHook{}
+	h := damtest.NewWithConfig(1, dam.MysqlConfig{Hooks: []dam.IQueryHook{hook}})
+	defer h.Close()
+	if err := h.LoadSchema("create table counter (id int not null auto_increment primary key, value int not null default 0)"); err != nil {
+		t.Fatal(err)
+	}
+	db := h.Manager.GetAllDbs()[0]
+	ctx := context.Background()
+
+	db.MustExec("insert into counter(value) values (?)", 1)
+	var value int
+	if err := db.QueryRowContext(ctx, "select value from counter where id=?", 1).Scan(&value); err != nil || value != 1 {
+		t.Errorf("aspect value 1, but get %d, %v", value, err)
+	}
+	stmt, err := db.PreparexContext(ctx, "select value from counter where id=?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stmt.GetContext(ctx, &value, 1); err != nil || value != 1 {
+		t.Errorf("aspect value 1, but get %d, %v", value, err)
+	}
+	if operations := hook.take(); !reflect.DeepEqual(operations, []string{"exec", "query_row", "prepare", "get"}) {
+		t.Errorf("aspect db operations hooked, but get %v", operations)
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.MustExecContext(ctx, "update counter set value=value+1 where id=?", 1)
+	if err := tx.Stmtx(stmt).GetContext(ctx, &value, 1); err != nil || value != 2 {
+		t.Errorf("aspect value 2 in tx, but get %d, %v", value, err)
+	}
+	if _, err := tx.Exec("select * from missing"); err == nil {
+		t.Error("aspect missing table error in tx")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err == nil {
+		t.Error("aspect rollback after commit to fail")
+	}
+	if operations := hook.take(); !reflect.DeepEqual(operations, []string{"begin", "exec", "get", "exec", "commit"}) {
+		t.Errorf("aspect tx operations hooked, but get %v", operations)
+	}
+
+	failed := errors.New("failed")
+	err = db.Transaction(ctx, func(tx *dam.ShardTx) error {
+		if _, err := tx.ExecContext(ctx, "update counter set value=0"); err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed {
+		t.Errorf("aspect %v, but get %v", failed, err)
+	}
+	if operations := hook.take(); !reflect.DeepEqual(operations, []string{"begin", "exec", "rollback"}) {
+		t.Errorf("aspect transaction operations hooked, but get %v", operations)
+	}
+	if err := db.Get(&value, "select value from counter where id=?", 1); err != nil || value != 2 {
+		t.Errorf("aspect rollback keeps value 2, but get %d, %v", value, err)
+	}
+}
@@ -0,0 +1,167 @@
+package dam
+
+/**
+ * 慢查询日志
+ * 作为 IQueryHook 挂在 ShardDB 上：MysqlConfig.Hooks = []IQueryHook{NewSlowQueryLog(200 * time.Millisecond, logger)}
+ * 超过阈值的语句按 分库 + 指纹 聚合次数、总耗时、最大耗时与p99，可输出 top N 报告
+ */
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+/** 每个指纹保留最近的耗时样本数，用于计算p99 **/
+const slowQuerySampleSize = 1024
+
+type SlowQueryLog struct {
+	threshold time.Duration
+	logger    ILogger
+	mu        sync.Mutex
+	entries   map[slowQueryKey]*slowQueryEntry
+}
+
+type slowQueryKey struct {
+	shardId     int
+	fingerprint string
+}
+
+type slowQueryEntry struct {
+	example string
+	count   int64
+	total   time.Duration
+	max     time.Duration
+	samples []time.Duration
+	next    int
+}
+
+type SlowQueryStat struct {
+	ShardId     int
+	Fingerprint string
+	Example     string
+	Count       int64
+	Total       time.Duration
+	Max         time.Duration
+	P99         time.Duration
+}
+
+/**
+ * logger 为空时使用默认logger
+ */
+func NewSlowQueryLog(threshold time.Duration, logger ILogger) *SlowQueryLog {
+	return &SlowQueryLog{
+		threshold: threshold,
+		logger:    loggerOrDefault(logger),
+		entries:   make(map[slowQueryKey]*slowQueryEntry),
+	}
+}
+
+func (this *SlowQueryLog) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+func (this *SlowQueryLog) AfterQuery(ctx context.Context, event *QueryEvent) {
+	if event.Duration < this.threshold {
+		return
+	}
+	this.logger.Warn("slow query", "shard", event.ShardId, "duration", event.Duration, "fingerprint", event.Fingerprint())
+	this.record(event.ShardId, event.Fingerprint(), event.Query, event.Duration)
+}
+
+func (this *SlowQueryLog) record(shardId int, fingerprint, query string, duration time.Duration) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	key := slowQueryKey{shardId: shardId, fingerprint: fingerprint}
+	entry, ok := this.entries[key]
+	if !ok {
+		entry = &slowQueryEntry{example: query}
+		this.entries[key] = entry
+	}
+	entry.count++
+	entry.total += duration
+	if duration > entry.max {
+		entry.max = duration
+	}
+	if len(entry.samples) < slowQuerySampleSize {
+		entry.samples = append(entry.samples, duration)
+	} else {
+		entry.samples[entry.next] = duration
+		entry.next = (entry.next + 1) % slowQuerySampleSize
+	}
+}
+
+/**
+ * 按总耗时倒序返回前n条，n<=0 返回全部
+ */
+func (this *SlowQueryLog) Top(n int) []SlowQueryStat {
+	this.mu.Lock()
+	stats := make([]SlowQueryStat, 0, len(this.entries))
+	for key, entry := range this.entries {
+		stats = append(stats, SlowQueryStat{
+			ShardId:     key.shardId,
+			Fingerprint: key.fingerprint,
+			Example:     entry.example,
+			Count:       entry.count,
+			Total:       entry.total,
+			Max:         entry.max,
+			P99:         percentile(entry.samples, 0.99),
+		})
+	}
+	this.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Total != stats[j].Total {
+			return stats[i].Total > stats[j].Total
+		}
+		if stats[i].ShardId != stats[j].ShardId {
+			return stats[i].ShardId < stats[j].ShardId
+		}
+		return stats[i].Fingerprint < stats[j].Fingerprint
+	})
+	if n > 0 && n < len(stats) {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+/**
+ * 输出top n报告
+ */
+func (this *SlowQueryLog) WriteReport(w io.Writer, n int) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SHARD\tCOUNT\tTOTAL\tMAX\tP99\tFINGERPRINT")
+	for _, stat := range this.Top(n) {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\n", stat.ShardId, stat.Count, stat.Total, stat.Max, stat.P99, stat.Fingerprint)
+	}
+	return tw.Flush()
+}
+
+/**
+ * 清空统计
+ */
+func (this *SlowQueryLog) Reset() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.entries = make(map[slowQueryKey]*slowQueryEntry)
+}
+
+func percentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	index := int(float64(len(sorted))*p+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
@@ -0,0 +1,64 @@
+package dam
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowQueryLog(t *testing.T) {
+	slowLog := NewSlowQueryLog(100*time.Millisecond, NopLogger())
+	events := []*QueryEvent{
+		{ShardId: 0, Query: "select * from user where user_id=1", Duration: 50 * time.Millisecond},
+		{ShardId: 0, Query: "select * from user where user_id=2", Duration: 150 * time.Millisecond},
+		{ShardId: 0, Query: "select * from user where user_id=3", Duration: 250 * time.Millisecond},
+		{ShardId: 1, Query: "select * from user where user_id=4", Duration: 120 * time.Millisecond},
+		{ShardId: 1, Query: "update user set enabled=0 where user_id=4", Duration: time.Second},
+	}
+	for _, event := range events {
+		slowLog.AfterQuery(slowLog.BeforeQuery(context.Background(), event), event)
+	}
+
+	stats := slowLog.Top(0)
+	if len(stats) != 3 {
+		t.Fatalf("aspect 3 fingerprints, but get %d", len(stats))
+	}
+	if stats[0].Fingerprint != "update user set enabled=? where user_id=?" {
+		t.Errorf("aspect slowest fingerprint first, but get %q", stats[0].Fingerprint)
+	}
+	if stat := stats[1]; stat.ShardId != 0 || stat.Count != 2 || stat.Total != 400*time.Millisecond ||
+		stat.Max != 250*time.Millisecond || stat.P99 != 250*time.Millisecond {
+		t.Errorf("unexpected stat %+v", stat)
+	}
+	if top := slowLog.Top(1); len(top) != 1 {
+		t.Errorf("aspect 1 stat, but get %d", len(top))
+	}
+
+	var buf bytes.Buffer
+	if err := slowLog.WriteReport(&buf, 2); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 3 {
+		t.Errorf("aspect header and 2 rows, but get:\n%s", buf.String())
+	}
+
+	slowLog.Reset()
+	if stats := slowLog.Top(0); len(stats) != 0 {
+		t.Errorf("aspect empty stats after reset, but get %d", len(stats))
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var samples []time.Duration
+	for i := 1; i <= 100; i++ {
+		samples = append(samples, time.Duration(i))
+	}
+	if p := percentile(samples, 0.99); p != 99 {
+		t.Errorf("aspect p99 is 99, but get %d", p)
+	}
+	if p := percentile(nil, 0.99); p != 0 {
+		t.Errorf("aspect p99 of empty is 0, but get %d", p)
+	}
+}
@@ -0,0 +1,183 @@
+package dam
+
+/**
+ * 雪花id生成
+ * 位布局与 gokit foundation.Worker 一致(见 snowid.go)，已生成的id可继续解析与比较；
+ * 检测到时钟回拨时按策略等待时钟追上或直接返回 ClockBackwardsError
+ */
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type ClockBackwardPolicy string
+
+const (
+	/** 回拨不超过最大等待时长时等待，否则返回错误 **/
+	ClockBackwardWait ClockBackwardPolicy = "wait"
+	/** 立即返回错误 **/
+	ClockBackwardFail ClockBackwardPolicy = "fail"
+
+	defaultMaxClockBackwardWait = 500 * time.Millisecond
+)
+
+var ErrClockMovedBackwards = errors.New("clock moved backwards")
+
+type ClockBackwardsError struct {
+	/** 当前时间落后于上次生成id的时长 **/
+	Offset time.Duration
+}
+
+func (this *ClockBackwardsError) Error() string {
+	return fmt.Sprintf("clock moved backwards by %s, refusing to generate id", this.Offset)
+}
+
+func (this *ClockBackwardsError) Is(target error) bool {
+	return target == ErrClockMovedBackwards
+}
+
+var timeSleep = time.Sleep
+
+type snowflakeIdGenerator struct {
+	mutex   sync.Mutex
+	policy  ClockBackwardPolicy
+	maxWait time.Duration
+	/** worker id 未分配(等待租约)时为 false **/
+	ready    bool
+	workerId int64
+	/** 使用租约时不为空 **/
+	lease    *workerLease
+	lastMs   int64
+	sequence int64
+}
+
+func newSnowflakeIdGenerator(policy ClockBackwardPolicy, maxWait time.Duration) *snowflakeIdGenerator {
+	if policy == "" {
+		policy = ClockBackwardWait
+	}
+	if maxWait <= 0 {
+		maxWait = defaultMaxClockBackwardWait
+	}
+	return &snowflakeIdGenerator{policy: policy, maxWait: maxWait}
+}
+
+func (this *snowflakeIdGenerator) setWorker(workerId int64, lease *workerLease) error {
+	if workerId < 0 || workerId > snowIdWorkerMax {
+		return fmt.Errorf("worker id must be between 0 and %d, get %d", snowIdWorkerMax, workerId)
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.workerId = workerId
+	this.lease = lease
+	this.ready = true
+	return nil
+}
+
+/**
+ * 取出租约并停止生成id，直到重新 setWorker；未使用租约时返回 nil 且不影响生成
+ */
+func (this *snowflakeIdGenerator) takeLease() *workerLease {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	lease := this.lease
+	if lease != nil {
+		this.lease = nil
+		this.ready = false
+	}
+	return lease
+}
+
+func (this *snowflakeIdGenerator) NextId() (int64, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if err := this.checkWorker(); err != nil {
+		return 0, err
+	}
+	return this.next()
+}
+
+func (this *snowflakeIdGenerator) NextIdString() (string, error) {
+	id, err := this.NextId()
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+/**
+ * 批量生成，只加锁一次，用于批量插入
+ */
+func (this *snowflakeIdGenerator) NextIds(n int) ([]int64, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if err := this.checkWorker(); err != nil {
+		return nil, err
+	}
+	ids := make([]int64, n)
+	for i := range ids {
+		id, err := this.next()
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func (this *snowflakeIdGenerator) checkWorker() error {
+	if !this.ready || (this.lease != nil && !this.lease.valid()) {
+		return ErrWorkerLeaseLost
+	}
+	return nil
+}
+
+func (this *snowflakeIdGenerator) next() (int64, error) {
+	ms, err := this.timestamp()
+	if err != nil {
+		return 0, err
+	}
+	var sequence int64
+	if ms == this.lastMs {
+		sequence = (this.sequence + 1) & snowIdSequenceMax
+		/** 当前毫秒序列号用尽，等待下一毫秒；等待期间的回拨同样按策略处理，失败时不修改状态 **/
+		for sequence == 0 && ms == this.lastMs {
+			timeSleep(time.Millisecond - time.Duration(timeNow().UnixNano()%int64(time.Millisecond)))
+			if ms, err = this.timestamp(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	this.sequence = sequence
+	this.lastMs = ms
+	return ms<<snowIdTimeShift | this.workerId<<snowIdWorkerShift | this.sequence, nil
+}
+
+/**
+ * 当前时间戳，发生回拨时按策略等待或返回错误
+ */
+func (this *snowflakeIdGenerator) timestamp() (int64, error) {
+	ms := snowflakeNow()
+	if ms >= this.lastMs {
+		return ms, nil
+	}
+	offset := time.Duration(this.lastMs-ms) * time.Millisecond
+	if this.policy == ClockBackwardFail || offset > this.maxWait {
+		return 0, &ClockBackwardsError{Offset: offset}
+	}
+	timeSleep(offset)
+	if ms = snowflakeNow(); ms < this.lastMs {
+		return 0, &ClockBackwardsError{Offset: time.Duration(this.lastMs-ms) * time.Millisecond}
+	}
+	return ms, nil
+}
+
+func snowflakeNow() int64 {
+	return timeNow().UnixNano()/int64(time.Millisecond) - snowIdEpoch
+}
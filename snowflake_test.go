@@ -0,0 +1,124 @@
+package dam
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+/**
+ * 固定时钟，sleep 推进时钟
+ */
+func fakeSnowflakeClock(start time.Time) (now *time.Time, restore func()) {
+	current := start
+	timeNow = func() time.Time { return current }
+	timeSleep = func(d time.Duration) { current = current.Add(d) }
+	return &current, func() {
+		timeNow = time.Now
+		timeSleep = time.Sleep
+	}
+}
+
+func newTestSnowflake(t *testing.T, policy ClockBackwardPolicy) *snowflakeIdGenerator {
+	generator := newSnowflakeIdGenerator(policy, 0)
+	if err := generator.setWorker(9, nil); err != nil {
+		t.Fatal(err)
+	}
+	return generator
+}
+
+func TestSnowflakeLayout(t *testing.T) {
+	now, restore := fakeSnowflakeClock(time.Unix(1600000000, 0))
+	defer restore()
+	generator := newTestSnowflake(t, ClockBackwardWait)
+	ids, err := generator.NextIds(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range ids {
+		info := DecodeId(id)
+		if !info.Time.Equal(*now) || info.WorkerId != 9 || info.Sequence != int64(i) {
+			t.Errorf("unexpected decode %+v", info)
+		}
+	}
+	if err := generator.setWorker(snowIdWorkerMax+1, nil); err == nil {
+		t.Error("aspect error for worker id out of range")
+	}
+}
+
+func TestSnowflakeSequenceExhausted(t *testing.T) {
+	now, restore := fakeSnowflakeClock(time.Unix(1600000000, 0))
+	defer restore()
+	start := *now
+	generator := newTestSnowflake(t, ClockBackwardWait)
+	ids, err := generator.NextIds(int(snowIdSequenceMax) + 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := DecodeId(ids[len(ids)-1])
+	if last.Sequence != 0 || !last.Time.Equal(start.Add(time.Millisecond)) {
+		t.Errorf("aspect next millisecond with sequence 0, but get %+v", last)
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Fatalf("aspect increasing ids at %d", i)
+		}
+	}
+}
+
+func TestSnowflakeClockBackwardsWhileExhausted(t *testing.T) {
+	now, restore := fakeSnowflakeClock(time.Unix(1600000000, 0))
+	defer restore()
+	start := *now
+	generator := newTestSnowflake(t, ClockBackwardWait)
+	if _, err := generator.NextIds(int(snowIdSequenceMax) + 1); err != nil {
+		t.Fatal(err)
+	}
+	/** 等待下一毫秒时时钟回拨1s，超过最大等待时长 **/
+	timeSleep = func(d time.Duration) { *now = now.Add(-time.Second) }
+	if _, err := generator.NextId(); !errors.Is(err, ErrClockMovedBackwards) {
+		t.Fatalf("aspect ErrClockMovedBackwards while waiting for next millisecond, but get %v", err)
+	}
+
+	/** 时钟恢复后序列号仍视为用尽，不重复使用已生成的序列号 **/
+	*now = start
+	timeSleep = func(d time.Duration) { *now = now.Add(d) }
+	id, err := generator.NextId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := DecodeId(id); info.Sequence != 0 || !info.Time.Equal(start.Add(time.Millisecond)) {
+		t.Errorf("aspect next millisecond with sequence 0, but get %+v", info)
+	}
+}
+
+func TestSnowflakeClockBackwards(t *testing.T) {
+	now, restore := fakeSnowflakeClock(time.Unix(1600000000, 0))
+	defer restore()
+
+	failing := newTestSnowflake(t, ClockBackwardFail)
+	first, _ := failing.NextId()
+	*now = now.Add(-10 * time.Millisecond)
+	_, err := failing.NextId()
+	var backwards *ClockBackwardsError
+	if !errors.As(err, &backwards) || !errors.Is(err, ErrClockMovedBackwards) || backwards.Offset != 10*time.Millisecond {
+		t.Fatalf("aspect ClockBackwardsError of 10ms, but get %v", err)
+	}
+
+	*now = time.Unix(1600000000, 0)
+	waiting := newTestSnowflake(t, ClockBackwardWait)
+	waiting.NextId()
+	*now = now.Add(-10 * time.Millisecond)
+	second, err := waiting.NextId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second <= first {
+		t.Errorf("aspect id after waiting greater than %d, but get %d", first, second)
+	}
+
+	*now = now.Add(-time.Second)
+	if _, err := waiting.NextId(); !errors.Is(err, ErrClockMovedBackwards) {
+		t.Errorf("aspect error when rollback exceeds max wait, but get %v", err)
+	}
+}
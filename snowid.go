@@ -0,0 +1,76 @@
+package dam
+
+/**
+ * 雪花id解析
+ * 位布局与 gokit foundation.Worker 一致：
+ *   41位毫秒时间戳(相对 epoch) | 10位 worker id | 12位序列号
+ * 可用 IdRange 把 create_time 范围查询改写为主键范围查询：
+ *   min, max := dam.IdRange(from, to)
+ *   db.Select(&users, "select * from user where user_id between ? and ?", min, max)
+ */
+
+import (
+	"time"
+)
+
+const (
+	snowIdEpoch        int64 = 1565420047000
+	snowIdSequenceBits uint8 = 12
+	snowIdWorkerBits   uint8 = 10
+	snowIdWorkerShift        = snowIdSequenceBits
+	snowIdTimeShift          = snowIdSequenceBits + snowIdWorkerBits
+	snowIdSequenceMax  int64 = -1 ^ (-1 << snowIdSequenceBits)
+	snowIdWorkerMax    int64 = -1 ^ (-1 << snowIdWorkerBits)
+)
+
+type SnowIdInfo struct {
+	Id       int64
+	Time     time.Time
+	WorkerId int64
+	Sequence int64
+}
+
+func DecodeId(id int64) SnowIdInfo {
+	return SnowIdInfo{
+		Id:       id,
+		Time:     snowIdTime(id >> snowIdTimeShift),
+		WorkerId: id >> snowIdWorkerShift & snowIdWorkerMax,
+		Sequence: id & snowIdSequenceMax,
+	}
+}
+
+/**
+ * 时刻 t 所在毫秒内可能生成的最小id
+ */
+func MinIdAt(t time.Time) int64 {
+	return snowIdTimestamp(t) << snowIdTimeShift
+}
+
+/**
+ * 时刻 t 所在毫秒内可能生成的最大id
+ */
+func MaxIdAt(t time.Time) int64 {
+	return snowIdTimestamp(t)<<snowIdTimeShift | (1<<snowIdTimeShift - 1)
+}
+
+/**
+ * [from, to] 时间范围内生成的id区间，用于 between 查询
+ */
+func IdRange(from, to time.Time) (min, max int64) {
+	return MinIdAt(from), MaxIdAt(to)
+}
+
+/**
+ * 相对 epoch 的毫秒数，早于 epoch 时为0
+ */
+func snowIdTimestamp(t time.Time) int64 {
+	ms := t.UnixNano()/int64(time.Millisecond) - snowIdEpoch
+	if ms < 0 {
+		return 0
+	}
+	return ms
+}
+
+func snowIdTime(timestamp int64) time.Time {
+	return time.Unix(0, (timestamp+snowIdEpoch)*int64(time.Millisecond))
+}
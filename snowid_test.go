@@ -0,0 +1,43 @@
+package dam
+
+import (
+	"testing"
+	"time"
+
+	"github.com/seanbit/gokit/foundation"
+)
+
+func TestDecodeId(t *testing.T) {
+	worker, err := foundation.NewWorker(37)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().Truncate(time.Millisecond)
+	id := worker.GetId()
+	after := time.Now()
+
+	info := DecodeId(id)
+	if info.Id != id || info.WorkerId != 37 || info.Sequence != 0 {
+		t.Errorf("unexpected decode %+v", info)
+	}
+	if info.Time.Before(before) || info.Time.After(after) {
+		t.Errorf("aspect time between %s and %s, but get %s", before, after, info.Time)
+	}
+	if min, max := IdRange(before, after); id < min || id > max {
+		t.Errorf("aspect id %d in [%d, %d]", id, min, max)
+	}
+}
+
+func TestIdAt(t *testing.T) {
+	at := time.Unix(0, (snowIdEpoch+1000)*int64(time.Millisecond))
+	if min := MinIdAt(at); min != 1000<<22 {
+		t.Errorf("aspect min %d, but get %d", 1000<<22, min)
+	}
+	max := MaxIdAt(at)
+	if info := DecodeId(max); !info.Time.Equal(at) || info.WorkerId != snowIdWorkerMax || info.Sequence != snowIdSequenceMax {
+		t.Errorf("unexpected max decode %+v", info)
+	}
+	if min := MinIdAt(time.Unix(0, 0)); min != 0 {
+		t.Errorf("aspect 0 before epoch, but get %d", min)
+	}
+}
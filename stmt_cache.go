@@ -0,0 +1,167 @@
+package dam
+
+/**
+ * 分库预处理语句缓存
+ * 以sql文本为key，LRU淘汰；database/sql 的 Stmt 会在新连接上自动重新prepare，
+ * 服务端丢失语句(1243)时丢弃缓存并重新prepare后重试一次
+ */
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+/** Unknown prepared statement handler **/
+const mysqlErrUnknownStmtHandler = 1243
+
+type stmtCacheEntry struct {
+	query string
+	stmt  *sqlx.Stmt
+	/** 正在使用的调用数，淘汰后归零时关闭 **/
+	refs    int
+	evicted bool
+}
+
+type stmtCache struct {
+	db       *sqlx.DB
+	capacity int
+
+	mutex     sync.Mutex
+	lru       *list.List
+	entries   map[string]*list.Element
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func newStmtCache(db *sqlx.DB, capacity int) *stmtCache {
+	return &stmtCache{
+		db:       db,
+		capacity: capacity,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+/**
+ * 使用缓存的语句执行 fn，语句在服务端失效时重新prepare并重试一次
+ */
+func (this *stmtCache) run(ctx context.Context, query string, fn func(stmt *sqlx.Stmt) error) error {
+	for attempt := 0; ; attempt++ {
+		entry, err := this.acquire(ctx, query)
+		if err != nil {
+			return err
+		}
+		err = fn(entry.stmt)
+		this.release(entry)
+		if err == nil || !isStmtLost(err) {
+			return err
+		}
+		this.invalidate(entry)
+		if attempt > 0 {
+			return err
+		}
+	}
+}
+
+func (this *stmtCache) acquire(ctx context.Context, query string) (*stmtCacheEntry, error) {
+	this.mutex.Lock()
+	if element, ok := this.entries[query]; ok {
+		this.hits++
+		this.lru.MoveToFront(element)
+		entry := element.Value.(*stmtCacheEntry)
+		entry.refs++
+		this.mutex.Unlock()
+		return entry, nil
+	}
+	this.misses++
+	this.mutex.Unlock()
+
+	stmt, err := this.db.PreparexContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if element, ok := this.entries[query]; ok {
+		/** 并发prepare了同一语句，使用已缓存的 **/
+		stmt.Close()
+		entry := element.Value.(*stmtCacheEntry)
+		entry.refs++
+		return entry, nil
+	}
+	entry := &stmtCacheEntry{query: query, stmt: stmt, refs: 1}
+	this.entries[query] = this.lru.PushFront(entry)
+	for this.lru.Len() > this.capacity {
+		this.evict(this.lru.Back())
+	}
+	return entry, nil
+}
+
+func (this *stmtCache) release(entry *stmtCacheEntry) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	entry.refs--
+	if entry.evicted && entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+/**
+ * 丢弃失效的语句，下次使用时重新prepare
+ */
+func (this *stmtCache) invalidate(entry *stmtCacheEntry) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if element, ok := this.entries[entry.query]; ok && element.Value == entry {
+		this.evict(element)
+	}
+}
+
+/**
+ * 需持有锁
+ */
+func (this *stmtCache) evict(element *list.Element) {
+	entry := this.lru.Remove(element).(*stmtCacheEntry)
+	delete(this.entries, entry.query)
+	this.evictions++
+	entry.evicted = true
+	if entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+func (this *stmtCache) close() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for this.lru.Len() > 0 {
+		this.evict(this.lru.Back())
+	}
+}
+
+func (this *stmtCache) samples(shardId int) []metricSample {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	labels := []string{"shard", strconv.Itoa(shardId)}
+	return []metricSample{
+		{name: "godam_mysql_stmt_cache_hits_total", help: "Prepared statement cache hits.", typ: metricTypeCounter, labels: labels, value: float64(this.hits)},
+		{name: "godam_mysql_stmt_cache_misses_total", help: "Prepared statement cache misses.", typ: metricTypeCounter, labels: labels, value: float64(this.misses)},
+		{name: "godam_mysql_stmt_cache_evictions_total", help: "Prepared statements evicted or invalidated.", typ: metricTypeCounter, labels: labels, value: float64(this.evictions)},
+		{name: "godam_mysql_stmt_cache_size", help: "Prepared statements currently cached.", typ: metricTypeGauge, labels: labels, value: float64(this.lru.Len())},
+	}
+}
+
+/**
+ * 服务端已不存在该语句，如 flush 或 max_prepared_stmt_count 调整后
+ */
+func isStmtLost(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrUnknownStmtHandler
+}
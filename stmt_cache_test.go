@@ -0,0 +1,139 @@
+package dam
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+/**
+ * 记录 prepare/close 次数的驱动，"lost" 语句第一次执行返回 1243
+ * 每个测试经 sql.OpenDB 使用新的实例，计数不受 -count 重复执行影响
+ */
+type countingDriver struct {
+	prepares int32
+	closes   int32
+	lost     int32
+}
+
+func (this *countingDriver) Open(name string) (driver.Conn, error) {
+	return &countingConn{driver: this}, nil
+}
+
+func (this *countingDriver) Connect(ctx context.Context) (driver.Conn, error) {
+	return this.Open("")
+}
+
+func (this *countingDriver) Driver() driver.Driver {
+	return this
+}
+
+type countingConn struct {
+	driver *countingDriver
+}
+
+func (this *countingConn) Prepare(query string) (driver.Stmt, error) {
+	atomic.AddInt32(&this.driver.prepares, 1)
+	return &countingStmt{driver: this.driver, query: query}, nil
+}
+
+func (this *countingConn) Close() error              { return nil }
+func (this *countingConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type countingStmt struct {
+	driver *countingDriver
+	query  string
+}
+
+func (this *countingStmt) Close() error {
+	atomic.AddInt32(&this.driver.closes, 1)
+	return nil
+}
+
+func (this *countingStmt) NumInput() int { return -1 }
+
+func (this *countingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if this.query == "lost" && atomic.CompareAndSwapInt32(&this.driver.lost, 0, 1) {
+		return nil, &mysql.MySQLError{Number: mysqlErrUnknownStmtHandler, Message: "Unknown prepared statement handler"}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (this *countingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string              { return nil }
+func (emptyRows) Close() error                   { return nil }
+func (emptyRows) Next(dest []driver.Value) error { return io.EOF }
+
+func TestStmtCache(t *testing.T) {
+	driverState := &countingDriver{}
+	db := sql.OpenDB(driverState)
+	db.SetMaxOpenConns(1)
+	metrics := NewMetrics()
+	shard := newShardDB(3, "fake", sqlx.NewDb(db, "mysql"), MysqlConfig{StmtCacheSize: 2, Metrics: metrics}, NopLogger())
+	ctx := context.Background()
+
+	for _, query := range []string{"a", "b", "a", "c", "a"} {
+		if _, err := shard.ExecContext(ctx, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	/** a b 未命中，a 命中，c 未命中并淘汰 b，a 命中 **/
+	stmts := shard.stmts
+	if stmts.hits != 2 || stmts.misses != 3 || stmts.evictions != 1 || stmts.lru.Len() != 2 {
+		t.Errorf("unexpected hits %d misses %d evictions %d size %d", stmts.hits, stmts.misses, stmts.evictions, stmts.lru.Len())
+	}
+	if prepares := atomic.LoadInt32(&driverState.prepares); prepares != 3 {
+		t.Errorf("aspect 3 prepares, but get %d", prepares)
+	}
+
+	/** 语句失效后重新prepare并重试 **/
+	if _, err := shard.ExecContext(ctx, "lost"); err != nil {
+		t.Fatalf("aspect retry after lost statement, but get %v", err)
+	}
+	if prepares := atomic.LoadInt32(&driverState.prepares); prepares != 5 {
+		t.Errorf("aspect 5 prepares, but get %d", prepares)
+	}
+
+	var rows []struct{}
+	if err := shard.SelectContext(ctx, &rows, "a"); err != nil {
+		t.Fatal(err)
+	}
+	samples := shard.statsSamples()
+	found := false
+	for _, sample := range samples {
+		if sample.name == "godam_mysql_stmt_cache_hits_total" {
+			found = sample.value == float64(stmts.hits)
+		}
+	}
+	if !found {
+		t.Error("aspect stmt cache hit samples")
+	}
+
+	if err := shard.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stmts.lru.Len() != 0 {
+		t.Errorf("aspect empty cache after close, but get %d", stmts.lru.Len())
+	}
+}
+
+func TestIsStmtLost(t *testing.T) {
+	if !isStmtLost(&mysql.MySQLError{Number: mysqlErrUnknownStmtHandler}) {
+		t.Error("aspect 1243 to be lost statement")
+	}
+	if isStmtLost(&mysql.MySQLError{Number: 1062}) || isStmtLost(errors.New("x")) {
+		t.Error("aspect other errors not to be lost statement")
+	}
+}
@@ -0,0 +1,144 @@
+package dam
+
+/**
+ * 链路追踪适配
+ * ITracer/ISpan 与 OpenTelemetry 的 trace.Tracer/trace.Span 形式一致，接入方只需做一层薄包装。
+ * TracingHook 同时实现 IQueryHook 与 redis.Hook：
+ *   MysqlConfig.Hooks = []IQueryHook{hook}
+ *   RedisConfig.Hooks = []redis.Hook{hook}
+ * span 经 context 传递，调用方传入带父 span 的 context 即可串联：
+ *   mysql 使用 ShardDB/ShardTx 的 XxxContext 方法
+ *   redis 使用 Redis().WithContext(ctx).Get(key)
+ */
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+type ITracer interface {
+	Start(ctx context.Context, spanName string, attributes ...SpanAttribute) (context.Context, ISpan)
+}
+
+type ISpan interface {
+	SetAttributes(attributes ...SpanAttribute)
+	RecordError(err error)
+	End()
+}
+
+type SpanAttribute struct {
+	Key   string
+	Value interface{}
+}
+
+const (
+	AttributeDbSystem    = "db.system"
+	AttributeDbShard     = "db.shard"
+	AttributeDbOperation = "db.operation"
+	AttributeDbStatement = "db.statement"
+	AttributeDbDuration  = "db.duration_ms"
+)
+
+type tracingSpanKey struct{}
+
+type TracingHook struct {
+	tracer ITracer
+}
+
+func NewTracingHook(tracer ITracer) *TracingHook {
+	return &TracingHook{tracer: tracer}
+}
+
+func (this *TracingHook) start(ctx context.Context, name string, attributes ...SpanAttribute) context.Context {
+	ctx, span := this.tracer.Start(ctx, name, attributes...)
+	return context.WithValue(ctx, tracingSpanKey{}, span)
+}
+
+func (this *TracingHook) end(ctx context.Context, duration time.Duration, err error) {
+	span, ok := ctx.Value(tracingSpanKey{}).(ISpan)
+	if !ok {
+		return
+	}
+	span.SetAttributes(SpanAttribute{Key: AttributeDbDuration, Value: float64(duration) / float64(time.Millisecond)})
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+/**
+ * mysql
+ */
+func (this *TracingHook) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	return this.start(ctx, "mysql "+event.Operation,
+		SpanAttribute{Key: AttributeDbSystem, Value: "mysql"},
+		SpanAttribute{Key: AttributeDbShard, Value: event.ShardId},
+		SpanAttribute{Key: AttributeDbOperation, Value: event.Operation},
+		SpanAttribute{Key: AttributeDbStatement, Value: event.Fingerprint()},
+	)
+}
+
+func (this *TracingHook) AfterQuery(ctx context.Context, event *QueryEvent) {
+	this.end(ctx, event.Duration, event.Err)
+}
+
+type tracingStartKey struct{}
+
+/**
+ * redis
+ */
+func (this *TracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx = this.start(ctx, "redis "+cmd.Name(),
+		SpanAttribute{Key: AttributeDbSystem, Value: "redis"},
+		SpanAttribute{Key: AttributeDbOperation, Value: cmd.Name()},
+		SpanAttribute{Key: AttributeDbStatement, Value: redisFingerprint(cmd)},
+	)
+	return context.WithValue(ctx, tracingStartKey{}, time.Now()), nil
+}
+
+func (this *TracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	start, _ := ctx.Value(tracingStartKey{}).(time.Time)
+	this.end(ctx, time.Since(start), redisCommandError(cmd))
+	return nil
+}
+
+func (this *TracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	statements := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		statements = append(statements, redisFingerprint(cmd))
+	}
+	ctx = this.start(ctx, "redis pipeline",
+		SpanAttribute{Key: AttributeDbSystem, Value: "redis"},
+		SpanAttribute{Key: AttributeDbOperation, Value: "pipeline"},
+		SpanAttribute{Key: AttributeDbStatement, Value: strings.Join(statements, "\n")},
+	)
+	return context.WithValue(ctx, tracingStartKey{}, time.Now()), nil
+}
+
+func (this *TracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	start, _ := ctx.Value(tracingStartKey{}).(time.Time)
+	var err error
+	for _, cmd := range cmds {
+		if err = redisCommandError(cmd); err != nil {
+			break
+		}
+	}
+	this.end(ctx, time.Since(start), err)
+	return nil
+}
+
+/**
+ * redis命令指纹：命令名 + 参数个数个 ?
+ */
+func redisFingerprint(cmd redis.Cmder) string {
+	args := cmd.Args()
+	var b strings.Builder
+	b.WriteString(cmd.Name())
+	for i := 1; i < len(args); i++ {
+		b.WriteString(" ?")
+	}
+	return b.String()
+}
@@ -0,0 +1,62 @@
+package dam
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+type parentKey struct{}
+
+/**
+ * 记录 span 名称与其父 context 中的 parentKey
+ */
+type recordTracer struct {
+	mutex sync.Mutex
+	spans []string
+}
+
+func (this *recordTracer) Start(ctx context.Context, spanName string, attributes ...SpanAttribute) (context.Context, ISpan) {
+	parent, _ := ctx.Value(parentKey{}).(string)
+	this.mutex.Lock()
+	this.spans = append(this.spans, spanName+" <- "+parent)
+	this.mutex.Unlock()
+	return ctx, recordSpan{}
+}
+
+type recordSpan struct{}
+
+func (recordSpan) SetAttributes(attributes ...SpanAttribute) {}
+func (recordSpan) RecordError(err error)                     {}
+func (recordSpan) End()                                      {}
+
+func TestTracingHookRedisWithContext(t *testing.T) {
+	tracer := &recordTracer{}
+	manager := NewRedisManager(RedisConfig{
+		Host:        "127.0.0.1:1",
+		IdleTimeout: time.Second,
+		Hooks:       []redis.Hook{NewTracingHook(tracer)},
+		Logger:      NopLogger(),
+	})
+	defer manager.UniversalClient().Close()
+	/** 连接失败不影响钩子回调 **/
+	manager.Get("key")
+	manager.WithContext(context.WithValue(context.Background(), parentKey{}, "request")).Get("key")
+	manager.Get("key")
+	want := []string{"redis get <- ", "redis get <- request", "redis get <- "}
+	if len(tracer.spans) != len(want) {
+		t.Fatalf("aspect %v, but get %v", want, tracer.spans)
+	}
+	for i := range want {
+		if tracer.spans[i] != want[i] {
+			t.Errorf("aspect %v, but get %v", want, tracer.spans)
+			break
+		}
+	}
+	if NewMemoryRedisManager().WithContext(context.Background()) == nil {
+		t.Error("aspect memory manager with context")
+	}
+}
@@ -0,0 +1,114 @@
+package dam
+
+/**
+ * insert ... on duplicate key update
+ *   created, err := users.Upsert(ctx, &user, "password", "alias_name")
+ * 冲突时只更新指定列及 update_time/update_user，不指定时更新除主键、分库键、create_time 外的所有列
+ * (包含 delete_time，已软删除的行会被恢复)；配置了版本列时版本号加一
+ */
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+/**
+ * 返回是否为新插入的行；整数主键在冲突时回写为库中已有行的主键
+ */
+func (this *Repository) Upsert(ctx context.Context, entity interface{}, updateColumns ...string) (created bool, err error) {
+	value, err := this.entityValue(entity)
+	if err != nil {
+		return false, err
+	}
+	query, err := this.upsertSQL(updateColumns)
+	if err != nil {
+		return false, err
+	}
+	db, err := this.Db(value.FieldByIndex(this.shardKey.index).Interface())
+	if err != nil {
+		return false, err
+	}
+	id := value.FieldByIndex(this.id.index)
+	if isZero(id) {
+		if err := setGeneratedId(id, this.idGenerator()); err != nil {
+			return false, fmt.Errorf("repository %s: %w", this.config.Table, err)
+		}
+	}
+	this.fillInsertAudit(ctx, value, auditTime())
+
+	result, err := db.ExecContext(ctx, query, this.insertArgs(value)...)
+	if err != nil {
+		return false, err
+	}
+	/** 1: 插入 2: 更新 0: 已存在且无变化 **/
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows != 1 && this.upsertReturnsId() {
+		if existing, err := result.LastInsertId(); err == nil && existing != 0 {
+			setIntValue(id, existing)
+		}
+	}
+	return rows == 1, nil
+}
+
+/**
+ * 整数主键通过 last_insert_id(id) 取回冲突行的主键
+ */
+func (this *Repository) upsertReturnsId() bool {
+	switch this.typ.FieldByIndex(this.id.index).Type.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func (this *Repository) upsertSQL(updateColumns []string) (string, error) {
+	protected := map[string]bool{this.id.name: true, this.shardKey.name: true, columnCreateTime: true}
+	var names []string
+	if len(updateColumns) == 0 {
+		for _, column := range this.columns {
+			if !protected[column.name] {
+				names = append(names, column.name)
+			}
+		}
+	} else {
+		seen := map[string]bool{}
+		for _, name := range updateColumns {
+			if _, ok := this.column(name); !ok {
+				return "", fmt.Errorf("repository %s: upsert column %q not found", this.config.Table, name)
+			}
+			if protected[name] {
+				return "", fmt.Errorf("repository %s: upsert can not update column %q", this.config.Table, name)
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		for _, name := range []string{columnUpdateTime, columnUpdateUser} {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	set := make([]string, 0, len(names)+2)
+	for _, name := range names {
+		if this.version != nil && name == this.version.name {
+			continue
+		}
+		set = append(set, fmt.Sprintf("%s=values(%s)", name, name))
+	}
+	if this.version != nil {
+		set = append(set, fmt.Sprintf("%s=%s+1", this.version.name, this.version.name))
+	}
+	if this.upsertReturnsId() {
+		set = append(set, fmt.Sprintf("%s=last_insert_id(%s)", this.id.name, this.id.name))
+	}
+	return this.insertSQL(1) + " on duplicate key update " + strings.Join(set, ", "), nil
+}
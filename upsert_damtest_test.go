@@ -0,0 +1,34 @@
+package dam_test
+
+import (
+	"context"
+	"testing"
+
+	dam "github.com/seanbit/godam"
+)
+
+func TestRepositoryUpsert(t *testing.T) {
+	_, repository := newUserRepository(t, dam.MysqlConfig{})
+	ctx := context.Background()
+	first := &user{UserId: 1, UserName: "10086", Password: "a", AliasName: "yang"}
+	if created, err := repository.Upsert(ctx, first); err != nil || !created {
+		t.Fatalf("aspect created, but get %v, %v", created, err)
+	}
+
+	/** user_name 冲突，只更新 password，主键回写为已有行的主键 **/
+	second := &user{UserId: 2, UserName: "10086", Password: "b", AliasName: "sean"}
+	created, err := repository.Upsert(ctx, second, "password")
+	if err != nil || created {
+		t.Fatalf("aspect updated on conflict, but get %v, %v", created, err)
+	}
+	if second.UserId != first.UserId {
+		t.Errorf("aspect conflicting id %d written back, but get %d", first.UserId, second.UserId)
+	}
+	var saved user
+	if err := repository.Get(ctx, &saved, first.UserId, first.UserName); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Password != "b" || saved.AliasName != "yang" {
+		t.Errorf("aspect only password updated, but get password %s alias %s", saved.Password, saved.AliasName)
+	}
+}
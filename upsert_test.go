@@ -0,0 +1,49 @@
+package dam
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRepositoryUpsertSQL(t *testing.T) {
+	repository := NewRepository(nil, &User{}, RepositoryConfig{Table: "user", IdColumn: "user_id", ShardKeyColumn: "user_name"})
+	insert := repository.insertSQL(1) + " on duplicate key update "
+
+	query, err := repository.upsertSQL([]string{"password", "alias_name", "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "password=values(password), alias_name=values(alias_name), update_time=values(update_time), " +
+		"update_user=values(update_user), user_id=last_insert_id(user_id)"
+	if query != insert+want {
+		t.Errorf("aspect %s, but get %s", want, strings.TrimPrefix(query, insert))
+	}
+
+	query, _ = repository.upsertSQL(nil)
+	want = "update_time=values(update_time), update_user=values(update_user), delete_time=values(delete_time), " +
+		"password=values(password), alias_name=values(alias_name), enabled=values(enabled), user_id=last_insert_id(user_id)"
+	if query != insert+want {
+		t.Errorf("aspect %s, but get %s", want, strings.TrimPrefix(query, insert))
+	}
+
+	for _, columns := range [][]string{{"user_name"}, {"user_id"}, {"create_time"}, {"unknown"}} {
+		if _, err := repository.upsertSQL(columns); err == nil {
+			t.Errorf("aspect error for update columns %v", columns)
+		}
+	}
+}
+
+func TestRepositoryUpsertSQLVersion(t *testing.T) {
+	repository := NewRepository(nil, versionedUser{}, RepositoryConfig{
+		Table: "user", IdColumn: "user_id", ShardKeyColumn: "user_name", VersionColumn: "version",
+	})
+	query, err := repository.upsertSQL([]string{"enabled", "version"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := " on duplicate key update enabled=values(enabled), update_time=values(update_time), " +
+		"update_user=values(update_user), version=version+1, user_id=last_insert_id(user_id)"
+	if !strings.HasSuffix(query, want) {
+		t.Errorf("aspect suffix %s, but get %s", want, query)
+	}
+}
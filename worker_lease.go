@@ -0,0 +1,193 @@
+package dam
+
+/**
+ * 基于redis的worker id租约
+ * 启动时在 [0, 1023] 中 SetNX 抢占一个空闲id，后台按心跳间隔续期，
+ * Close 时释放；key被他人占用即视为租约丢失，此后拒绝生成id
+ * 距上次成功续期超过 TTL-Heartbeat 时也拒绝生成id，保证redis中的key过期(可能被他人抢占)前已停止使用
+ */
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	defaultWorkerLeasePrefix = "godam:worker:"
+	defaultWorkerLeaseTTL    = 30 * time.Second
+)
+
+var (
+	ErrWorkerLeaseLost      = errors.New("worker id lease not held")
+	ErrNoWorkerIdsAvailable = errors.New("no free worker id to lease")
+)
+
+/** 值等于本实例token时续期 **/
+var workerLeaseRenewScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+
+/** 值等于本实例token时删除 **/
+var workerLeaseReleaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+type WorkerLeaseConfig struct {
+	Redis IRedisManager
+	/** key前缀，默认 godam:worker: **/
+	KeyPrefix string
+	/** 租约有效期，默认30s **/
+	TTL time.Duration
+	/** 续期间隔，默认 TTL/3 **/
+	Heartbeat time.Duration
+}
+
+type workerLease struct {
+	client    redis.Cmdable
+	key       string
+	token     string
+	ttl       time.Duration
+	heartbeat time.Duration
+	workerId  int64
+	lost      int32
+	/** 最近一次成功续期(或抢占)的时间，UnixNano **/
+	renewed     int64
+	stop        chan struct{}
+	done        chan struct{}
+	releaseOnce sync.Once
+	releaseErr  error
+	logger      ILogger
+}
+
+/**
+ * 抢占空闲worker id并开始心跳
+ */
+func acquireWorkerLease(config WorkerLeaseConfig, logger ILogger) (*workerLease, error) {
+	if config.Redis == nil {
+		return nil, errors.New("worker lease: redis is required")
+	}
+	if config.KeyPrefix == "" {
+		config.KeyPrefix = defaultWorkerLeasePrefix
+	}
+	if config.TTL <= 0 {
+		config.TTL = defaultWorkerLeaseTTL
+	}
+	if config.Heartbeat <= 0 || config.Heartbeat >= config.TTL {
+		config.Heartbeat = config.TTL / 3
+	}
+	client := config.Redis.UniversalClient()
+	if client == nil {
+		return nil, errors.New("worker lease: redis client is unavailable")
+	}
+	token := workerLeaseToken()
+	for workerId := int64(0); workerId <= snowIdWorkerMax; workerId++ {
+		key := config.KeyPrefix + strconv.FormatInt(workerId, 10)
+		acquired := timeNow()
+		ok, err := client.SetNX(key, token, config.TTL).Result()
+		if err != nil {
+			return nil, fmt.Errorf("worker lease: %v", err)
+		}
+		if !ok {
+			continue
+		}
+		lease := &workerLease{
+			client:    client,
+			key:       key,
+			token:     token,
+			ttl:       config.TTL,
+			heartbeat: config.Heartbeat,
+			workerId:  workerId,
+			renewed:   acquired.UnixNano(),
+			stop:      make(chan struct{}),
+			done:      make(chan struct{}),
+			logger:    logger.With("worker_id", workerId),
+		}
+		go lease.keepAlive(config.Heartbeat)
+		lease.logger.Info("worker id leased", "key", key, "ttl", config.TTL)
+		return lease, nil
+	}
+	return nil, ErrNoWorkerIdsAvailable
+}
+
+/**
+ * 主机名-进程号-纳秒时间，区分同一id的不同持有者
+ */
+func workerLeaseToken() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+}
+
+/**
+ * 租约未丢失且距上次续期不足 TTL-Heartbeat，每次生成id时检查
+ */
+func (this *workerLease) valid() bool {
+	if atomic.LoadInt32(&this.lost) != 0 {
+		return false
+	}
+	renewed := time.Unix(0, atomic.LoadInt64(&this.renewed))
+	return timeNow().Sub(renewed) < this.ttl-this.heartbeat
+}
+
+func (this *workerLease) keepAlive(interval time.Duration) {
+	defer close(this.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.stop:
+			return
+		case <-ticker.C:
+			/** 以发起续期的时间为准，保守估计key的过期时间 **/
+			start := timeNow()
+			result, err := workerLeaseRenewScript.Run(this.client, []string{this.key}, this.token, this.ttl.Milliseconds()).Int()
+			if err == nil && result == 1 {
+				atomic.StoreInt64(&this.renewed, start.UnixNano())
+				continue
+			}
+			if err == nil {
+				this.markLost("lease taken by another holder")
+				return
+			}
+			this.logger.Warn("worker id lease renew failed", "error", err)
+			if !this.valid() {
+				this.markLost("lease expired")
+				return
+			}
+		}
+	}
+}
+
+func (this *workerLease) markLost(reason string) {
+	atomic.StoreInt32(&this.lost, 1)
+	this.logger.Error("worker id lease lost, id generation disabled", "reason", reason)
+}
+
+/**
+ * 停止心跳并释放租约，可重复调用
+ */
+func (this *workerLease) release() error {
+	this.releaseOnce.Do(func() {
+		close(this.stop)
+		<-this.done
+		if atomic.SwapInt32(&this.lost, 1) != 0 {
+			return
+		}
+		if err := workerLeaseReleaseScript.Run(this.client, []string{this.key}, this.token).Err(); err != nil {
+			this.releaseErr = fmt.Errorf("worker lease release: %v", err)
+			return
+		}
+		this.logger.Info("worker id lease released")
+	})
+	return this.releaseErr
+}
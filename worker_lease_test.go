@@ -0,0 +1,102 @@
+package dam
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextIdLeaseLost(t *testing.T) {
+	lease := &workerLease{workerId: 5, ttl: time.Minute, heartbeat: time.Second, renewed: time.Now().UnixNano(), logger: NopLogger()}
+	snowflake := newSnowflakeIdGenerator(ClockBackwardWait, 0)
+	if err := snowflake.setWorker(lease.workerId, lease); err != nil {
+		t.Fatal(err)
+	}
+	manager := &mysqlManagerImpl{snowflake: snowflake, dbMap: make(map[int]*ShardDB)}
+	id, err := manager.NextId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := DecodeId(id); info.WorkerId != 5 {
+		t.Errorf("aspect worker id 5, but get %d", info.WorkerId)
+	}
+
+	lease.markLost("test")
+	if _, err := manager.NextId(); err != ErrWorkerLeaseLost {
+		t.Errorf("aspect ErrWorkerLeaseLost, but get %v", err)
+	}
+	defer func() {
+		if recover() != ErrWorkerLeaseLost {
+			t.Error("aspect GenerateId panic with ErrWorkerLeaseLost")
+		}
+	}()
+	manager.GenerateId()
+}
+
+func TestNextIdBeforeLease(t *testing.T) {
+	manager := NewMysqlManager(MysqlConfig{WorkerLease: &WorkerLeaseConfig{}, Logger: NopLogger()})
+	if _, err := manager.NextId(); err != ErrWorkerLeaseLost {
+		t.Errorf("aspect ErrWorkerLeaseLost before Open, but get %v", err)
+	}
+}
+
+func TestAcquireWorkerLeaseRedisDown(t *testing.T) {
+	redisManager := NewRedisManager(RedisConfig{Host: "127.0.0.1:1", IdleTimeout: time.Second, Logger: NopLogger()})
+	if _, err := acquireWorkerLease(WorkerLeaseConfig{Redis: redisManager}, NopLogger()); err == nil {
+		t.Error("aspect error when redis is unreachable")
+	}
+	if _, err := acquireWorkerLease(WorkerLeaseConfig{}, NopLogger()); err == nil {
+		t.Error("aspect error without redis")
+	}
+}
+
+func TestWorkerLeaseExpiresBeforeTTL(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return now }
+	lease := &workerLease{ttl: 30 * time.Second, heartbeat: 10 * time.Second, renewed: now.UnixNano(), logger: NopLogger()}
+	if !lease.valid() {
+		t.Error("aspect lease valid right after renew")
+	}
+	now = now.Add(19 * time.Second)
+	if !lease.valid() {
+		t.Error("aspect lease valid within ttl-heartbeat")
+	}
+	/** key 在 30s 时过期，提前一个心跳间隔停止使用 **/
+	now = now.Add(time.Second)
+	if lease.valid() {
+		t.Error("aspect lease invalid once ttl-heartbeat elapsed without renew")
+	}
+}
+
+func TestWorkerLeaseReleaseTwice(t *testing.T) {
+	redisManager := NewRedisManager(RedisConfig{Host: "127.0.0.1:1", IdleTimeout: time.Second, Logger: NopLogger()})
+	lease := &workerLease{
+		client:    redisManager.UniversalClient(),
+		key:       "godam:worker:1",
+		ttl:       time.Minute,
+		heartbeat: time.Hour,
+		renewed:   time.Now().UnixNano(),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		logger:    NopLogger(),
+	}
+	go lease.keepAlive(lease.heartbeat)
+	snowflake := newSnowflakeIdGenerator(ClockBackwardWait, 0)
+	if err := snowflake.setWorker(1, lease); err != nil {
+		t.Fatal(err)
+	}
+	manager := &mysqlManagerImpl{snowflake: snowflake, dbMap: make(map[int]*ShardDB)}
+	/** redis 不可达，释放失败但不影响再次关闭 **/
+	if err := manager.Close(); err == nil {
+		t.Error("aspect release error when redis is unreachable")
+	}
+	if err := manager.Close(); err != nil {
+		t.Errorf("aspect second Close to be a no-op, but get %v", err)
+	}
+	if err := lease.release(); err == nil {
+		t.Error("aspect release to keep reporting the first error")
+	}
+	if _, err := manager.NextId(); err != ErrWorkerLeaseLost {
+		t.Errorf("aspect ErrWorkerLeaseLost after Close, but get %v", err)
+	}
+}